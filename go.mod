@@ -3,7 +3,8 @@ module github.com/stripe/skycfg
 go 1.16
 
 require (
-	go.starlark.net v0.0.0-20201204201740-42d4f566359b
+	github.com/BurntSushi/toml v1.2.1
+	go.starlark.net v0.0.0-20211013185944-b0039bd2cfe3
 	google.golang.org/protobuf v1.25.0
-	gopkg.in/yaml.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
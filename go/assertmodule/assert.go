@@ -42,6 +42,8 @@ func AssertModule() *TestContext {
 	}
 
 	ctx.Attrs["fails"] = starlark.NewBuiltin("assert.fails", ctx.AssertFails)
+	ctx.Attrs["true"] = starlark.NewBuiltin("assert.true", ctx.AssertTrue)
+	ctx.Attrs["contains"] = starlark.NewBuiltin("assert.contains", ctx.AssertContains)
 
 	return ctx
 }
@@ -55,6 +57,12 @@ type assertionError struct {
 	callStack starlark.CallStack
 }
 
+// Position returns the position of the failed assertion in the Starlark
+// source.
+func (err assertionError) Position() syntax.Position {
+	return err.callStack[:len(err.callStack)-1].At(0).Pos
+}
+
 func (err assertionError) Error() string {
 	callStack := err.callStack[:len(err.callStack)-1]
 	position := callStack.At(0).Pos.String()
@@ -194,6 +202,59 @@ func (t *TestContext) AssertFails(thread *starlark.Thread, fn *starlark.Builtin,
 	return nil, err
 }
 
+// AssertTrue is the implementation of assert.true(value, msg?), which fails if
+// the value is not truthy.
+func (t *TestContext) AssertTrue(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var val starlark.Value
+	var msg string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &val, "msg?", &msg); err != nil {
+		return nil, err
+	}
+
+	if !val.Truth() {
+		err := assertionError{
+			msg:       msg,
+			callStack: thread.CallStack(),
+		}
+		t.Failures = append(t.Failures, err)
+		return nil, err
+	}
+
+	return starlark.None, nil
+}
+
+// AssertContains is the implementation of assert.contains(container, value),
+// which fails if the value is not in the container.
+func (t *TestContext) AssertContains(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var container starlark.Value
+	var val starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &container, &val); err != nil {
+		return nil, err
+	}
+
+	contains, err := starlark.Binary(syntax.IN, val, container)
+	if err != nil {
+		return nil, err
+	}
+
+	if !contains.Truth() {
+		err := assertionError{
+			msg: fmt.Sprintf(
+				"%s (type: %s) not in %s (type: %s)",
+				val.String(),
+				val.Type(),
+				container.String(),
+				container.Type(),
+			),
+			callStack: thread.CallStack(),
+		}
+		t.Failures = append(t.Failures, err)
+		return nil, err
+	}
+
+	return starlark.None, nil
+}
+
 var tokenToString = map[syntax.Token]string{
 	syntax.LT:  "lesser",
 	syntax.GT:  "greater",
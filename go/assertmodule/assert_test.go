@@ -300,6 +300,107 @@ func TestAssertFails(t *testing.T) {
 	}
 }
 
+func TestAssertTrue(t *testing.T) {
+	testCases := []assertUnaryTestCase{
+		assertUnaryTestCase{
+			assertTestCaseImpl: assertTestCaseImpl{
+				expFailure: false,
+				expError:   false,
+			},
+			val: `[1]`,
+		},
+		assertUnaryTestCase{
+			assertTestCaseImpl: assertTestCaseImpl{
+				expFailure:    true,
+				expFailureMsg: "assertion failed",
+				expError:      false,
+			},
+			val: `""`,
+		},
+		assertUnaryTestCase{
+			assertTestCaseImpl: assertTestCaseImpl{
+				expFailure:    true,
+				expFailureMsg: "assertion failed: list should not be empty",
+				expError:      false,
+			},
+			val: `[], "list should not be empty"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		cmd := fmt.Sprintf(
+			`t.assert.true(%s)`,
+			testCase.val,
+		)
+
+		evalAndReportResults(t, cmd, testCase)
+	}
+}
+
+func TestAssertContains(t *testing.T) {
+	testCases := []assertUnaryTestCase{
+		assertUnaryTestCase{
+			assertTestCaseImpl: assertTestCaseImpl{
+				expFailure: false,
+				expError:   false,
+			},
+			val: `[1, 2], 2`,
+		},
+		assertUnaryTestCase{
+			assertTestCaseImpl: assertTestCaseImpl{
+				expFailure: false,
+				expError:   false,
+			},
+			val: `"hello", "ell"`,
+		},
+		assertUnaryTestCase{
+			assertTestCaseImpl: assertTestCaseImpl{
+				expFailure:    true,
+				expFailureMsg: `assertion failed: "b" (type: string) not in {"a": 1} (type: dict)`,
+				expError:      false,
+			},
+			val: `{"a": 1}, "b"`,
+		},
+		assertUnaryTestCase{
+			assertTestCaseImpl: assertTestCaseImpl{
+				expFailure:  false,
+				expError:    true,
+				expErrorMsg: "unknown binary op: int in int",
+			},
+			val: `1, 1`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		cmd := fmt.Sprintf(
+			`t.assert.contains(%s)`,
+			testCase.val,
+		)
+
+		evalAndReportResults(t, cmd, testCase)
+	}
+}
+
+func TestAssertionPosition(t *testing.T) {
+	thread := new(starlark.Thread)
+	assertModule := AssertModule()
+	env := starlark.StringDict{
+		"assert": assertModule,
+	}
+
+	_, err := starlark.ExecFile(thread, "test.sky", "\nassert.equal(1, 2)\n", env)
+	if err == nil {
+		t.Fatal("Failing an assertion should return an error, but code completed successfully")
+	}
+	failure, ok := assertModule.Failures[0].(interface{ Position() syntax.Position })
+	if !ok {
+		t.Fatalf("Assertion failure %T has no Position method", assertModule.Failures[0])
+	}
+	if got, want := failure.Position().String(), "test.sky:2:13"; got != want {
+		t.Errorf("Expected assertion failure at %s, found %s", want, got)
+	}
+}
+
 func TestMultipleAssertionErrors(t *testing.T) {
 	thread := new(starlark.Thread)
 	assertModule := AssertModule()
@@ -0,0 +1,96 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package base64module defines a Starlark module of base64 functions.
+package base64module
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// NewModule returns a Starlark module of base64 functions.
+//
+//  base64 = module(
+//    decode,
+//    encode,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "base64",
+		Members: starlark.StringDict{
+			"decode": starlark.NewBuiltin("base64.decode", base64Decode),
+			"encode": starlark.NewBuiltin("base64.encode", base64Encode),
+		},
+	}
+}
+
+func base64Encode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data starlark.Value
+	var urlSafe, noPadding bool
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"data", &data,
+		"url_safe?", &urlSafe,
+		"no_padding?", &noPadding,
+	); err != nil {
+		return nil, err
+	}
+	var raw string
+	switch data := data.(type) {
+	case starlark.String:
+		raw = string(data)
+	case starlark.Bytes:
+		raw = string(data)
+	default:
+		return nil, fmt.Errorf("%s: for parameter data: got %s, want string or bytes", fn.Name(), data.Type())
+	}
+	return starlark.String(encoding(urlSafe, noPadding).EncodeToString([]byte(raw))), nil
+}
+
+func base64Decode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	var urlSafe, noPadding bool
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"data", &data,
+		"url_safe?", &urlSafe,
+		"no_padding?", &noPadding,
+	); err != nil {
+		return nil, err
+	}
+	decoded, err := encoding(urlSafe, noPadding).DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.Bytes(decoded), nil
+}
+
+// encoding returns the base64 alphabet and padding selected by the options.
+func encoding(urlSafe, noPadding bool) *base64.Encoding {
+	switch {
+	case urlSafe && noPadding:
+		return base64.RawURLEncoding
+	case urlSafe:
+		return base64.URLEncoding
+	case noPadding:
+		return base64.RawStdEncoding
+	}
+	return base64.StdEncoding
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package base64module
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestBase64(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"base64": NewModule(),
+	}
+
+	testCases := []struct {
+		expr string
+		want string
+	}{
+		{`base64.encode("hello?")`, `"aGVsbG8/"`},
+		{`base64.encode(b"\xfb\xff")`, `"+/8="`},
+		{`base64.encode(b"\xfb\xff", url_safe = True)`, `"-_8="`},
+		{`base64.encode(b"\xfb\xff", no_padding = True)`, `"+/8"`},
+		{`base64.encode(b"\xfb\xff", url_safe = True, no_padding = True)`, `"-_8"`},
+		{`base64.decode("aGVsbG8/")`, `b"hello?"`},
+		{`base64.decode("-_8=", url_safe = True)`, `b"\xfb\xff"`},
+		{`base64.decode("+/8", no_padding = True)`, `b"\xfb\xff"`},
+		{`base64.decode(base64.encode("secret"))`, `b"secret"`},
+	}
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.expr, err)
+			continue
+		}
+		if got := v.String(); got != testCase.want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", testCase.expr, testCase.want, got)
+		}
+	}
+
+	errorCases := []struct {
+		expr string
+		want string
+	}{
+		{`base64.decode("aGVsbG8")`, "base64.decode: illegal base64 data at input byte 4"},
+		{`base64.decode("-_8=")`, "base64.decode: illegal base64 data at input byte 0"},
+		{`base64.encode(1)`, "base64.encode: for parameter data: got int, want string or bytes"},
+	}
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err == nil || err.Error() != testCase.want {
+			t.Errorf("%s:\nExpected error: %s\nGot           : %v", testCase.expr, testCase.want, err)
+		}
+	}
+}
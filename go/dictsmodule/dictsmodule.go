@@ -0,0 +1,201 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dictsmodule defines a Starlark module of functions for working with
+// dicts.
+package dictsmodule
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// NewModule returns a Starlark module of functions for working with dicts.
+//
+//  dicts = module(
+//    merge,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "dicts",
+		Members: starlark.StringDict{
+			"merge": starlark.NewBuiltin("dicts.merge", dictsMerge),
+		},
+	}
+}
+
+// List strategies for dicts.merge.
+const (
+	listReplace = "replace"
+	listConcat  = "concat"
+)
+
+func dictsMerge(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var base, override *starlark.Dict
+	deep := true
+	listStrategy := listReplace
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"base", &base,
+		"override", &override,
+		"deep?", &deep,
+		"list_strategy?", &listStrategy,
+	); err != nil {
+		return nil, err
+	}
+	if listStrategy != listReplace && listStrategy != listConcat {
+		return nil, fmt.Errorf("%s: list_strategy must be %q or %q, got %q", fn.Name(), listReplace, listConcat, listStrategy)
+	}
+	m := merger{
+		deep:        deep,
+		concatLists: listStrategy == listConcat,
+		merging:     make(map[[2]*starlark.Dict]bool),
+		copying:     make(map[starlark.Value]bool),
+	}
+	merged, err := m.merge(base, override)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return merged, nil
+}
+
+type merger struct {
+	deep        bool
+	concatLists bool
+
+	// The pairs of dicts being merged and the values being copied, so that
+	// a dict or list that contains itself is an error rather than endless
+	// recursion.
+	merging map[[2]*starlark.Dict]bool
+	copying map[starlark.Value]bool
+}
+
+// merge returns a new dict with the entries of base, followed by any new keys
+// of override. Where both have a key, the values are merged by mergeValue.
+func (m merger) merge(base, override *starlark.Dict) (*starlark.Dict, error) {
+	pair := [2]*starlark.Dict{base, override}
+	if m.merging[pair] {
+		return nil, fmt.Errorf("cannot merge a dict that contains itself")
+	}
+	m.merging[pair] = true
+	defer delete(m.merging, pair)
+
+	merged := starlark.NewDict(base.Len() + override.Len())
+	for _, item := range base.Items() {
+		overrideValue, found, err := override.Get(item[0])
+		if err != nil {
+			return nil, err
+		}
+		var value starlark.Value
+		if found {
+			value, err = m.mergeValue(item[1], overrideValue)
+		} else {
+			value, err = m.copyValue(item[1])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := merged.SetKey(item[0], value); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range override.Items() {
+		_, found, err := base.Get(item[0])
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			continue
+		}
+		value, err := m.copyValue(item[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := merged.SetKey(item[0], value); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// mergeValue merges the values of a key found in both dicts. Dicts are merged
+// recursively if deep is set, and lists are concatenated if concatLists is
+// set. Otherwise the override value wins.
+func (m merger) mergeValue(base, override starlark.Value) (starlark.Value, error) {
+	switch base := base.(type) {
+	case *starlark.Dict:
+		if override, ok := override.(*starlark.Dict); ok && m.deep {
+			return m.merge(base, override)
+		}
+	case *starlark.List:
+		if override, ok := override.(*starlark.List); ok && m.concatLists {
+			elems := make([]starlark.Value, 0, base.Len()+override.Len())
+			for _, list := range []*starlark.List{base, override} {
+				for ii := 0; ii < list.Len(); ii++ {
+					elem, err := m.copyValue(list.Index(ii))
+					if err != nil {
+						return nil, err
+					}
+					elems = append(elems, elem)
+				}
+			}
+			return starlark.NewList(elems), nil
+		}
+	}
+	return m.copyValue(override)
+}
+
+// copyValue returns a deep copy of the dicts and lists within v, so the merged
+// dict doesn't share mutable values with its inputs.
+func (m merger) copyValue(v starlark.Value) (starlark.Value, error) {
+	switch v.(type) {
+	case *starlark.Dict, *starlark.List:
+		if m.copying[v] {
+			return nil, fmt.Errorf("cannot copy a %s that contains itself", v.Type())
+		}
+		m.copying[v] = true
+		defer delete(m.copying, v)
+	}
+
+	switch v := v.(type) {
+	case *starlark.Dict:
+		copied := starlark.NewDict(v.Len())
+		for _, item := range v.Items() {
+			value, err := m.copyValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			if err := copied.SetKey(item[0], value); err != nil {
+				return nil, err
+			}
+		}
+		return copied, nil
+	case *starlark.List:
+		elems := make([]starlark.Value, v.Len())
+		for ii := range elems {
+			elem, err := m.copyValue(v.Index(ii))
+			if err != nil {
+				return nil, err
+			}
+			elems[ii] = elem
+		}
+		return starlark.NewList(elems), nil
+	}
+	return v, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package dictsmodule
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestMerge(t *testing.T) {
+	thread := new(starlark.Thread)
+	cyclicDict := starlark.NewDict(1)
+	cyclicDict.SetKey(starlark.String("self"), cyclicDict)
+	cyclicList := starlark.NewList(nil)
+	cyclicList.Append(cyclicList)
+	env := starlark.StringDict{
+		"dicts":       NewModule(),
+		"cyclic_dict": cyclicDict,
+		"cyclic_list": cyclicList,
+	}
+
+	testCases := []struct {
+		expr string
+		want string
+	}{
+		{`dicts.merge({}, {})`, `{}`},
+		{`dicts.merge({"a": 1, "b": 2}, {"b": 3, "c": 4})`, `{"a": 1, "b": 3, "c": 4}`},
+		{
+			`dicts.merge({"spec": {"replicas": 1, "image": "web:1"}}, {"spec": {"replicas": 3}})`,
+			`{"spec": {"replicas": 3, "image": "web:1"}}`,
+		},
+		{
+			`dicts.merge(
+				{"a": {"b": {"c": {"d": 1, "e": 2}, "f": 3}}, "g": 4},
+				{"a": {"b": {"c": {"e": 5, "h": 6}}}, "i": 7},
+			)`,
+			`{"a": {"b": {"c": {"d": 1, "e": 5, "h": 6}, "f": 3}}, "g": 4, "i": 7}`,
+		},
+		{
+			`dicts.merge({"spec": {"replicas": 1}}, {"spec": {"image": "web:2"}}, deep = False)`,
+			`{"spec": {"image": "web:2"}}`,
+		},
+		// Collisions between a dict and another type take the override.
+		{`dicts.merge({"a": {"b": 1}}, {"a": None})`, `{"a": None}`},
+		{`dicts.merge({"a": 1}, {"a": {"b": 1}})`, `{"a": {"b": 1}}`},
+		{`dicts.merge({"a": [1]}, {"a": {"b": 1}}, list_strategy = "concat")`, `{"a": {"b": 1}}`},
+		// Lists are replaced by default, or concatenated.
+		{`dicts.merge({"args": ["-v"]}, {"args": ["-q"]})`, `{"args": ["-q"]}`},
+		{
+			`dicts.merge({"args": ["-v"], "env": {"PATH": ["/bin"]}}, {"args": ["-q"], "env": {"PATH": ["/usr/bin"]}}, list_strategy = "concat")`,
+			`{"args": ["-v", "-q"], "env": {"PATH": ["/bin", "/usr/bin"]}}`,
+		},
+		{`dicts.merge({"a": [1]}, {"a": (2,)}, list_strategy = "concat")`, `{"a": (2,)}`},
+		{`dicts.merge({1: "a", (2, 3): "b"}, {(2, 3): "c"})`, `{1: "a", (2, 3): "c"}`},
+		// A dict may be merged with a dict that contains it.
+		{`[dicts.merge(d, {"k": d}) for d in [{"k": 1}]][0]`, `{"k": {"k": 1}}`},
+	}
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.expr, err)
+			continue
+		}
+		if got := v.String(); got != testCase.want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", testCase.expr, testCase.want, got)
+		}
+	}
+
+	errorCases := []struct {
+		expr    string
+		wantErr string
+	}{
+		{`dicts.merge({}, [])`, `dicts.merge: for parameter override: got list, want dict`},
+		{`dicts.merge({}, {}, list_strategy = "append")`, `dicts.merge: list_strategy must be "replace" or "concat", got "append"`},
+		{`dicts.merge(cyclic_dict, {})`, `dicts.merge: cannot copy a dict that contains itself`},
+		{`dicts.merge(cyclic_dict, cyclic_dict)`, `dicts.merge: cannot merge a dict that contains itself`},
+		{`dicts.merge({}, {"a": cyclic_list})`, `dicts.merge: cannot copy a list that contains itself`},
+	}
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err == nil || err.Error() != testCase.wantErr {
+			t.Errorf("%s:\nExpected error: %s\nGot           : %v", testCase.expr, testCase.wantErr, err)
+		}
+	}
+}
+
+func TestMergeCopies(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"dicts": NewModule(),
+	}
+	globals, err := starlark.ExecFile(thread, "<test>", `
+base = {"spec": {"ports": [80], "labels": {"app": "web"}}, "tags": ["a"]}
+override = {"spec": {"ports": [443]}, "extra": {"x": [1]}}
+merged = dicts.merge(base, override, list_strategy = "concat")
+merged["spec"]["ports"].append(8080)
+merged["spec"]["labels"]["tier"] = "frontend"
+merged["tags"].append("b")
+merged["extra"]["x"].append(2)
+`, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"base":     `{"spec": {"ports": [80], "labels": {"app": "web"}}, "tags": ["a"]}`,
+		"override": `{"spec": {"ports": [443]}, "extra": {"x": [1]}}`,
+		"merged":   `{"spec": {"ports": [80, 443, 8080], "labels": {"app": "web", "tier": "frontend"}}, "tags": ["a", "b"], "extra": {"x": [1, 2]}}`,
+	}
+	for name, want := range want {
+		if got := globals[name].String(); got != want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", name, want, got)
+		}
+	}
+
+	// Frozen inputs, as from a loaded module, produce a mutable result.
+	thread = new(starlark.Thread)
+	base := starlark.NewDict(1)
+	base.SetKey(starlark.String("a"), starlark.NewList([]starlark.Value{starlark.MakeInt(1)}))
+	base.Freeze()
+	env["base"] = base
+	if _, err := starlark.ExecFile(thread, "<test>", `dicts.merge(base, {})["a"].append(2)`, env); err != nil {
+		t.Errorf("frozen input: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package envmodule defines a Starlark module for reading environment
+// variables supplied by the program embedding Skycfg.
+package envmodule
+
+import (
+	"fmt"
+	"sort"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// NewModule returns a Starlark module for reading the given environment
+// variables. The module never reads the environment of the process, so the
+// variables visible to a config are exactly those passed in.
+//
+//  env = module(
+//    get,
+//    keys,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule(vars map[string]string) *starlarkstruct.Module {
+	copied := make(map[string]string, len(vars))
+	for key, value := range vars {
+		copied[key] = value
+	}
+	return &starlarkstruct.Module{
+		Name: "env",
+		Members: starlark.StringDict{
+			"get":  starlark.NewBuiltin("env.get", envGet(copied)),
+			"keys": starlark.NewBuiltin("env.keys", envKeys(copied)),
+		},
+	}
+}
+
+func envGet(vars map[string]string) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var def starlark.Value = starlark.None
+		var required bool
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+			"name", &name,
+			"default?", &def,
+			"required?", &required,
+		); err != nil {
+			return nil, err
+		}
+		if value, ok := vars[name]; ok {
+			return starlark.String(value), nil
+		}
+		if required {
+			return nil, fmt.Errorf("%s: environment variable %q is not set", fn.Name(), name)
+		}
+		return def, nil
+	}
+}
+
+func envKeys(vars map[string]string) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(vars))
+		for key := range vars {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]starlark.Value, len(keys))
+		for ii, key := range keys {
+			values[ii] = starlark.String(key)
+		}
+		return starlark.NewList(values), nil
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package envmodule
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestEnv(t *testing.T) {
+	thread := new(starlark.Thread)
+	vars := map[string]string{
+		"CLUSTER": "ord1",
+		"EMPTY":   "",
+	}
+	env := starlark.StringDict{
+		"env": NewModule(vars),
+	}
+	// The module keeps its own copy of the variables.
+	vars["CLUSTER"] = "changed"
+
+	testCases := []struct {
+		expr string
+		want string
+	}{
+		{`env.get("CLUSTER")`, `"ord1"`},
+		{`env.get("CLUSTER", required = True)`, `"ord1"`},
+		{`env.get("EMPTY", required = True)`, `""`},
+		{`env.get("MISSING")`, `None`},
+		{`env.get("MISSING", "default")`, `"default"`},
+		{`env.get("MISSING", default = 1)`, `1`},
+		{`env.keys()`, `["CLUSTER", "EMPTY"]`},
+	}
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.expr, err)
+			continue
+		}
+		if got := v.String(); got != testCase.want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", testCase.expr, testCase.want, got)
+		}
+	}
+
+	_, err := starlark.Eval(thread, "<expr>", `env.get("MISSING", required = True)`, env)
+	wantErr := `env.get: environment variable "MISSING" is not set`
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Bad error from env.get\nExpected: %s\nGot     : %v", wantErr, err)
+	}
+
+	// Without variables, every lookup misses.
+	v, err := starlark.Eval(thread, "<expr>", `env.keys()`, starlark.StringDict{"env": NewModule(nil)})
+	if err != nil || v.String() != `[]` {
+		t.Errorf("empty env.keys(): got %v, %v", v, err)
+	}
+}
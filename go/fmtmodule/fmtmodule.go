@@ -0,0 +1,164 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package fmtmodule defines a Starlark module of string formatting functions.
+package fmtmodule
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// NewModule returns a Starlark module of string formatting functions.
+//
+//  fmt = module(
+//    sprintf,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "fmt",
+		Members: starlark.StringDict{
+			"sprintf": starlark.NewBuiltin("fmt.sprintf", fmtSprintf),
+		},
+	}
+}
+
+func fmtSprintf(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword argument %s", fn.Name(), kwargs[0][0])
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing argument for format", fn.Name())
+	}
+	format, ok := starlark.AsString(args[0])
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter format: got %s, want string", fn.Name(), args[0].Type())
+	}
+	out, err := sprintf(format, args[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.String(out), nil
+}
+
+// verbs are the formatting verbs supported by sprintf.
+const verbs = "vsqdobxXeEfFgG"
+
+// sprintf formats args according to format, checking that each argument has
+// a type the verb accepts.
+func sprintf(format string, args starlark.Tuple) (string, error) {
+	var buf strings.Builder
+	next := 0
+	for ii := 0; ii < len(format); ii++ {
+		if format[ii] != '%' {
+			buf.WriteByte(format[ii])
+			continue
+		}
+		// A directive is %[flags][width][.precision]verb.
+		start := ii
+		ii++
+		for ii < len(format) && strings.IndexByte("-+# 0", format[ii]) >= 0 {
+			ii++
+		}
+		for ii < len(format) && isDigit(format[ii]) {
+			ii++
+		}
+		if ii < len(format) && format[ii] == '.' {
+			ii++
+			for ii < len(format) && isDigit(format[ii]) {
+				ii++
+			}
+		}
+		if ii == len(format) {
+			return "", fmt.Errorf("incomplete directive %q at end of format", format[start:])
+		}
+		directive := format[start : ii+1]
+		verb := format[ii]
+		if verb == '%' {
+			if directive != "%%" {
+				return "", fmt.Errorf("invalid directive %q", directive)
+			}
+			buf.WriteByte('%')
+			continue
+		}
+		if strings.IndexByte(verbs, verb) < 0 {
+			return "", fmt.Errorf("unsupported verb %%%c", verb)
+		}
+		if next == len(args) {
+			return "", fmt.Errorf("missing argument for %s", directive)
+		}
+		arg, err := formatArg(verb, args[next])
+		if err != nil {
+			return "", fmt.Errorf("argument %d for %s: %v", next+1, directive, err)
+		}
+		next++
+		fmt.Fprintf(&buf, directive, arg)
+	}
+	if next < len(args) {
+		return "", fmt.Errorf("got %d arguments, but format uses %d", len(args), next)
+	}
+	return buf.String(), nil
+}
+
+// formatArg converts a Starlark value to the Go value formatted by a verb, or
+// returns an error if the verb doesn't accept the value's type.
+func formatArg(verb byte, v starlark.Value) (interface{}, error) {
+	switch verb {
+	case 'v':
+		if s, ok := v.(starlark.String); ok {
+			return string(s), nil
+		}
+		return v.String(), nil
+	case 's', 'q':
+		if s, ok := v.(starlark.String); ok {
+			return string(s), nil
+		}
+		return nil, fmt.Errorf("got %s, want string", v.Type())
+	case 'd', 'o', 'b':
+		if i, ok := v.(starlark.Int); ok {
+			return i.BigInt(), nil
+		}
+		return nil, fmt.Errorf("got %s, want int", v.Type())
+	case 'x', 'X':
+		switch v := v.(type) {
+		case starlark.Int:
+			return v.BigInt(), nil
+		case starlark.String:
+			return string(v), nil
+		case starlark.Bytes:
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("got %s, want int, string, or bytes", v.Type())
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		switch v := v.(type) {
+		case starlark.Float:
+			return float64(v), nil
+		case starlark.Int:
+			return float64(v.Float()), nil
+		}
+		return nil, fmt.Errorf("got %s, want float or int", v.Type())
+	}
+	panic(fmt.Sprintf("formatArg: unexpected verb %q", verb))
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fmtmodule
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestSprintf(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"fmt": NewModule(),
+	}
+
+	testCases := []struct {
+		expr string
+		want string
+	}{
+		{`fmt.sprintf("%s-%s", "web", "canary")`, `"web-canary"`},
+		{`fmt.sprintf("replicas=%d", 3)`, `"replicas=3"`},
+		{`fmt.sprintf("%05d|%-4d|%+d", 42, 7, 1)`, `"00042|7   |+1"`},
+		{`fmt.sprintf("%d", 123456789012345678901234567890)`, `"123456789012345678901234567890"`},
+		{`fmt.sprintf("%x %X %o %b", 255, 255, 8, 5)`, `"ff FF 10 101"`},
+		{`fmt.sprintf("%x %x", "hi", b"\x01")`, `"6869 01"`},
+		{`fmt.sprintf("%.2f %.1e %g", 3.14159, 1234.5, 2)`, `"3.14 1.2e+03 2"`},
+		{`fmt.sprintf("%8.3s|", "abcdef")`, `"     abc|"`},
+		{`fmt.sprintf("%q", "a\"b")`, `"\"a\\\"b\""`},
+		{`fmt.sprintf("%v %v %v %v", "s", 1, [1, "a"], None)`, `"s 1 [1, \"a\"] None"`},
+		{`fmt.sprintf("%6v|", True)`, `"  True|"`},
+		{`fmt.sprintf("100%%")`, `"100%"`},
+		{`fmt.sprintf("no directives")`, `"no directives"`},
+	}
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.expr, err)
+			continue
+		}
+		if got := v.String(); got != testCase.want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", testCase.expr, testCase.want, got)
+		}
+	}
+
+	errorCases := []struct {
+		expr    string
+		wantErr string
+	}{
+		{`fmt.sprintf("%d", "3")`, `fmt.sprintf: argument 1 for %d: got string, want int`},
+		{`fmt.sprintf("%s %s", "a", 1)`, `fmt.sprintf: argument 2 for %s: got int, want string`},
+		{`fmt.sprintf("%d", True)`, `fmt.sprintf: argument 1 for %d: got bool, want int`},
+		{`fmt.sprintf("%.2f", "1.5")`, `fmt.sprintf: argument 1 for %.2f: got string, want float or int`},
+		{`fmt.sprintf("%s %s", "a")`, `fmt.sprintf: missing argument for %s`},
+		{`fmt.sprintf("%s", "a", "b")`, `fmt.sprintf: got 2 arguments, but format uses 1`},
+		{`fmt.sprintf("%T", 1)`, `fmt.sprintf: unsupported verb %T`},
+		{`fmt.sprintf("50%")`, `fmt.sprintf: incomplete directive "%" at end of format`},
+		{`fmt.sprintf("%5%")`, `fmt.sprintf: invalid directive "%5%"`},
+		{`fmt.sprintf(1)`, `fmt.sprintf: for parameter format: got int, want string`},
+		{`fmt.sprintf()`, `fmt.sprintf: missing argument for format`},
+		{`fmt.sprintf("%s", x = 1)`, `fmt.sprintf: unexpected keyword argument "x"`},
+	}
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err == nil || err.Error() != testCase.wantErr {
+			t.Errorf("%s:\nExpected error: %s\nGot           : %v", testCase.expr, testCase.wantErr, err)
+		}
+	}
+}
@@ -26,6 +26,8 @@ import (
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+
+	"github.com/stripe/skycfg/go/jsonmodule"
 )
 
 // NewModule returns a Starlark module of common hash functions.
@@ -50,13 +52,31 @@ func NewModule() *starlarkstruct.Module {
 
 func fnHash(hash func() hash.Hash) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
 	return func(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-		var s starlark.String
-		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &s); err != nil {
+		var v starlark.Value
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &v); err != nil {
 			return nil, err
 		}
 
+		data, err := hashInput(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+		}
+
 		h := hash()
-		h.Write([]byte(string(s)))
+		h.Write(data)
 		return starlark.String(fmt.Sprintf("%x", h.Sum(nil))), nil
 	}
 }
+
+// hashInput returns the bytes that are hashed for a value. Strings and bytes
+// are hashed as-is. Any other value is hashed by its canonical JSON encoding,
+// which sorts the keys of every dict so that equal values hash identically.
+func hashInput(v starlark.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case starlark.String:
+		return []byte(string(v)), nil
+	case starlark.Bytes:
+		return []byte(string(v)), nil
+	}
+	return jsonmodule.Marshal(v)
+}
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
 )
 
 type hashTestCase struct {
@@ -71,3 +72,55 @@ func TestHashes(t *testing.T) {
 		}
 	}
 }
+
+func TestHashValues(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"hash":   NewModule(),
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	}
+
+	testCases := []struct {
+		expr string
+		want string
+	}{
+		// Bytes hash their contents, the same as strings.
+		{`hash.sha256(b"test sha256 string")`, `a9c78816353b119a0ba2a1281675b147fd47abee11a8d41d5abb739dce8273b7`},
+		// Other values hash their canonical JSON encoding.
+		{`hash.md5({"b": [1, 2], "a": True}) == hash.md5('{"a":true,"b":[1,2]}')`, `True`},
+		{`hash.sha1({"a": 1, "b": 2}) == hash.sha1({"b": 2, "a": 1})`, `True`},
+		{`hash.sha256({"x": {"c": 1, "d": 2}}) == hash.sha256({"x": {"d": 2, "c": 1}})`, `True`},
+		{`hash.sha256(struct(a = 1, b = "x")) == hash.sha256({"b": "x", "a": 1})`, `True`},
+		{`hash.sha256([1, 2]) == hash.sha256([2, 1])`, `False`},
+		{`hash.sha256(1) == hash.sha256("1")`, `True`},
+	}
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.expr, err)
+			continue
+		}
+		got := v.String()
+		if s, ok := v.(starlark.String); ok {
+			got = string(s)
+		}
+		if got != testCase.want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", testCase.expr, testCase.want, got)
+		}
+	}
+
+	_, err := starlark.Eval(thread, "<expr>", `hash.sha256({1: "a"})`, env)
+	wantErr := "hash.sha256: int64 (1) is not a supported key type"
+	if err == nil || err.Error() != wantErr {
+		t.Error("Bad error from hash.sha256", "\nExpected", wantErr, "\nGot", err)
+	}
+
+	cyclic := starlark.NewList(nil)
+	cyclic.Append(cyclic)
+	env["cyclic"] = cyclic
+	_, err = starlark.Eval(thread, "<expr>", `hash.sha256(cyclic)`, env)
+	wantErr = "hash.sha256: ValueError: value [[...]] (type `list') contains itself and can't be converted to JSON."
+	if err == nil || err.Error() != wantErr {
+		t.Error("Bad error from hash.sha256", "\nExpected", wantErr, "\nGot", err)
+	}
+}
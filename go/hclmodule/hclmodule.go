@@ -0,0 +1,245 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package hclmodule defines a Starlark module of HCL-related functions.
+package hclmodule
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/stripe/skycfg/go/yamlmodule"
+)
+
+// NewModule returns a Starlark module of HCL-related functions.
+//
+//  hcl = module(
+//    encode,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "hcl",
+		Members: starlark.StringDict{
+			"encode": starlark.NewBuiltin("hcl.encode", hclEncode),
+		},
+	}
+}
+
+func hclEncode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &v); err != nil {
+		return nil, err
+	}
+	inflated, err := yamlmodule.FromStarlark(v, "HCL")
+	if err != nil {
+		return nil, err
+	}
+	body, ok := inflated.(yamlmodule.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter value: got %s, want dict or struct", fn.Name(), v.Type())
+	}
+	var buf bytes.Buffer
+	if err := writeBody(&buf, body, 0); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.String(buf.String()), nil
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// writeBody writes the items of a mapping as the body of a file or block.
+// Mappings, and lists containing only mappings, are written as blocks. All
+// other values are written as attributes, with the "=" of consecutive
+// attributes aligned.
+func writeBody(buf *bytes.Buffer, body yamlmodule.MapSlice, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	wrote := false
+	var attrs []yamlmodule.MapItem
+	flushAttrs := func() error {
+		width := 0
+		for _, attr := range attrs {
+			if n := len(attr.Key.(string)); n > width {
+				width = n
+			}
+		}
+		for _, attr := range attrs {
+			name := attr.Key.(string)
+			fmt.Fprintf(buf, "%s%s%s = ", indent, name, strings.Repeat(" ", width-len(name)))
+			if err := writeExpr(buf, attr.Value, depth); err != nil {
+				return fmt.Errorf("attribute %q: %v", name, err)
+			}
+			buf.WriteByte('\n')
+			wrote = true
+		}
+		attrs = nil
+		return nil
+	}
+
+	for _, item := range body {
+		name, ok := item.Key.(string)
+		if !ok {
+			return fmt.Errorf("%s (%v) is not a supported key type", reflect.TypeOf(item.Key).Kind(), item.Key)
+		}
+		if !identifierPattern.MatchString(name) {
+			return fmt.Errorf("key %q is not a valid HCL identifier", name)
+		}
+		blocks, isBlock := asBlocks(item.Value)
+		if !isBlock {
+			attrs = append(attrs, item)
+			continue
+		}
+		if err := flushAttrs(); err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			// Separate each block from the item before it.
+			if wrote {
+				buf.WriteByte('\n')
+			}
+			fmt.Fprintf(buf, "%s%s {\n", indent, name)
+			if err := writeBody(buf, block, depth+1); err != nil {
+				return fmt.Errorf("block %q: %v", name, err)
+			}
+			fmt.Fprintf(buf, "%s}\n", indent)
+			wrote = true
+		}
+	}
+	return flushAttrs()
+}
+
+// asBlocks reports whether a value is written as one or more blocks, and
+// returns the body of each block.
+func asBlocks(value interface{}) ([]yamlmodule.MapSlice, bool) {
+	switch value := value.(type) {
+	case yamlmodule.MapSlice:
+		return []yamlmodule.MapSlice{value}, true
+	case []interface{}:
+		if len(value) == 0 {
+			return nil, false
+		}
+		blocks := make([]yamlmodule.MapSlice, len(value))
+		for i, element := range value {
+			block, ok := element.(yamlmodule.MapSlice)
+			if !ok {
+				return nil, false
+			}
+			blocks[i] = block
+		}
+		return blocks, true
+	}
+	return nil, false
+}
+
+// writeExpr writes a value as an HCL expression. Mappings that are not
+// written as blocks, such as those within a list of mixed values, are written
+// as object expressions.
+func writeExpr(buf *bytes.Buffer, obj interface{}, depth int) error {
+	switch obj := obj.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if obj {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case int, int64, uint64:
+		fmt.Fprint(buf, obj)
+	case *big.Int:
+		buf.WriteString(obj.String())
+	case float64:
+		if math.IsInf(obj, 0) || math.IsNaN(obj) {
+			return fmt.Errorf("cannot encode non-finite float %v", starlark.Float(obj))
+		}
+		buf.WriteString(starlark.Float(obj).String())
+	case string:
+		writeString(buf, obj)
+	case []byte:
+		writeString(buf, base64.StdEncoding.EncodeToString(obj))
+	case time.Time:
+		writeString(buf, obj.Format(time.RFC3339Nano))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, element := range obj {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := writeExpr(buf, element, depth); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yamlmodule.MapSlice:
+		if len(obj) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		indent := strings.Repeat("  ", depth+1)
+		buf.WriteString("{\n")
+		for _, item := range obj {
+			name, ok := item.Key.(string)
+			if !ok {
+				return fmt.Errorf("%s (%v) is not a supported key type", reflect.TypeOf(item.Key).Kind(), item.Key)
+			}
+			buf.WriteString(indent)
+			if identifierPattern.MatchString(name) {
+				buf.WriteString(name)
+			} else {
+				writeString(buf, name)
+			}
+			buf.WriteString(" = ")
+			if err := writeExpr(buf, item.Value, depth+1); err != nil {
+				return err
+			}
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(buf, "%s}", strings.Repeat("  ", depth))
+	default:
+		return fmt.Errorf("%s (%v) is not a supported type", reflect.TypeOf(obj).Kind(), obj)
+	}
+	return nil
+}
+
+// stringEscaper escapes a string for an HCL quoted template, including the
+// "${" and "%{" sequences that would otherwise begin an interpolation or
+// directive.
+var stringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", "$${",
+	"%{", "%%{",
+)
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	stringEscaper.WriteString(buf, s)
+	buf.WriteByte('"')
+}
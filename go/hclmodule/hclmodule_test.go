@@ -0,0 +1,141 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hclmodule
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+type HclTestCase struct {
+	skyExpr   string
+	expOutput string
+}
+
+func TestSkyToHcl(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"hcl":    NewModule(),
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	}
+
+	testCases := []HclTestCase{
+		{
+			skyExpr: `hcl.encode({"name": "web", "count": 2, "ratio": 0.5, "enabled": True, "zone": None})`,
+			expOutput: strings.Join([]string{
+				`name    = "web"`,
+				`count   = 2`,
+				`ratio   = 0.5`,
+				`enabled = true`,
+				`zone    = null`,
+				``,
+			}, "\n"),
+		},
+		{
+			skyExpr: `hcl.encode({"region": "us-west-2", "provider": {"aws": struct(version = "~> 4.0")}})`,
+			expOutput: strings.Join([]string{
+				`region = "us-west-2"`,
+				``,
+				`provider {`,
+				`  aws {`,
+				`    version = "~> 4.0"`,
+				`  }`,
+				`}`,
+				``,
+			}, "\n"),
+		},
+		{
+			skyExpr: `hcl.encode({"ingress": [{"port": 80}, {"port": 443}], "tags": ["a", "b"], "empty": []})`,
+			expOutput: strings.Join([]string{
+				`ingress {`,
+				`  port = 80`,
+				`}`,
+				``,
+				`ingress {`,
+				`  port = 443`,
+				`}`,
+				`tags  = ["a", "b"]`,
+				`empty = []`,
+				``,
+			}, "\n"),
+		},
+		{
+			skyExpr: `hcl.encode({"values": [1, {"a-b": 1, "c d": "x"}, {}]})`,
+			expOutput: strings.Join([]string{
+				`values = [1, {`,
+				`  a-b = 1`,
+				`  "c d" = "x"`,
+				`}, {}]`,
+				``,
+			}, "\n"),
+		},
+		{
+			skyExpr:   `hcl.encode({"script": "echo \"${HOME}\" %{x}\n"})`,
+			expOutput: `script = "echo \"$${HOME}\" %%{x}\n"` + "\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		if got := string(v.(starlark.String)); got != testCase.expOutput {
+			t.Error(
+				"Bad return value from hcl.encode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", got)
+		}
+	}
+
+	errorCases := []HclTestCase{
+		{
+			skyExpr:   `hcl.encode([1])`,
+			expOutput: "hcl.encode: for parameter value: got list, want dict or struct",
+		},
+		{
+			skyExpr:   `hcl.encode({"server": {"bad key": 1}})`,
+			expOutput: `hcl.encode: block "server": key "bad key" is not a valid HCL identifier`,
+		},
+		{
+			skyExpr:   `hcl.encode({"ratio": float("inf")})`,
+			expOutput: `hcl.encode: attribute "ratio": cannot encode non-finite float +inf`,
+		},
+		{
+			skyExpr:   `hcl.encode({1: 2})`,
+			expOutput: "hcl.encode: int64 (1) is not a supported key type",
+		},
+		{
+			skyExpr:   `hcl.encode({"a": hcl})`,
+			expOutput: "TypeError: value <module \"hcl\"> (type `module') can't be converted to HCL.",
+		},
+	}
+
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err == nil || err.Error() != testCase.expOutput {
+			t.Error(
+				"Bad error from hcl.encode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", err)
+		}
+	}
+}
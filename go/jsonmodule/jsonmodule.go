@@ -0,0 +1,458 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package jsonmodule defines a Starlark module of JSON-related functions.
+package jsonmodule
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/stripe/skycfg/go/yamlmodule"
+)
+
+// NewModule returns a Starlark module of JSON-related functions.
+//
+//  json = module(
+//    canonical,
+//    decode,
+//    decode_lines,
+//    encode,
+//    encode_lines,
+//    indent,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "json",
+		Members: starlark.StringDict{
+			"canonical":    starlark.NewBuiltin("json.canonical", jsonCanonical),
+			"decode":       starlark.NewBuiltin("json.decode", jsonDecode),
+			"decode_lines": starlark.NewBuiltin("json.decode_lines", jsonDecodeLines),
+			"encode":       starlark.NewBuiltin("json.encode", jsonEncode),
+			"encode_lines": starlark.NewBuiltin("json.encode_lines", jsonEncodeLines),
+			"indent":       starlarkjson.Module.Members["indent"],
+		},
+	}
+}
+
+func jsonDecode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var blob string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "blob", &blob); err != nil {
+		return nil, err
+	}
+	inflated, err := decodeDocument(blob)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return yamlmodule.ToStarlark(inflated)
+}
+
+func jsonDecodeLines(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var blob string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "blob", &blob); err != nil {
+		return nil, err
+	}
+	var values []starlark.Value
+	for i, line := range strings.Split(blob, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		inflated, err := decodeDocument(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", fn.Name(), i+1, err)
+		}
+		value, err := yamlmodule.ToStarlark(inflated)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return starlark.NewList(values), nil
+}
+
+// decodeDocument decodes a string containing a single JSON value.
+func decodeDocument(blob string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(blob))
+	decoder.UseNumber()
+	inflated, err := decodeValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	return inflated, nil
+}
+
+// decodeValue reads a single JSON value from the decoder. Objects are
+// decoded as a yamlmodule.MapSlice, so that keys keep the order they were
+// written in.
+func decodeValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '[':
+			slice := []interface{}{}
+			for decoder.More() {
+				element, err := decodeValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				slice = append(slice, element)
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return slice, nil
+		case '{':
+			var items yamlmodule.MapSlice
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, yamlmodule.MapItem{Key: key, Value: value})
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return items, nil
+		}
+		return nil, fmt.Errorf("unexpected delimiter %q", token)
+	case json.Number:
+		return decodeNumber(token)
+	}
+	return token, nil
+}
+
+// decodeNumber converts a JSON number to an integer if it has no fraction or
+// exponent, and to a float otherwise.
+func decodeNumber(n json.Number) (interface{}, error) {
+	if strings.ContainsAny(n.String(), ".eE") {
+		return n.Float64()
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	i, ok := new(big.Int).SetString(n.String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", n)
+	}
+	return i, nil
+}
+
+func jsonEncode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	var indent int
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"value", &v,
+		"indent?", &indent,
+	); err != nil {
+		return nil, err
+	}
+	if indent < 0 {
+		return nil, fmt.Errorf("%s: for parameter indent: got %d, want a non-negative value", fn.Name(), indent)
+	}
+	inflated, err := yamlmodule.FromStarlark(v, "JSON")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, inflated, false); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	if indent == 0 {
+		return starlark.String(buf.String()), nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buf.Bytes(), "", strings.Repeat(" ", indent)); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.String(indented.String()), nil
+}
+
+func jsonEncodeLines(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "values", &v); err != nil {
+		return nil, err
+	}
+	var values starlark.Indexable
+	switch v := v.(type) {
+	case *starlark.List, starlark.Tuple:
+		values = v.(starlark.Indexable)
+	default:
+		return nil, fmt.Errorf("%s: for parameter values: got %s, want list", fn.Name(), v.Type())
+	}
+	var buf bytes.Buffer
+	for i := 0; i < values.Len(); i++ {
+		inflated, err := yamlmodule.FromStarlark(values.Index(i), "JSON")
+		if err != nil {
+			return nil, err
+		}
+		if err := encodeValue(&buf, inflated, false); err != nil {
+			return nil, fmt.Errorf("%s: element %d: %v", fn.Name(), i, err)
+		}
+		buf.WriteByte('\n')
+	}
+	return starlark.String(buf.String()), nil
+}
+
+// Marshal returns the JSON encoding of a Starlark value, in the same compact
+// format as the json.encode builtin. Object keys are sorted, so values that
+// are equal have the same encoding.
+func Marshal(v starlark.Value) ([]byte, error) {
+	inflated, err := yamlmodule.FromStarlark(v, "JSON")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, inflated, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func jsonCanonical(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &v); err != nil {
+		return nil, err
+	}
+	inflated, err := yamlmodule.FromStarlark(v, "JSON")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, inflated, true); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.String(buf.String()), nil
+}
+
+// MarshalCanonical returns the canonical JSON encoding of a Starlark value,
+// in the same format as the json.canonical builtin. The encoding follows the
+// JSON Canonicalization Scheme of RFC 8785, except that integers are written
+// exactly rather than being rounded to a float, so it is suitable for hashing
+// or signing.
+func MarshalCanonical(v starlark.Value) ([]byte, error) {
+	inflated, err := yamlmodule.FromStarlark(v, "JSON")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, inflated, true); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeValue writes the compact JSON encoding of a value returned by
+// yamlmodule.FromStarlark. Object keys are written in sorted order.
+//
+// If canonical is true, floats, strings, and the order of keys follow RFC
+// 8785, so that the output is the same for equal values.
+func encodeValue(buf *bytes.Buffer, obj interface{}, canonical bool) error {
+	switch obj := obj.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if obj {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case int:
+		fmt.Fprint(buf, obj)
+	case int64:
+		fmt.Fprint(buf, obj)
+	case uint64:
+		fmt.Fprint(buf, obj)
+	case *big.Int:
+		buf.WriteString(obj.String())
+	case float64:
+		if math.IsInf(obj, 0) || math.IsNaN(obj) {
+			return fmt.Errorf("cannot encode non-finite float %v", starlark.Float(obj))
+		}
+		if canonical {
+			buf.WriteString(canonicalFloat(obj))
+		} else {
+			buf.WriteString(starlark.Float(obj).String())
+		}
+	case string:
+		if canonical {
+			if !utf8.ValidString(obj) {
+				return fmt.Errorf("cannot encode string %q with invalid UTF-8", obj)
+			}
+			writeCanonicalString(buf, obj)
+		} else {
+			writeString(buf, obj)
+		}
+	case []byte:
+		writeString(buf, base64.StdEncoding.EncodeToString(obj))
+	case time.Time:
+		writeString(buf, obj.Format(time.RFC3339Nano))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, element := range obj {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, element, canonical); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yamlmodule.MapSlice:
+		items := make(yamlmodule.MapSlice, len(obj))
+		copy(items, obj)
+		for _, item := range items {
+			if _, ok := item.Key.(string); !ok {
+				return fmt.Errorf("%s (%v) is not a supported key type", reflect.TypeOf(item.Key).Kind(), item.Key)
+			}
+		}
+		less := func(i, j int) bool {
+			return items[i].Key.(string) < items[j].Key.(string)
+		}
+		if canonical {
+			less = func(i, j int) bool {
+				return utf16Less(items[i].Key.(string), items[j].Key.(string))
+			}
+		}
+		sort.SliceStable(items, less)
+		buf.WriteByte('{')
+		for i, item := range items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if canonical {
+				if err := encodeValue(buf, item.Key, true); err != nil {
+					return err
+				}
+			} else {
+				writeString(buf, item.Key.(string))
+			}
+			buf.WriteByte(':')
+			if err := encodeValue(buf, item.Value, canonical); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("%s (%v) is not a supported type", reflect.TypeOf(obj).Kind(), obj)
+	}
+	return nil
+}
+
+// writeString writes a quoted JSON string, without the HTML escaping done by
+// json.Marshal.
+func writeString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	// Remove the newline written after each value.
+	buf.Truncate(buf.Len() - 1)
+}
+
+// canonicalFloat formats a finite float in the same way as JavaScript's
+// Number.prototype.toString, as required by RFC 8785.
+func canonicalFloat(f float64) string {
+	if f == 0 {
+		// Including negative zero.
+		return "0"
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f, format, -1, 64)
+	if format == 'e' {
+		// Remove any leading zero from the exponent, as in "1e-07".
+		if i := strings.IndexByte(s, 'e'); s[i+2] == '0' {
+			s = s[:i+2] + s[i+3:]
+		}
+	}
+	return s
+}
+
+// writeCanonicalString writes a quoted JSON string, escaping only the
+// characters that RFC 8785 requires to be escaped.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// utf16Less reports whether a sorts before b when compared as sequences of
+// UTF-16 code units, which is the key order required by RFC 8785.
+func utf16Less(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
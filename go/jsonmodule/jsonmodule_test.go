@@ -0,0 +1,368 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonmodule
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+type JsonTestCase struct {
+	skyExpr   string
+	expOutput string
+}
+
+func TestSkyToJson(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"json":   NewModule(),
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	}
+
+	testCases := []JsonTestCase{
+		{
+			skyExpr:   `json.encode({"b": [1, 2.5, None], "a": True})`,
+			expOutput: `{"a":true,"b":[1,2.5,null]}`,
+		},
+		{
+			skyExpr:   `json.encode([1.0, 18446744073709551616, -9223372036854775808])`,
+			expOutput: `[1.0,18446744073709551616,-9223372036854775808]`,
+		},
+		{
+			skyExpr:   `json.encode(struct(name = "<tag> & \n", items = ()))`,
+			expOutput: `{"items":[],"name":"<tag> & \n"}`,
+		},
+		{
+			skyExpr:   `json.encode(b"hello")`,
+			expOutput: `"aGVsbG8="`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		got, ok := v.(starlark.String)
+		if !ok {
+			t.Errorf("%s: got %s, want string", testCase.skyExpr, v.Type())
+			continue
+		}
+		if string(got) != testCase.expOutput {
+			t.Error(
+				"Bad return value from json.encode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", string(got))
+		}
+	}
+}
+
+func TestSkyToJsonIndent(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"json": NewModule(),
+	}
+
+	testCases := []JsonTestCase{
+		{
+			skyExpr:   `json.encode({"b": [1, {}], "a": []}, indent = 0)`,
+			expOutput: `{"a":[],"b":[1,{}]}`,
+		},
+		{
+			skyExpr:   `json.encode({"b": [1, {}], "a": []}, indent = 2)`,
+			expOutput: "{\n  \"a\": [],\n  \"b\": [\n    1,\n    {}\n  ]\n}",
+		},
+		{
+			skyExpr:   `json.encode({"z": {"y": 1, "x": 2}}, indent = 4)`,
+			expOutput: "{\n    \"z\": {\n        \"x\": 2,\n        \"y\": 1\n    }\n}",
+		},
+		{
+			skyExpr:   `json.encode("a", indent = 2)`,
+			expOutput: `"a"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		if got := string(v.(starlark.String)); got != testCase.expOutput {
+			t.Error(
+				"Bad return value from json.encode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", got)
+		}
+	}
+
+	_, err := starlark.Eval(thread, "<expr>", `json.encode({}, indent = -1)`, env)
+	wantErr := "json.encode: for parameter indent: got -1, want a non-negative value"
+	if err == nil || err.Error() != wantErr {
+		t.Error("Bad error from json.encode", "\nExpected:", wantErr, "\nGot:", err)
+	}
+}
+
+func TestSkyToJsonCanonical(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"json":   NewModule(),
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	}
+
+	testCases := []JsonTestCase{
+		{
+			skyExpr:   `json.canonical({"b": [1, 2.5, None], "a": struct(y = True, x = "")})`,
+			expOutput: `{"a":{"x":"","y":true},"b":[1,2.5,null]}`,
+		},
+		// Numbers from the examples of RFC 8785.
+		{
+			skyExpr:   `json.canonical([1.0, -0.0, 333333333.33333329, 1e30, 4.50, 2e-3, 0.000000000000000000000000001, 1e-7, 1e21, 1e20])`,
+			expOutput: `[1,0,333333333.3333333,1e+30,4.5,0.002,1e-27,1e-7,1e+21,100000000000000000000]`,
+		},
+		{
+			skyExpr:   `json.canonical(18446744073709551616)`,
+			expOutput: `18446744073709551616`,
+		},
+		{
+			skyExpr:   `json.canonical("<tag> & €   \x7f \x01 \t\"\\")`,
+			expOutput: "\"<tag> & €   \x7f \\u0001 \\t\\\"\\\\\"",
+		},
+		// Keys are sorted by UTF-16 code units, so U+1F600 (encoded as the
+		// surrogates U+D83D U+DE00) sorts before U+FB33.
+		{
+			skyExpr:   `json.canonical({"דּ": 1, "\U0001f600": 2, "€": 3, "1": 4, "": 5})`,
+			expOutput: "{\"\":5,\"1\":4,\"€\":3,\"\U0001f600\":2,\"דּ\":1}",
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		if got := string(v.(starlark.String)); got != testCase.expOutput {
+			t.Error(
+				"Bad return value from json.canonical", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", got)
+		}
+	}
+
+	env["invalid"] = starlark.String("\xff")
+	errorCases := []JsonTestCase{
+		{
+			skyExpr:   `json.canonical(float("inf"))`,
+			expOutput: "json.canonical: cannot encode non-finite float +inf",
+		},
+		{
+			skyExpr:   `json.canonical({"a": invalid})`,
+			expOutput: `json.canonical: cannot encode string "\xff" with invalid UTF-8`,
+		},
+	}
+
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err == nil || err.Error() != testCase.expOutput {
+			t.Error(
+				"Bad error from json.canonical", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", err)
+		}
+	}
+
+	got, err := MarshalCanonical(starlark.Float(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "100" {
+		t.Error("Bad return value from MarshalCanonical", "\nExpected: 100", "\nGot:", string(got))
+	}
+}
+
+func TestSkyToJsonErrors(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"json": NewModule(),
+	}
+
+	testCases := []JsonTestCase{
+		{
+			skyExpr:   `json.encode({1: "a"})`,
+			expOutput: "json.encode: int64 (1) is not a supported key type",
+		},
+		{
+			skyExpr:   `json.encode(float("nan"))`,
+			expOutput: "json.encode: cannot encode non-finite float nan",
+		},
+		{
+			skyExpr:   `json.encode(json)`,
+			expOutput: "TypeError: value <module \"json\"> (type `module') can't be converted to JSON.",
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err == nil || err.Error() != testCase.expOutput {
+			t.Error(
+				"Bad error from json.encode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", err)
+		}
+	}
+}
+
+func TestJsonToSky(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"json": NewModule(),
+	}
+
+	testCases := []JsonTestCase{
+		{
+			skyExpr:   `json.decode('{"b": [1, 2.5, 1e3, null], "a": {"c": false}}')`,
+			expOutput: `{"b": [1, 2.5, 1000.0, None], "a": {"c": False}}`,
+		},
+		{
+			skyExpr:   `json.decode('18446744073709551616')`,
+			expOutput: `18446744073709551616`,
+		},
+		{
+			skyExpr:   `json.decode('[]')`,
+			expOutput: `[]`,
+		},
+		{
+			skyExpr:   `json.decode('"caf\\u00e9"')`,
+			expOutput: `"café"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		if v.String() != testCase.expOutput {
+			t.Error(
+				"Bad return value from json.decode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", v)
+		}
+	}
+
+	// Syntax errors are reported by the encoding/json package, with wording
+	// that varies between Go versions.
+	errorCases := []JsonTestCase{
+		{
+			skyExpr:   `json.decode('{"a": }')`,
+			expOutput: "json.decode: ",
+		},
+		{
+			skyExpr:   `json.decode('[1, 2')`,
+			expOutput: "json.decode: ",
+		},
+		{
+			skyExpr:   `json.decode('1 2')`,
+			expOutput: "json.decode: unexpected data after top-level value",
+		},
+	}
+
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err == nil || !strings.HasPrefix(err.Error(), testCase.expOutput) {
+			t.Error(
+				"Bad error from json.decode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", err)
+		}
+	}
+}
+
+func TestJsonLines(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"json": NewModule(),
+	}
+
+	testCases := []JsonTestCase{
+		{
+			skyExpr:   `json.encode_lines([{"b": 1, "a": "x\ny"}, [1, 2], None, "s"])`,
+			expOutput: `"{\"a\":\"x\\ny\",\"b\":1}\n[1,2]\nnull\n\"s\"\n"`,
+		},
+		{
+			skyExpr:   `json.encode_lines(())`,
+			expOutput: `""`,
+		},
+		{
+			skyExpr:   `json.decode_lines('{"a": 1}\n\n  \n[true, null]\r\n"s"')`,
+			expOutput: `[{"a": 1}, [True, None], "s"]`,
+		},
+		{
+			skyExpr:   `json.decode_lines('')`,
+			expOutput: `[]`,
+		},
+		{
+			skyExpr:   `json.decode_lines(json.encode_lines([{"a": [1, 2.5]}, {"b": "c"}]))`,
+			expOutput: `[{"a": [1, 2.5]}, {"b": "c"}]`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		if v.String() != testCase.expOutput {
+			t.Error(
+				"Bad return value", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", v)
+		}
+	}
+
+	errorCases := []JsonTestCase{
+		{
+			skyExpr:   `json.encode_lines({"a": 1})`,
+			expOutput: "json.encode_lines: for parameter values: got dict, want list",
+		},
+		{
+			skyExpr:   `json.encode_lines([1, float("inf")])`,
+			expOutput: "json.encode_lines: element 1: cannot encode non-finite float +inf",
+		},
+		{
+			skyExpr:   `json.decode_lines('1\n\n2 3')`,
+			expOutput: "json.decode_lines: line 3: unexpected data after top-level value",
+		},
+	}
+
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err == nil || err.Error() != testCase.expOutput {
+			t.Error(
+				"Bad error", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", err)
+		}
+	}
+}
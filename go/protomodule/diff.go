@@ -0,0 +1,215 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package protomodule
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// diffMessages returns the differences between two messages of the same
+// type, one per line. Each line names the path of a field that was added,
+// removed, or changed between a and b.
+func diffMessages(a, b protoreflect.Message) []string {
+	var d differ
+	d.messages("", a, b)
+	return d.lines
+}
+
+type differ struct {
+	lines []string
+}
+
+func (d *differ) added(path string, v string) {
+	d.lines = append(d.lines, fmt.Sprintf("added %s: %s", path, v))
+}
+
+func (d *differ) removed(path string, v string) {
+	d.lines = append(d.lines, fmt.Sprintf("removed %s: %s", path, v))
+}
+
+func (d *differ) changed(path string, a, b string) {
+	d.lines = append(d.lines, fmt.Sprintf("changed %s: %s -> %s", path, a, b))
+}
+
+func (d *differ) messages(path string, a, b protoreflect.Message) {
+	fields := a.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		hasA, hasB := a.Has(fd), b.Has(fd)
+		if !hasA && !hasB {
+			continue
+		}
+		fieldPath := string(fd.Name())
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		switch {
+		case fd.IsList():
+			d.lists(fieldPath, fd, a.Get(fd).List(), b.Get(fd).List())
+		case fd.IsMap():
+			d.maps(fieldPath, fd.MapValue(), a.Get(fd).Map(), b.Get(fd).Map())
+		case !hasA:
+			d.added(fieldPath, formatValue(fd, b.Get(fd)))
+		case !hasB:
+			d.removed(fieldPath, formatValue(fd, a.Get(fd)))
+		default:
+			d.values(fieldPath, fd, a.Get(fd), b.Get(fd))
+		}
+	}
+}
+
+func (d *differ) values(path string, fd protoreflect.FieldDescriptor, a, b protoreflect.Value) {
+	if isMessageKind(fd) {
+		d.messages(path, a.Message(), b.Message())
+		return
+	}
+	if !scalarEqual(a, b) {
+		d.changed(path, formatScalar(fd, a), formatScalar(fd, b))
+	}
+}
+
+func (d *differ) lists(path string, fd protoreflect.FieldDescriptor, a, b protoreflect.List) {
+	for i := 0; i < a.Len() || i < b.Len(); i++ {
+		elemPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= a.Len():
+			d.added(elemPath, formatScalar(fd, b.Get(i)))
+		case i >= b.Len():
+			d.removed(elemPath, formatScalar(fd, a.Get(i)))
+		default:
+			d.values(elemPath, fd, a.Get(i), b.Get(i))
+		}
+	}
+}
+
+func (d *differ) maps(path string, fd protoreflect.FieldDescriptor, a, b protoreflect.Map) {
+	var keys []protoreflect.MapKey
+	a.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	b.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		if !a.Has(k) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return lessMapKey(keys[i], keys[j])
+	})
+	for _, k := range keys {
+		entryPath := fmt.Sprintf("%s[%s]", path, formatMapKey(k))
+		switch {
+		case !a.Has(k):
+			d.added(entryPath, formatScalar(fd, b.Get(k)))
+		case !b.Has(k):
+			d.removed(entryPath, formatScalar(fd, a.Get(k)))
+		default:
+			d.values(entryPath, fd, a.Get(k), b.Get(k))
+		}
+	}
+}
+
+func isMessageKind(fd protoreflect.FieldDescriptor) bool {
+	return fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind
+}
+
+func scalarEqual(a, b protoreflect.Value) bool {
+	if x, ok := a.Interface().([]byte); ok {
+		return bytes.Equal(x, b.Bytes())
+	}
+	return a.Interface() == b.Interface()
+}
+
+func lessMapKey(a, b protoreflect.MapKey) bool {
+	switch x := a.Interface().(type) {
+	case bool:
+		return !x && b.Bool()
+	case int32, int64:
+		return a.Int() < b.Int()
+	case uint32, uint64:
+		return a.Uint() < b.Uint()
+	}
+	return a.String() < b.String()
+}
+
+func formatMapKey(k protoreflect.MapKey) string {
+	if s, ok := k.Interface().(string); ok {
+		return strconv.Quote(s)
+	}
+	return k.String()
+}
+
+// formatValue formats the value of a field, which may be a list or map.
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		elems := make([]string, list.Len())
+		for i := range elems {
+			elems[i] = formatScalar(fd, list.Get(i))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case fd.IsMap():
+		var keys []protoreflect.MapKey
+		v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, k)
+			return true
+		})
+		sort.Slice(keys, func(i, j int) bool {
+			return lessMapKey(keys[i], keys[j])
+		})
+		entries := make([]string, len(keys))
+		for i, k := range keys {
+			entries[i] = formatMapKey(k) + ": " + formatScalar(fd.MapValue(), v.Map().Get(k))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	}
+	return formatScalar(fd, v)
+}
+
+// formatScalar formats a single value of a field, which is a message or a
+// scalar but not a list or map.
+func formatScalar(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		msg := v.Message()
+		var fields []string
+		msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			fields = append(fields, string(fd.Name())+": "+formatValue(fd, v))
+			return true
+		})
+		sort.Strings(fields)
+		return "{" + strings.Join(fields, ", ") + "}"
+	case protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case protoreflect.BytesKind:
+		return strconv.Quote(string(v.Bytes()))
+	case protoreflect.EnumKind:
+		if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+			return string(value.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	}
+	return v.String()
+}
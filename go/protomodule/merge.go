@@ -18,8 +18,11 @@ package protomodule
 
 import (
 	"fmt"
+	"strings"
 
 	"go.starlark.net/starlark"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	field_mask_pb "google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 // Implements proto.merge merging src into dst, returning merged value
@@ -43,6 +46,7 @@ func mergeField(dst, src starlark.Value) (starlark.Value, error) {
 		}
 
 		newList := newProtoRepeated(dst.fieldDesc)
+		newList.strict = dst.strict
 
 		err := newList.Extend(dst)
 		if err != nil {
@@ -62,6 +66,7 @@ func mergeField(dst, src starlark.Value) (starlark.Value, error) {
 		}
 
 		newMap := newProtoMap(dst.mapKey, dst.mapValue)
+		newMap.strict = dst.strict
 
 		for _, item := range dst.Items() {
 			err := newMap.SetKey(item[0], item[1])
@@ -88,6 +93,7 @@ func mergeField(dst, src starlark.Value) (starlark.Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		newMessage.strict = dst.strict
 
 		newMessage.Merge(dst)
 		newMessage.Merge(src)
@@ -98,6 +104,131 @@ func mergeField(dst, src starlark.Value) (starlark.Value, error) {
 	}
 }
 
+// MergePaths copies the fields named by paths from other into msg, following
+// the update semantics of google.protobuf.FieldMask. Each path is a sequence
+// of field names separated by ".", which must have been validated against
+// the message type. The last field of each path is replaced rather than
+// merged, so repeated fields aren't concatenated, and a field that is unset
+// in other is cleared in msg.
+func (msg *protoMessage) MergePaths(other *protoMessage, paths []string) error {
+	if msg.Type() != other.Type() {
+		return fmt.Errorf("Cannot merge protobufs of different types: Merge(%s, %s) ", msg.Type(), other.Type())
+	}
+
+	if err := msg.CheckMutable("merge"); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := mergePath(msg, other, strings.Split(path, ".")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergePath(dst, src *protoMessage, path []string) error {
+	name := path[0]
+	fieldDesc := getFieldDescriptor(dst.msgDesc, name)
+	if fieldDesc == nil {
+		return fmt.Errorf("AttributeError: `%s' value has no field %q", dst.Type(), name)
+	}
+	srcVal, srcSet := src.fields[name]
+
+	if len(path) == 1 {
+		if !srcSet {
+			if err := dst.CheckMutable("merge"); err != nil {
+				return err
+			}
+			delete(dst.fields, name)
+			return nil
+		}
+		val, err := copyField(srcVal)
+		if err != nil {
+			return err
+		}
+		return dst.SetField(name, val)
+	}
+
+	// Intermediate fields are singular messages, which are created in dst
+	// if not already present. A message already present may be shared
+	// with other values or frozen, so it is copied rather than modified.
+	if fieldDesc.Kind() != protoreflect.MessageKind || fieldDesc.IsList() || fieldDesc.IsMap() {
+		return fmt.Errorf("field %q of %s is not a message", name, dst.Type())
+	}
+	var dstMsg *protoMessage
+	if oldMsg, ok := dst.fields[name].(*protoMessage); ok {
+		newMsg, err := NewMessage(oldMsg.msg)
+		if err != nil {
+			return err
+		}
+		newMsg.strict = oldMsg.strict
+		if err := newMsg.Merge(oldMsg); err != nil {
+			return err
+		}
+		dstMsg = newMsg
+	} else {
+		newMsg, err := NewMessage(dst.msg.ProtoReflect().NewField(fieldDesc).Message().Interface())
+		if err != nil {
+			return err
+		}
+		dstMsg = newMsg
+	}
+	if err := dst.SetField(name, dstMsg); err != nil {
+		return err
+	}
+	srcMsg, ok := srcVal.(*protoMessage)
+	if !ok {
+		// Unset in src, so the remaining path is cleared in dst.
+		var err error
+		if srcMsg, err = NewMessage(dstMsg.msg); err != nil {
+			return err
+		}
+	}
+	return mergePath(dstMsg, srcMsg, path[1:])
+}
+
+// copyField returns a copy of a field value, so that a message merged with
+// MergePaths doesn't share lists, maps, or messages with its source.
+func copyField(val starlark.Value) (starlark.Value, error) {
+	switch val := val.(type) {
+	case *protoRepeated:
+		return mergeField(newProtoRepeated(val.fieldDesc), val)
+	case *protoMap:
+		return mergeField(newProtoMap(val.mapKey, val.mapValue), val)
+	case *protoMessage:
+		return NewMessage(val.toProtoMessage())
+	}
+	return val, nil
+}
+
+// fieldMaskPaths returns the paths of the field_mask parameter of
+// proto.merge, which may be a list of paths or a google.protobuf.FieldMask.
+func fieldMaskPaths(v starlark.Value) ([]string, error) {
+	if msg, ok := AsProtoMessage(v); ok {
+		if mask, ok := msg.(*field_mask_pb.FieldMask); ok {
+			return mask.GetPaths(), nil
+		}
+		return nil, fmt.Errorf("got %s, want google.protobuf.FieldMask", v.Type())
+	}
+	var paths []string
+	switch v := v.(type) {
+	case *starlark.List, starlark.Tuple:
+		iter := starlark.Iterate(v)
+		defer iter.Done()
+		var item starlark.Value
+		for iter.Next(&item) {
+			path, ok := starlark.AsString(item)
+			if !ok {
+				return nil, fmt.Errorf("got %s in list, want string", item.Type())
+			}
+			paths = append(paths, path)
+		}
+		return paths, nil
+	}
+	return nil, fmt.Errorf("got %s, want list of field paths", v.Type())
+}
+
 func mergeError(dst, src starlark.Value) error {
 	return fmt.Errorf("MergeError: Cannot merge protobufs of different types: Merge(%s, %s)", dst.Type(), src.Type())
 }
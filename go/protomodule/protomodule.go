@@ -18,7 +18,10 @@
 package protomodule
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -28,41 +31,83 @@ import (
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	any_pb "google.golang.org/protobuf/types/known/anypb"
+	field_mask_pb "google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/stripe/skycfg/go/jsonmodule"
+	"github.com/stripe/skycfg/go/yamlmodule"
 )
 
 // NewModule returns a Starlark module of Protobuf-related functions.
 //
 //  proto = module(
 //    clear,
+//    clear_field,
+//    clear_oneof,
 //    clone,
 //    decode_any,
 //    decode_json,
 //    decode_text,
+//    diff,
 //    encode_any,
 //    encode_json,
 //    encode_text,
+//    fields,
+//    from_yaml,
+//    has,
 //    merge,
+//    pack_any,
 //    set_defaults,
+//    size,
+//    to_yaml,
+//    unpack_any,
+//    which_oneof,
 //  )
 //
 // See `docs/modules.asciidoc` for details on the API of each function.
 func NewModule(registry *protoregistry.Types) *starlarkstruct.Module {
-	return &starlarkstruct.Module{
+	return newModule(registry, false)
+}
+
+// NewStrictModule returns a Starlark module of Protobuf-related functions
+// whose message types check field types strictly. Messages of those types
+// don't accept int values for float and double fields, or numbers of seconds
+// for Duration fields, which NewModule's message types convert implicitly.
+func NewStrictModule(registry *protoregistry.Types) *starlarkstruct.Module {
+	return newModule(registry, true)
+}
+
+func newModule(registry *protoregistry.Types, strict bool) *starlarkstruct.Module {
+	module := &starlarkstruct.Module{
 		Name: "proto",
 		Members: starlark.StringDict{
 			"clear":        starlarkClear,
+			"clear_field":  starlarkClearField,
+			"clear_oneof":  starlarkClearOneof,
 			"clone":        starlarkClone,
 			"decode_any":   decodeAny(registry),
 			"decode_json":  decodeJSON(registry),
 			"decode_text":  decodeText(registry),
+			"diff":         starlarkDiff,
 			"encode_any":   starlarkEncodeAny,
 			"encode_json":  encodeJSON(registry),
 			"encode_text":  encodeText(registry),
+			"fields":       starlarkFields,
+			"from_yaml":    fromYAML(registry),
+			"has":          starlarkHas,
 			"merge":        starlarkMerge,
-			"package":      starlarkPackageFn(registry),
+			"package":      starlarkPackageFn(registry, strict),
 			"set_defaults": starlarkSetDefaults,
+			"size":         starlarkSize,
+			"to_yaml":      toYAML(registry),
+			"which_oneof":  starlarkWhichOneof,
 		},
 	}
+
+	// Aliases matching the Any helpers of other Protobuf libraries.
+	module.Members["pack_any"] = module.Members["encode_any"]
+	module.Members["unpack_any"] = module.Members["decode_any"]
+
+	return module
 }
 
 var starlarkClear = starlark.NewBuiltin("proto.clear", func(
@@ -84,17 +129,64 @@ var starlarkClear = starlark.NewBuiltin("proto.clear", func(
 	return skyProtoMsg, nil
 })
 
+var starlarkClearField = starlark.NewBuiltin("proto.clear_field", func(
+	t *starlark.Thread,
+	fn *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var val starlark.Value
+	var path string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &val, &path); err != nil {
+		return nil, err
+	}
+	msg, ok := val.(*protoMessage)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.Message", fn.Name(), val.Type())
+	}
+	if err := msg.ClearField(path); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.None, nil
+})
+
+var starlarkClearOneof = starlark.NewBuiltin("proto.clear_oneof", func(
+	t *starlark.Thread,
+	fn *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var val starlark.Value
+	var name string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &val, &name); err != nil {
+		return nil, err
+	}
+	msg, ok := val.(*protoMessage)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.Message", fn.Name(), val.Type())
+	}
+	if err := msg.ClearOneof(name); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.None, nil
+})
+
 var starlarkClone = starlark.NewBuiltin("proto.clone", func(
 	t *starlark.Thread,
 	fn *starlark.Builtin,
 	args starlark.Tuple,
 	kwargs []starlark.Tuple,
 ) (starlark.Value, error) {
-	msg, _, err := wantSingleProtoMessage(fn, args, kwargs)
+	msg, skyProtoMsg, err := wantSingleProtoMessage(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	out, err := NewMessage(proto.Clone(msg))
 	if err != nil {
 		return nil, err
 	}
-	return NewMessage(proto.Clone(msg))
+	out.strict = skyProtoMsg.(*protoMessage).strict
+	return out, nil
 })
 
 func decodeAny(registry *protoregistry.Types) starlark.Callable {
@@ -116,6 +208,9 @@ func decodeAny(registry *protoregistry.Types) starlark.Callable {
 		decoded, err := any_pb.UnmarshalNew(anyMsg, proto.UnmarshalOptions{
 			Resolver: registry,
 		})
+		if errors.Is(err, protoregistry.NotFound) {
+			return nil, fmt.Errorf("%s: no message type registered for type URL %q", fn.Name(), anyMsg.GetTypeUrl())
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +227,7 @@ func decodeJSON(registry *protoregistry.Types) starlark.Callable {
 	) (starlark.Value, error) {
 		var msgType starlark.Value
 		var value starlark.String
-		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &msgType, &value); err != nil {
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, nil, 2, &msgType, &value); err != nil {
 			return nil, err
 		}
 		protoMsgType, ok := msgType.(skyProtoMessageType)
@@ -143,11 +238,21 @@ func decodeJSON(registry *protoregistry.Types) starlark.Callable {
 		unmarshal := protojson.UnmarshalOptions{
 			Resolver: registry,
 		}
+
+		if len(kwargs) > 0 {
+			strict := true
+			if err := starlark.UnpackArgs(fn.Name(), nil, kwargs, "strict", &strict); err != nil {
+				return nil, err
+			}
+			if !strict {
+				unmarshal.DiscardUnknown = true
+			}
+		}
 		decoded := protoMsgType.NewMessage()
 		if err := unmarshal.Unmarshal([]byte(value), decoded); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: %s: %v", fn.Name(), decoded.ProtoReflect().Descriptor().FullName(), err)
 		}
-		return NewMessage(decoded)
+		return newMessageOfType(msgType, decoded)
 	})
 }
 
@@ -173,12 +278,38 @@ func decodeText(registry *protoregistry.Types) starlark.Callable {
 		}
 		decoded := protoMsgType.NewMessage()
 		if err := unmarshal.Unmarshal([]byte(value), decoded); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: %s: %v", fn.Name(), decoded.ProtoReflect().Descriptor().FullName(), err)
 		}
-		return NewMessage(decoded)
+		return newMessageOfType(msgType, decoded)
 	})
 }
 
+var starlarkDiff = starlark.NewBuiltin("proto.diff", func(
+	t *starlark.Thread,
+	fn *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var val1, val2 starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &val1, &val2); err != nil {
+		return nil, err
+	}
+	msg1, ok := AsProtoMessage(val1)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.Message", fn.Name(), val1.Type())
+	}
+	msg2, ok := AsProtoMessage(val2)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter 2: got %s, want proto.Message", fn.Name(), val2.Type())
+	}
+	if val1.Type() != val2.Type() {
+		return nil, fmt.Errorf("%s: types are not the same: got %s and %s", fn.Name(), val1.Type(), val2.Type())
+	}
+
+	lines := diffMessages(msg1.ProtoReflect(), msg2.ProtoReflect())
+	return starlark.String(strings.Join(lines, "\n")), nil
+})
+
 var starlarkEncodeAny = starlark.NewBuiltin("proto.encode_any", func(
 	t *starlark.Thread,
 	fn *starlark.Builtin,
@@ -218,7 +349,12 @@ func encodeJSON(registry *protoregistry.Types) starlark.Callable {
 
 		if len(kwargs) > 0 {
 			compact := true
-			if err := starlark.UnpackArgs(fn.Name(), nil, kwargs, "compact", &compact); err != nil {
+			if err := starlark.UnpackArgs(fn.Name(), nil, kwargs,
+				"compact?", &compact,
+				"use_proto_names?", &marshal.UseProtoNames,
+				"emit_defaults?", &marshal.EmitUnpopulated,
+				"use_enum_numbers?", &marshal.UseEnumNumbers,
+			); err != nil {
 				return nil, err
 			}
 			if !compact {
@@ -249,9 +385,13 @@ func encodeText(registry *protoregistry.Types) starlark.Callable {
 			Resolver: registry,
 		}
 
+		deterministic := true
 		if len(kwargs) > 0 {
 			compact := true
-			if err := starlark.UnpackArgs(fn.Name(), nil, kwargs, "compact", &compact); err != nil {
+			if err := starlark.UnpackArgs(fn.Name(), nil, kwargs,
+				"compact?", &compact,
+				"deterministic?", &deterministic,
+			); err != nil {
 				return nil, err
 			}
 			if !compact {
@@ -262,10 +402,175 @@ func encodeText(registry *protoregistry.Types) starlark.Callable {
 		if err != nil {
 			return nil, err
 		}
+		if deterministic {
+			text = normalizeTextSpace(text)
+		}
 		return starlark.String(text), nil
 	})
 }
 
+func toYAML(registry *protoregistry.Types) starlark.Callable {
+	return starlark.NewBuiltin("proto.to_yaml", func(
+		t *starlark.Thread,
+		fn *starlark.Builtin,
+		args starlark.Tuple,
+		kwargs []starlark.Tuple,
+	) (starlark.Value, error) {
+		var val starlark.Value
+		useProtoNames := true
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+			"msg", &val,
+			"use_proto_names?", &useProtoNames,
+		); err != nil {
+			return nil, err
+		}
+		protoMsg, ok := AsProtoMessage(val)
+		if !ok {
+			return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.Message", fn.Name(), val.Type())
+		}
+
+		marshal := protojson.MarshalOptions{
+			UseProtoNames: useProtoNames,
+			Resolver:      registry,
+		}
+		jsonData, err := marshal.Marshal(protoMsg)
+		if err != nil {
+			return nil, err
+		}
+		// JSON is a subset of YAML, so the JSON encoding can be decoded
+		// and then encoded again in the same style as yaml.encode.
+		value, err := yamlmodule.Unmarshal(jsonData)
+		if err != nil {
+			return nil, err
+		}
+		yamlData, err := yamlmodule.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.String(yamlData), nil
+	})
+}
+
+func fromYAML(registry *protoregistry.Types) starlark.Callable {
+	return starlark.NewBuiltin("proto.from_yaml", func(
+		t *starlark.Thread,
+		fn *starlark.Builtin,
+		args starlark.Tuple,
+		kwargs []starlark.Tuple,
+	) (starlark.Value, error) {
+		var msgType starlark.Value
+		var value starlark.String
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &msgType, &value); err != nil {
+			return nil, err
+		}
+
+		// The message type may be given either as a message type value or
+		// by its full name, which is looked up in the registry.
+		var decoded proto.Message
+		switch msgType := msgType.(type) {
+		case skyProtoMessageType:
+			decoded = msgType.NewMessage()
+		case starlark.String:
+			mt, err := registry.FindMessageByName(protoreflect.FullName(msgType))
+			if err != nil {
+				return nil, fmt.Errorf("%s: message type %q not found", fn.Name(), string(msgType))
+			}
+			decoded = mt.New().Interface()
+		default:
+			return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.MessageType or string", fn.Name(), msgType.Type())
+		}
+
+		// The YAML is decoded in the same way as yaml.decode and
+		// re-encoded as JSON, so the Protobuf JSON mapping applies.
+		yamlValue, err := yamlmodule.Unmarshal([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+		}
+		jsonData, err := jsonmodule.Marshal(yamlValue)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+		}
+		unmarshal := protojson.UnmarshalOptions{
+			Resolver: registry,
+		}
+		if err := unmarshal.Unmarshal(jsonData, decoded); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", fn.Name(), decoded.ProtoReflect().Descriptor().FullName(), err)
+		}
+		return newMessageOfType(msgType, decoded)
+	})
+}
+
+var starlarkFields = starlark.NewBuiltin("proto.fields", func(
+	t *starlark.Thread,
+	fn *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	protoMsg, skyProtoMsg, err := wantSingleProtoMessage(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	var fieldDescs []protoreflect.FieldDescriptor
+	protoMsg.ProtoReflect().Range(func(fieldDesc protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fieldDescs = append(fieldDescs, fieldDesc)
+		return true
+	})
+	sort.Slice(fieldDescs, func(i, j int) bool {
+		return fieldDescs[i].Number() < fieldDescs[j].Number()
+	})
+
+	fields := make([]starlark.Value, 0, len(fieldDescs))
+	for _, fieldDesc := range fieldDescs {
+		name := string(fieldDesc.Name())
+		val, err := skyProtoMsg.Attr(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+		}
+		fields = append(fields, starlark.Tuple{starlark.String(name), val})
+	}
+	return starlark.NewList(fields), nil
+})
+
+var starlarkHas = starlark.NewBuiltin("proto.has", func(
+	t *starlark.Thread,
+	fn *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var val starlark.Value
+	var path string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &val, &path); err != nil {
+		return nil, err
+	}
+	protoMsg, ok := AsProtoMessage(val)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.Message", fn.Name(), val.Type())
+	}
+
+	// Each field of the path but the last must be a singular message. An
+	// unset message is read as empty, so that the rest of the path is still
+	// checked against the message type.
+	msg := protoMsg.ProtoReflect()
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		fieldDesc := getFieldDescriptor(msg.Descriptor(), name)
+		if fieldDesc == nil {
+			return nil, fmt.Errorf("%s: AttributeError: `%s' value has no field %q", fn.Name(), msg.Descriptor().FullName(), name)
+		}
+		if fieldDesc.Kind() != protoreflect.MessageKind || fieldDesc.IsList() || fieldDesc.IsMap() {
+			return nil, fmt.Errorf("%s: field %q of %s is not a message", fn.Name(), name, msg.Descriptor().FullName())
+		}
+		msg = msg.Get(fieldDesc).Message()
+	}
+	name := names[len(names)-1]
+	fieldDesc := getFieldDescriptor(msg.Descriptor(), name)
+	if fieldDesc == nil {
+		return nil, fmt.Errorf("%s: AttributeError: `%s' value has no field %q", fn.Name(), msg.Descriptor().FullName(), name)
+	}
+	return starlark.Bool(msg.Has(fieldDesc)), nil
+})
+
 var starlarkMerge = starlark.NewBuiltin("proto.merge", func(
 	t *starlark.Thread,
 	fn *starlark.Builtin,
@@ -273,7 +578,11 @@ var starlarkMerge = starlark.NewBuiltin("proto.merge", func(
 	kwargs []starlark.Tuple,
 ) (starlark.Value, error) {
 	var val1, val2 starlark.Value
-	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &val1, &val2); err != nil {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, nil, 2, &val1, &val2); err != nil {
+		return nil, err
+	}
+	var fieldMask starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), nil, kwargs, "field_mask?", &fieldMask); err != nil {
 		return nil, err
 	}
 
@@ -283,6 +592,24 @@ var starlarkMerge = starlark.NewBuiltin("proto.merge", func(
 		return nil, fmt.Errorf("%s: types are not the same: got %s and %s", fn.Name(), src.Type(), dst.Type())
 	}
 
+	if fieldMask != nil && fieldMask != starlark.None {
+		paths, err := fieldMaskPaths(fieldMask)
+		if err != nil {
+			return nil, fmt.Errorf("%s: for parameter field_mask: %v", fn.Name(), err)
+		}
+		mask := &field_mask_pb.FieldMask{}
+		for _, path := range paths {
+			if err := mask.Append(dst.msg, path); err != nil {
+				return nil, fmt.Errorf("%s: for parameter field_mask: invalid path %q for message %s", fn.Name(), path, dst.Type())
+			}
+		}
+		mask.Normalize()
+		if err := dst.MergePaths(src, mask.GetPaths()); err != nil {
+			return nil, err
+		}
+		return dst, nil
+	}
+
 	err := dst.Merge(src)
 	if err != nil {
 		return nil, err
@@ -310,12 +637,109 @@ var starlarkSetDefaults = starlark.NewBuiltin("proto.set_defaults", func(
 	return skyProtoMsg, nil
 })
 
+var starlarkWhichOneof = starlark.NewBuiltin("proto.which_oneof", func(
+	t *starlark.Thread,
+	fn *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var val starlark.Value
+	var name string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &val, &name); err != nil {
+		return nil, err
+	}
+	protoMsg, ok := AsProtoMessage(val)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.Message", fn.Name(), val.Type())
+	}
+	msg := protoMsg.ProtoReflect()
+	oneof := msg.Descriptor().Oneofs().ByName(protoreflect.Name(name))
+	if oneof == nil {
+		return nil, fmt.Errorf("%s: AttributeError: `%s' value has no oneof %q", fn.Name(), msg.Descriptor().FullName(), name)
+	}
+	fieldDesc := msg.WhichOneof(oneof)
+	if fieldDesc == nil {
+		return starlark.None, nil
+	}
+	return starlark.String(fieldDesc.Name()), nil
+})
+
+var starlarkSize = starlark.NewBuiltin("proto.size", func(
+	t *starlark.Thread,
+	fn *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var val starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &val); err != nil {
+		return nil, err
+	}
+	if protoMsg, ok := AsProtoMessage(val); ok {
+		return starlark.MakeInt(proto.Size(protoMsg)), nil
+	}
+	// The total size of a list of messages, as returned from main().
+	var elems []starlark.Value
+	switch val := val.(type) {
+	case *starlark.List:
+		for ii := 0; ii < val.Len(); ii++ {
+			elems = append(elems, val.Index(ii))
+		}
+	case starlark.Tuple:
+		elems = val
+	default:
+		return nil, fmt.Errorf("%s: for parameter 1: got %s, want proto.Message or list", fn.Name(), val.Type())
+	}
+	var size int
+	for ii, elem := range elems {
+		protoMsg, ok := AsProtoMessage(elem)
+		if !ok {
+			return nil, fmt.Errorf("%s: for parameter 1: element %d: got %s, want proto.Message", fn.Name(), ii, elem.Type())
+		}
+		size += proto.Size(protoMsg)
+	}
+	return starlark.MakeInt(size), nil
+})
+
+// normalizeTextSpace removes the extra spaces that the prototext package
+// randomly inserts between fields, so that its output is stable across
+// builds. Spaces within quoted strings and indentation are kept.
+func normalizeTextSpace(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	var quote byte
+	lineStart := true
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(text) {
+				i++
+				out = append(out, text[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			out = append(out, c)
+		case c == ' ' && !lineStart && len(out) > 0 && out[len(out)-1] == ' ':
+		default:
+			out = append(out, c)
+		}
+		if c == '\n' {
+			lineStart = true
+		} else if c != ' ' {
+			lineStart = false
+		}
+	}
+	return out
+}
+
 type skyProtoMessageType interface {
 	NewMessage() protoreflect.ProtoMessage
 }
 
 type skyProtoMessage interface {
-	starlark.Value
+	starlark.HasAttrs
 	MarshalJSON() ([]byte, error)
 	Clear() error
 	Merge(*protoMessage) error
@@ -18,6 +18,7 @@ package protomodule
 
 import (
 	"fmt"
+	"math"
 	"sort"
 
 	"go.starlark.net/starlark"
@@ -92,6 +93,7 @@ type protoEnumValue struct {
 }
 
 var _ starlark.Comparable = (*protoEnumValue)(nil)
+var _ starlark.HasAttrs = (*protoEnumValue)(nil)
 var _ starlark.Value = (*protoEnumValue)(nil)
 
 func (v *protoEnumValue) String() string {
@@ -108,6 +110,20 @@ func (v *protoEnumValue) enumNumber() protoreflect.EnumNumber {
 	return v.value.Number()
 }
 
+func (v *protoEnumValue) Attr(attrName string) (starlark.Value, error) {
+	switch attrName {
+	case "name":
+		return starlark.String(v.value.Name()), nil
+	case "number":
+		return starlark.MakeInt64(int64(v.value.Number())), nil
+	}
+	return nil, nil
+}
+
+func (v *protoEnumValue) AttrNames() []string {
+	return []string{"name", "number"}
+}
+
 func (v *protoEnumValue) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
 	other := y.(*protoEnumValue)
 	switch op {
@@ -119,3 +135,35 @@ func (v *protoEnumValue) CompareSameType(op syntax.Token, y starlark.Value, dept
 		return false, fmt.Errorf("enums support only `==' and `!=' comparisons, got: %#v", op)
 	}
 }
+
+// maybeConvertToEnum converts the name or number of a value of an enum to the
+// enum value, if the field has an enum type. Other values are returned as-is,
+// so that type errors are reported by the usual type check.
+func maybeConvertToEnum(fieldDesc protoreflect.FieldDescriptor, val starlark.Value) (starlark.Value, error) {
+	if fieldDesc.Kind() != protoreflect.EnumKind || fieldDesc.Enum() == nil {
+		return val, nil
+	}
+	enumDesc := fieldDesc.Enum()
+	var value protoreflect.EnumValueDescriptor
+	switch val := val.(type) {
+	case starlark.String:
+		value = enumDesc.Values().ByName(protoreflect.Name(val))
+		if value == nil {
+			return nil, fmt.Errorf("ValueError: enum %q not found in %s", string(val), enumDesc.FullName())
+		}
+	case starlark.Int:
+		number, ok := val.Int64()
+		if ok && number >= math.MinInt32 && number <= math.MaxInt32 {
+			value = enumDesc.Values().ByNumber(protoreflect.EnumNumber(number))
+		}
+		if value == nil {
+			return nil, fmt.Errorf("ValueError: enum %v out of bounds for %s", val, enumDesc.FullName())
+		}
+	default:
+		return val, nil
+	}
+	return &protoEnumValue{
+		typeName: enumDesc.FullName(),
+		value:    value,
+	}, nil
+}
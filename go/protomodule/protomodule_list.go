@@ -38,6 +38,7 @@ var allowedListMethods = map[string]func(*protoRepeated) starlark.Value{
 type protoRepeated struct {
 	fieldDesc protoreflect.FieldDescriptor
 	list      *starlark.List
+	strict    bool
 }
 
 var _ starlark.Value = (*protoRepeated)(nil)
@@ -50,16 +51,34 @@ var _ starlark.HasBinary = (*protoRepeated)(nil)
 var _ starlark.Comparable = (*protoRepeated)(nil)
 
 func newProtoRepeated(fieldDesc protoreflect.FieldDescriptor) *protoRepeated {
-	return &protoRepeated{fieldDesc, starlark.NewList(nil)}
+	return &protoRepeated{fieldDesc: fieldDesc, list: starlark.NewList(nil)}
 }
 
 func newProtoRepeatedFromList(fieldDesc protoreflect.FieldDescriptor, l *starlark.List) (*protoRepeated, error) {
-	out := &protoRepeated{fieldDesc, l}
+	out := &protoRepeated{fieldDesc: fieldDesc, list: l}
 	for i := 0; i < l.Len(); i++ {
-		err := scalarTypeCheck(fieldDesc, l.Index(i))
+		v, err := maybeConvertToEnum(fieldDesc, l.Index(i))
 		if err != nil {
 			return nil, err
 		}
+		err = scalarTypeCheck(fieldDesc, v)
+		if err != nil {
+			return nil, err
+		}
+		if v != l.Index(i) {
+			// Enum names and numbers are converted into a copy of the
+			// list, leaving the given list (which may be frozen) as is
+			if out.list == l {
+				elems := make([]starlark.Value, l.Len())
+				for j := range elems {
+					elems[j] = l.Index(j)
+				}
+				out.list = starlark.NewList(elems)
+			}
+			if err := out.list.SetIndex(i, v); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return out, nil
 }
@@ -99,7 +118,16 @@ func (r *protoRepeated) CompareSameType(op syntax.Token, y starlark.Value, depth
 }
 
 func (r *protoRepeated) Append(v starlark.Value) error {
-	err := scalarTypeCheck(r.fieldDesc, v)
+	if r.strict {
+		if err := checkStrictType(r.fieldDesc, v); err != nil {
+			return err
+		}
+	}
+	v, err := maybeConvertToEnum(r.fieldDesc, v)
+	if err != nil {
+		return err
+	}
+	err = scalarTypeCheck(r.fieldDesc, v)
 	if err != nil {
 		return err
 	}
@@ -108,7 +136,16 @@ func (r *protoRepeated) Append(v starlark.Value) error {
 }
 
 func (r *protoRepeated) SetIndex(i int, v starlark.Value) error {
-	err := scalarTypeCheck(r.fieldDesc, v)
+	if r.strict {
+		if err := checkStrictType(r.fieldDesc, v); err != nil {
+			return err
+		}
+	}
+	v, err := maybeConvertToEnum(r.fieldDesc, v)
+	if err != nil {
+		return err
+	}
+	err = scalarTypeCheck(r.fieldDesc, v)
 	if err != nil {
 		return err
 	}
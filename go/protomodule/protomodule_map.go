@@ -39,6 +39,7 @@ type protoMap struct {
 	mapKey   protoreflect.FieldDescriptor
 	mapValue protoreflect.FieldDescriptor
 	dict     *starlark.Dict
+	strict   bool
 }
 
 var _ starlark.Value = (*protoMap)(nil)
@@ -67,11 +68,23 @@ func newProtoMapFromDict(mapKey protoreflect.FieldDescriptor, mapValue protorefl
 	// so that the underlying out.dict still has a reference to the given
 	// dict rather than copying
 	tmpMap := newProtoMap(mapKey, mapValue)
+	converted := false
 	for _, item := range d.Items() {
 		err := tmpMap.SetKey(item[0], item[1])
 		if err != nil {
 			return nil, err
 		}
+		if v, _, _ := tmpMap.dict.Get(item[0]); v != nil && v != item[1] {
+			converted = true
+		}
+	}
+
+	// Enum names and numbers, and the plain values of wrapper messages, are
+	// converted when typechecked. The converted values are kept in the
+	// temporary object, leaving the given dict (which may be frozen) as is
+	if converted {
+		out.dict = tmpMap.dict
+		return out, nil
 	}
 
 	// Remove any None values from map, see SetKey for compatibility behavior
@@ -173,7 +186,22 @@ func (m *protoMap) SetKey(k, v starlark.Value) error {
 		return err
 	}
 
-	// Typecheck value
+	// Typecheck value, converting enum names and numbers and the plain
+	// values of wrapper messages as on assignment to a field
+	if m.strict {
+		if err := checkStrictType(m.mapValue, v); err != nil {
+			return err
+		}
+	}
+	v, err = maybeConvertToEnum(m.mapValue, v)
+	if err != nil {
+		return err
+	}
+	if msg, err := maybeConvertToWrapper(m.mapValue, v); err != nil {
+		return err
+	} else if msg != nil {
+		v = msg
+	}
 	err = scalarTypeCheck(m.mapValue, v)
 	if err != nil {
 		return err
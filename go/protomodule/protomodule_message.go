@@ -18,7 +18,11 @@ package protomodule
 
 import (
 	"fmt"
+	"hash/fnv"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
@@ -83,6 +87,17 @@ func AsProtoMessage(v starlark.Value) (proto.Message, bool) {
 	return nil, false
 }
 
+// MessagePosition returns the position of the Starlark call that constructed
+// the given message value. The position is not valid if the message was not
+// constructed by calling a message type, for example if it was decoded or
+// created with NewMessage().
+func MessagePosition(v starlark.Value) syntax.Position {
+	if msg, ok := v.(*protoMessage); ok {
+		return msg.pos
+	}
+	return syntax.Position{}
+}
+
 // protoMessage exposes an underlying protobuf message as a starlark.Value
 //
 // Internally protoMessage tracks the message state on the `fields` map. Values
@@ -95,6 +110,14 @@ type protoMessage struct {
 	msgDesc protoreflect.MessageDescriptor
 	fields  map[string]starlark.Value
 	frozen  bool
+
+	// Whether field types are checked strictly, as for messages of the
+	// message types of NewStrictModule().
+	strict bool
+
+	// Where the message was constructed by calling its message type, if it
+	// was constructed from Starlark.
+	pos syntax.Position
 }
 
 var _ starlark.Value = (*protoMessage)(nil)
@@ -107,8 +130,22 @@ func (msg *protoMessage) String() string {
 }
 func (msg *protoMessage) Type() string         { return string(msg.msgDesc.FullName()) }
 func (msg *protoMessage) Truth() starlark.Bool { return starlark.True }
+
+// Hash returns a hash of the message's deterministic wire encoding, so that
+// messages which compare equal with `==` hash equally. Unknown fields are
+// included in the encoding as-is.
+//
+// Messages are hashable even if they aren't frozen, so that configs can use
+// the messages they construct as dict keys. Modifying a message changes its
+// hash, so a dict that already has it as a key no longer finds it.
 func (msg *protoMessage) Hash() (uint32, error) {
-	return 0, fmt.Errorf("unhashable type: %s", msg.Type())
+	data, err := (proto.MarshalOptions{Deterministic: true}).Marshal(msg.toProtoMessage())
+	if err != nil {
+		return 0, fmt.Errorf("unhashable type: %s: %v", msg.Type(), err)
+	}
+	h := fnv.New32a()
+	h.Write(data)
+	return h.Sum32(), nil
 }
 
 func (msg *protoMessage) Freeze() {
@@ -192,7 +229,17 @@ func (msg *protoMessage) Attr(name string) (starlark.Value, error) {
 	//   msg = MyProtoMessage()
 	//   msg.repeated_field.append("a")
 	//   # msg.repeated_field should be ["a"]
+	//
+	// The default of a frozen message can't be set, so it is frozen instead
+	// and changing it is an error rather than a change that is lost.
 	if fieldDesc.IsList() || fieldDesc.IsMap() || fieldDesc.Kind() == protoreflect.MessageKind {
+		if msg.frozen {
+			starlarkValue.Freeze()
+			return starlarkValue, nil
+		}
+		if msg.strict {
+			makeStrict(starlarkValue)
+		}
 		msg.SetField(name, starlarkValue)
 	}
 
@@ -224,6 +271,12 @@ func (msg *protoMessage) SetField(name string, val starlark.Value) error {
 		return err
 	}
 
+	if msg.strict {
+		if err := checkStrictValue(fieldDesc, val); err != nil {
+			return err
+		}
+	}
+
 	// Autoconvert starlark.List, starlark.Dict, wrapperspb on assignment
 	if fieldDesc.IsList() {
 		if starlarkListVal, ok := val.(*starlark.List); ok {
@@ -246,6 +299,7 @@ func (msg *protoMessage) SetField(name string, val starlark.Value) error {
 			if err != nil {
 				return err
 			}
+			list.strict = msg.strict
 
 			val = list
 		}
@@ -254,10 +308,17 @@ func (msg *protoMessage) SetField(name string, val starlark.Value) error {
 			// Convert stalark.Map into protoMap
 			mapVal, err := newProtoMapFromDict(fieldDesc.MapKey(), fieldDesc.MapValue(), starlarkDictVal)
 			if err != nil {
-				return err
+				return mapFieldError(fieldDesc, err)
 			}
+			mapVal.strict = msg.strict
 
 			val = mapVal
+		} else if mapVal, ok := val.(*protoMap); ok {
+			// A map of another field must have the same key and value
+			// types, which is checked entry by entry.
+			if _, err := valueFromStarlark(msg.msg.ProtoReflect(), fieldDesc, mapVal); err != nil {
+				return mapFieldError(fieldDesc, err)
+			}
 		}
 	} else if fieldDesc.Kind() == protoreflect.MessageKind {
 		msg, err := maybeConvertToWrapper(fieldDesc, val)
@@ -267,6 +328,12 @@ func (msg *protoMessage) SetField(name string, val starlark.Value) error {
 		if msg != nil {
 			val = msg
 		}
+	} else if fieldDesc.Kind() == protoreflect.EnumKind {
+		enum, err := maybeConvertToEnum(fieldDesc, val)
+		if err != nil {
+			return err
+		}
+		val = enum
 	}
 
 	// Allow using msg_field = None to unset a scalar message field
@@ -295,6 +362,22 @@ func (msg *protoMessage) SetField(name string, val starlark.Value) error {
 	return nil
 }
 
+// ClearOneof clears whichever field of the named oneof is set.
+func (msg *protoMessage) ClearOneof(name string) error {
+	oneof := msg.msgDesc.Oneofs().ByName(protoreflect.Name(name))
+	if oneof == nil {
+		return fmt.Errorf("AttributeError: `%s' value has no oneof %q", msg.msgDesc.FullName(), name)
+	}
+	if err := msg.CheckMutable("clear oneof of"); err != nil {
+		return err
+	}
+	fields := oneof.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		delete(msg.fields, string(fields.Get(i).Name()))
+	}
+	return nil
+}
+
 // Applies default values for proto2 fields with defaults that are not already set
 func (msg *protoMessage) SetDefaults() error {
 	if err := msg.CheckMutable("set field defaults of"); err != nil {
@@ -327,6 +410,68 @@ func (msg *protoMessage) SetDefaults() error {
 	return nil
 }
 
+// Matches one field of a path passed to ClearField, such as "items[2]".
+var fieldPathElemPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(?:\[(\d+)\])?$`)
+
+// ClearField unsets the field at the given path, which is a sequence of
+// field names separated by ".". Each field but the last must be a message,
+// or an element of a repeated message field selected by index as in
+// "items[2].name". Clearing a field within an unset message has no effect.
+func (msg *protoMessage) ClearField(path string) error {
+	elems := strings.Split(path, ".")
+	msgDesc := msg.msgDesc
+	current := msg
+	for i, elem := range elems {
+		match := fieldPathElemPattern.FindStringSubmatch(elem)
+		if match == nil {
+			return fmt.Errorf("invalid field path %q", path)
+		}
+		name, hasIndex := match[1], match[2] != ""
+		fieldDesc := getFieldDescriptor(msgDesc, name)
+		if fieldDesc == nil {
+			return fmt.Errorf("AttributeError: `%s' value has no field %q", msgDesc.FullName(), name)
+		}
+
+		if i == len(elems)-1 && !hasIndex {
+			if current == nil {
+				return nil
+			}
+			if err := current.CheckMutable("clear field of"); err != nil {
+				return err
+			}
+			delete(current.fields, name)
+			return nil
+		}
+
+		if i == len(elems)-1 {
+			return fmt.Errorf("invalid field path %q: must end with a field name", path)
+		}
+		if fieldDesc.Kind() != protoreflect.MessageKind || fieldDesc.IsMap() || fieldDesc.IsList() != hasIndex {
+			return fmt.Errorf("invalid field path %q: %q is not a message field of %s", path, elem, msgDesc.FullName())
+		}
+		var next *protoMessage
+		if hasIndex {
+			index, err := strconv.Atoi(match[2])
+			if err != nil {
+				return fmt.Errorf("invalid field path %q", path)
+			}
+			var list starlark.Indexable
+			if current != nil {
+				list, _ = current.fields[name].(starlark.Indexable)
+			}
+			if list == nil || index >= list.Len() {
+				return fmt.Errorf("index %d out of range for field %q of %s", index, name, msgDesc.FullName())
+			}
+			next, _ = list.Index(index).(*protoMessage)
+		} else if current != nil {
+			next, _ = current.fields[name].(*protoMessage)
+		}
+		current = next
+		msgDesc = fieldDesc.Message()
+	}
+	return nil
+}
+
 // Merges values from other into msg following proto.Merge logic
 func (msg *protoMessage) Merge(other *protoMessage) error {
 	if msg.Type() != other.Type() {
@@ -378,6 +523,12 @@ func (msg *protoMessage) toProtoMessage() proto.Message {
 	return out
 }
 
+// mapFieldError annotates an error in a key or value assigned to a map field
+// with the name of the field.
+func mapFieldError(fieldDesc protoreflect.FieldDescriptor, err error) error {
+	return fmt.Errorf("%s: %w", fieldDesc.FullName(), err)
+}
+
 func getFieldDescriptor(msgDesc protoreflect.MessageDescriptor, fieldName string) protoreflect.FieldDescriptor {
 	fields := msgDesc.Fields()
 	for i := 0; i < fields.Len(); i++ {
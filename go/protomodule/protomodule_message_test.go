@@ -17,15 +17,24 @@
 package protomodule
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
 	"sort"
 	"testing"
 
+	starlarktime "go.starlark.net/lib/time"
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
+	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	pb "github.com/stripe/skycfg/internal/testdata/test_proto"
@@ -67,6 +76,11 @@ func TestMessageAttrNames(t *testing.T) {
 		"f_Uint64Value",
 		"r_StringValue",
 		"f_Any",
+		"f_FloatValue",
+		"f_Duration",
+		"f_Timestamp",
+		"r_toplevel_enum",
+		"map_toplevel_enum",
 	}
 	sort.Strings(want)
 	if !reflect.DeepEqual(want, got) {
@@ -433,8 +447,18 @@ func TestAttrValidation(t *testing.T) {
 		},
 		{
 			name:    "enum",
-			src:     `pb.MessageV3(f_toplevel_enum = 0)`,
-			wantErr: fmt.Errorf(`TypeError: value 0 (type "int") can't be assigned to type "skycfg.test_proto.ToplevelEnumV3".`),
+			src:     `pb.MessageV3(f_toplevel_enum = 0.0)`,
+			wantErr: fmt.Errorf(`TypeError: value 0.0 (type "float") can't be assigned to type "skycfg.test_proto.ToplevelEnumV3".`),
+		},
+		{
+			name:    "enum name",
+			src:     `pb.MessageV3(f_toplevel_enum = "toplevel_enum_v3_b")`,
+			wantErr: fmt.Errorf(`ValueError: enum "toplevel_enum_v3_b" not found in skycfg.test_proto.ToplevelEnumV3`),
+		},
+		{
+			name:    "enum number",
+			src:     `pb.MessageV3(f_toplevel_enum = 100)`,
+			wantErr: fmt.Errorf(`ValueError: enum 100 out of bounds for skycfg.test_proto.ToplevelEnumV3`),
 		},
 
 		// Non-scalar type mismatch
@@ -456,17 +480,17 @@ func TestAttrValidation(t *testing.T) {
 		{
 			name:    "string map key assignment",
 			src:     `pb.MessageV3(map_string = {123: ''})`,
-			wantErr: fmt.Errorf(`TypeError: value 123 (type "int") can't be assigned to type "string".`),
+			wantErr: fmt.Errorf(`skycfg.test_proto.MessageV3.map_string: TypeError: value 123 (type "int") can't be assigned to type "string".`),
 		},
 		{
 			name:    "string map value assignment",
 			src:     `pb.MessageV3(map_string = {'': 456})`,
-			wantErr: fmt.Errorf(`TypeError: value 456 (type "int") can't be assigned to type "string".`),
+			wantErr: fmt.Errorf(`skycfg.test_proto.MessageV3.map_string: TypeError: value 456 (type "int") can't be assigned to type "string".`),
 		},
 		{
 			name:    "message map value assignment",
 			src:     `pb.MessageV3(map_submsg = {'': 456})`,
-			wantErr: fmt.Errorf(`TypeError: value 456 (type "int") can't be assigned to type "skycfg.test_proto.MessageV3".`),
+			wantErr: fmt.Errorf(`skycfg.test_proto.MessageV3.map_submsg: TypeError: value 456 (type "int") can't be assigned to type "skycfg.test_proto.MessageV3".`),
 		},
 		{
 			name:    "message assignment with wrong type",
@@ -548,6 +572,122 @@ func TestAttrValidation(t *testing.T) {
 	runSkycfgTests(t, tests, withGlobals(globals))
 }
 
+func TestEnumConversions(t *testing.T) {
+	frozenDict := starlark.NewDict(1)
+	frozenDict.SetKey(starlark.String("k"), starlark.String("TOPLEVEL_ENUM_V3_B"))
+	globals := starlark.StringDict{
+		"pb":          NewProtoPackage(newRegistry(), "skycfg.test_proto"),
+		"frozen_list": starlark.NewList([]starlark.Value{starlark.String("TOPLEVEL_ENUM_V3_B")}),
+		"frozen_dict": frozenDict,
+	}
+	globals.Freeze()
+
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "enum by name",
+			src:  `pb.MessageV3(f_toplevel_enum = "TOPLEVEL_ENUM_V3_B").f_toplevel_enum`,
+			want: `<skycfg.test_proto.ToplevelEnumV3 TOPLEVEL_ENUM_V3_B=1>`,
+		},
+		{
+			name: "enum by number",
+			src:  `pb.MessageV3(f_nested_enum = 1).f_nested_enum == pb.MessageV3.NestedEnum.NESTED_ENUM_B`,
+			want: `True`,
+		},
+		{
+			name: "enum name and number",
+			src:  `[pb.MessageV3(f_toplevel_enum = 1).f_toplevel_enum.name, pb.ToplevelEnumV3.TOPLEVEL_ENUM_V3_B.number]`,
+			want: `["TOPLEVEL_ENUM_V3_B", 1]`,
+		},
+		{
+			name: "repeated enum",
+			srcFunc: `
+def fun():
+    l = ["TOPLEVEL_ENUM_V3_B", 0]
+    msg = pb.MessageV3(r_toplevel_enum = l)
+    return [l, msg.r_toplevel_enum]
+`,
+			want: `[["TOPLEVEL_ENUM_V3_B", 0], [<skycfg.test_proto.ToplevelEnumV3 TOPLEVEL_ENUM_V3_B=1>, <skycfg.test_proto.ToplevelEnumV3 TOPLEVEL_ENUM_V3_A=0>]]`,
+		},
+		{
+			name: "map enum",
+			srcFunc: `
+def fun():
+    d = {"k": 1}
+    msg = pb.MessageV3(map_toplevel_enum = d)
+    return [d, msg.map_toplevel_enum]
+`,
+			want: `[{"k": 1}, {"k": <skycfg.test_proto.ToplevelEnumV3 TOPLEVEL_ENUM_V3_B=1>}]`,
+		},
+		{
+			name: "frozen repeated and map enum",
+			srcFunc: `
+def fun():
+    msg = pb.MessageV3(r_toplevel_enum = frozen_list, map_toplevel_enum = frozen_dict)
+    return [frozen_list, frozen_dict, msg.r_toplevel_enum, msg.map_toplevel_enum]
+`,
+			want: `[["TOPLEVEL_ENUM_V3_B"], {"k": "TOPLEVEL_ENUM_V3_B"}, [<skycfg.test_proto.ToplevelEnumV3 TOPLEVEL_ENUM_V3_B=1>], {"k": <skycfg.test_proto.ToplevelEnumV3 TOPLEVEL_ENUM_V3_B=1>}]`,
+		},
+	}, withGlobals(globals))
+}
+
+func TestWellKnownTypeConversions(t *testing.T) {
+	timeGlobals := starlark.StringDict{
+		"proto": NewModule(newRegistry()),
+		"time":  starlarktime.Module,
+	}
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "wrappers from scalars",
+			src: `proto.package("skycfg.test_proto").MessageV3(
+				f_FloatValue = 1.5,
+				f_Int32Value = 5,
+			)`,
+			want: &pb.MessageV3{
+				F_FloatValue: &wrapperspb.FloatValue{Value: 1.5},
+				F_Int32Value: &wrapperspb.Int32Value{Value: 5},
+			},
+		},
+		{
+			name: "duration from string",
+			src:  `proto.package("skycfg.test_proto").MessageV3(f_Duration = "1m30.5s")`,
+			want: &pb.MessageV3{
+				F_Duration: &durationpb.Duration{Seconds: 90, Nanos: 500000000},
+			},
+		},
+		{
+			name: "duration from number of seconds",
+			src:  `[proto.package("skycfg.test_proto").MessageV3(f_Duration = d).f_Duration.seconds for d in [90, 1.5, -2]]`,
+			want: `[90, 1, -2]`,
+		},
+		{
+			name:    "duration from time.duration",
+			src:     `proto.package("skycfg.test_proto").MessageV3(f_Duration = time.parse_duration("2h"))`,
+			globals: timeGlobals,
+			want: &pb.MessageV3{
+				F_Duration: &durationpb.Duration{Seconds: 7200},
+			},
+		},
+		{
+			name:    "timestamp from time.time",
+			src:     `proto.package("skycfg.test_proto").MessageV3(f_Timestamp = time.from_timestamp(1600000000, 5))`,
+			globals: timeGlobals,
+			want: &pb.MessageV3{
+				F_Timestamp: &timestamppb.Timestamp{Seconds: 1600000000, Nanos: 5},
+			},
+		},
+		{
+			name:    "invalid duration string",
+			src:     `proto.package("skycfg.test_proto").MessageV3(f_Duration = "soon")`,
+			wantErr: errors.New(`ValueError: value "soon" is not a valid duration: time: invalid duration "soon"`),
+		},
+		{
+			name:    "timestamp type mismatch",
+			src:     `proto.package("skycfg.test_proto").MessageV3(f_Timestamp = "2020-01-01")`,
+			wantErr: errors.New(`TypeError: value "2020-01-01" (type "string") can't be assigned to type "google.protobuf.Timestamp".`),
+		},
+	})
+}
+
 func TestProtoMessageString(t *testing.T) {
 	runSkycfgTests(t, []skycfgTest{
 		{
@@ -986,6 +1126,142 @@ func TestProtoMergeV3(t *testing.T) {
 	checkProtoEqual(t, msg1, gotMsg)
 }
 
+func TestProtoMergeFieldMask(t *testing.T) {
+	frozenSubmsg, err := NewMessage(&pb.MessageV3{
+		FSubmsg: &pb.MessageV3{FString: "frozen"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frozenGlobals := starlark.StringDict{
+		"proto":         NewModule(newRegistry()),
+		"frozen_submsg": frozenSubmsg,
+	}
+	frozenGlobals.Freeze()
+
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "replaces only masked fields",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    dst = pkg.MessageV3(
+        f_int32 = 1010,
+        f_string = "dst string",
+        r_string = ["dst 1", "dst 2"],
+        map_string = {"a": "dst"},
+        f_submsg = pkg.MessageV3(f_int32 = 1010, f_string = "dst submsg"),
+    )
+    src = pkg.MessageV3(
+        f_int32 = 2010,
+        f_string = "src string",
+        r_string = ["src 1"],
+        map_string = {"b": "src"},
+        f_submsg = pkg.MessageV3(f_int32 = 2010, f_string = "src submsg"),
+    )
+    return proto.merge(dst, src, field_mask = ["f_string", "r_string", "map_string", "f_submsg.f_string"])
+`,
+			want: &pb.MessageV3{
+				FInt32:    1010,
+				FString:   "src string",
+				RString:   []string{"src 1"},
+				MapString: map[string]string{"b": "src"},
+				FSubmsg: &pb.MessageV3{
+					FInt32:  1010,
+					FString: "src submsg",
+				},
+			},
+		},
+		{
+			name: "clears fields unset in src",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    dst = pkg.MessageV3(
+        f_int32 = 1010,
+        r_string = ["dst 1"],
+        f_submsg = pkg.MessageV3(f_int32 = 1010, f_string = "dst submsg"),
+    )
+    return proto.merge(dst, pkg.MessageV3(), field_mask = ["r_string", "f_submsg.f_string"])
+`,
+			want: &pb.MessageV3{
+				FInt32: 1010,
+				FSubmsg: &pb.MessageV3{
+					FInt32: 1010,
+				},
+			},
+		},
+		{
+			name: "creates intermediate messages",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    src = pkg.MessageV3(f_submsg = pkg.MessageV3(f_submsg = pkg.MessageV3(f_string = "src")))
+    return proto.merge(pkg.MessageV3(), src, field_mask = ("f_submsg.f_submsg.f_string",))
+`,
+			want: &pb.MessageV3{
+				FSubmsg: &pb.MessageV3{
+					FSubmsg: &pb.MessageV3{
+						FString: "src",
+					},
+				},
+			},
+		},
+		{
+			name: "does not share values with src",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    src = pkg.MessageV3(r_string = ["src"])
+    dst = proto.merge(pkg.MessageV3(), src, field_mask = ["r_string"])
+    dst.r_string.append("dst")
+    return src
+`,
+			want: &pb.MessageV3{
+				RString: []string{"src"},
+			},
+		},
+		{
+			name: "does not modify messages of dst",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    submsg = pkg.MessageV3(f_string = "dst submsg")
+    dst = pkg.MessageV3(f_submsg = submsg)
+    proto.merge(dst, pkg.MessageV3(), field_mask = ["f_submsg.f_string"])
+    return submsg
+`,
+			want: &pb.MessageV3{
+				FString: "dst submsg",
+			},
+		},
+		{
+			name: "does not modify frozen messages of dst",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    dst = pkg.MessageV3(f_submsg = frozen_submsg)
+    src = pkg.MessageV3(f_submsg = pkg.MessageV3(f_submsg = pkg.MessageV3(f_int32 = 2010)))
+    proto.merge(dst, pkg.MessageV3(), field_mask = ["f_submsg.f_submsg.f_string"])
+    proto.merge(dst, src, field_mask = ["f_submsg.f_submsg.f_int32"])
+    return [frozen_submsg, dst]
+`,
+			globals: frozenGlobals,
+			want:    `[<skycfg.test_proto.MessageV3 f_submsg:{f_string:"frozen"}>, <skycfg.test_proto.MessageV3 f_submsg:{f_submsg:{f_int32:2010}}>]`,
+		},
+		{
+			name:    "invalid path",
+			src:     `proto.merge(proto.package("skycfg.test_proto").MessageV3(), proto.package("skycfg.test_proto").MessageV3(), field_mask = ["r_string.f_string"])`,
+			wantErr: errors.New(`proto.merge: for parameter field_mask: invalid path "r_string.f_string" for message skycfg.test_proto.MessageV3`),
+		},
+		{
+			name:    "not a list",
+			src:     `proto.merge(proto.package("skycfg.test_proto").MessageV3(), proto.package("skycfg.test_proto").MessageV3(), field_mask = "f_string")`,
+			wantErr: errors.New(`proto.merge: for parameter field_mask: got string, want list of field paths`),
+		},
+	})
+}
+
 func TestProtoMergeDiffTypes(t *testing.T) {
 	errorMsg := "proto.merge: types are not the same: got skycfg.test_proto.MessageV3 and skycfg.test_proto.MessageV2"
 	globals := starlark.StringDict{
@@ -1001,6 +1277,285 @@ func TestProtoMergeDiffTypes(t *testing.T) {
 	}
 }
 
+// newMapTestRegistry returns the test registry with an added message type
+// that has map fields with key and value types not used by the test protos.
+func newMapTestRegistry(t *testing.T) *protoregistry.Types {
+	t.Helper()
+	var fdProto descriptorpb.FileDescriptorProto
+	err := prototext.Unmarshal([]byte(`
+		name: "map_test.proto"
+		package: "skycfg.map_test"
+		dependency: ["test_proto_v3.proto", "google/protobuf/wrappers.proto"]
+		syntax: "proto3"
+		message_type: {
+			name: "MapMessage"
+			field: {
+				name: "map_int32_submsg" json_name: "mapInt32Submsg" number: 1
+				label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".skycfg.map_test.MapMessage.MapInt32SubmsgEntry"
+			}
+			field: {
+				name: "map_string_wrapper" json_name: "mapStringWrapper" number: 2
+				label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".skycfg.map_test.MapMessage.MapStringWrapperEntry"
+			}
+			nested_type: {
+				name: "MapInt32SubmsgEntry"
+				field: {name: "key" json_name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_INT32}
+				field: {name: "value" json_name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".skycfg.test_proto.MessageV3"}
+				options: {map_entry: true}
+			}
+			nested_type: {
+				name: "MapStringWrapperEntry"
+				field: {name: "key" json_name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING}
+				field: {name: "value" json_name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".google.protobuf.StringValue"}
+				options: {map_entry: true}
+			}
+		}
+	`), &fdProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(&fdProto, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := newRegistry()
+	if err := registry.RegisterMessage(dynamicpb.NewMessageType(fd.Messages().ByName("MapMessage"))); err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestMapFieldAssignment(t *testing.T) {
+	globals := starlark.StringDict{
+		"proto": NewModule(newMapTestRegistry(t)),
+	}
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "map<string, string>",
+			srcFunc: `
+def fun():
+    pb = proto.package("skycfg.test_proto")
+    msg = pb.MessageV3()
+    msg.map_string = {"a": "A", "b": "B"}
+    return sorted(proto.clone(msg).map_string.items())
+`,
+			want: `[("a", "A"), ("b", "B")]`,
+		},
+		{
+			name: "map<int32, Msg>",
+			srcFunc: `
+def fun():
+    pb = proto.package("skycfg.test_proto")
+    msg = proto.package("skycfg.map_test").MapMessage()
+    msg.map_int32_submsg = {1: pb.MessageV3(f_string = "a"), 2: pb.MessageV3()}
+    clone = proto.clone(msg)
+    return [sorted(clone.map_int32_submsg.keys()), clone.map_int32_submsg[1].f_string]
+`,
+			want: `[[1, 2], "a"]`,
+		},
+		{
+			name: "wrapper message values from plain values",
+			srcFunc: `
+def fun():
+    msg = proto.package("skycfg.map_test").MapMessage(map_string_wrapper = {"a": "A"})
+    msg.map_string_wrapper["b"] = "B"
+    return [msg.map_string_wrapper["a"].value, proto.clone(msg).map_string_wrapper["b"].value]
+`,
+			want: `["A", "B"]`,
+		},
+		{
+			name: "empty maps",
+			srcFunc: `
+def fun():
+    msg = proto.package("skycfg.map_test").MapMessage(map_int32_submsg = {})
+    msg.map_string_wrapper = {}
+    return [proto.has(msg, "map_int32_submsg"), len(proto.clone(msg).map_string_wrapper)]
+`,
+			want: `[False, 0]`,
+		},
+		{
+			name: "map of another field",
+			srcFunc: `
+def fun():
+    pb = proto.package("skycfg.test_proto")
+    msg = pb.MessageV3(map_string = {"a": "A"})
+    return pb.MessageV2(map_string = msg.map_string).map_string
+`,
+			want: `{"a": "A"}`,
+		},
+		{
+			name:    "mismatched key",
+			src:     `proto.package("skycfg.map_test").MapMessage(map_int32_submsg = {"a": proto.package("skycfg.test_proto").MessageV3()})`,
+			wantErr: errors.New(`skycfg.map_test.MapMessage.map_int32_submsg: TypeError: value "a" (type "string") can't be assigned to type "int32".`),
+		},
+		{
+			name:    "mismatched message value",
+			src:     `proto.package("skycfg.map_test").MapMessage(map_int32_submsg = {1: proto.package("skycfg.test_proto").MessageV2()})`,
+			wantErr: errors.New(`skycfg.map_test.MapMessage.map_int32_submsg: TypeError: value <skycfg.test_proto.MessageV2 > (type "skycfg.test_proto.MessageV2") can't be assigned to type "skycfg.test_proto.MessageV3".`),
+		},
+		{
+			name:    "map of another field with different types",
+			src:     `proto.package("skycfg.map_test").MapMessage(map_string_wrapper = proto.package("skycfg.test_proto").MessageV3(map_submsg = {"a": proto.package("skycfg.test_proto").MessageV3()}).map_submsg)`,
+			wantErr: errors.New(`skycfg.map_test.MapMessage.map_string_wrapper: TypeError: value <skycfg.test_proto.MessageV3 > (type "skycfg.test_proto.MessageV3") can't be assigned to type "google.protobuf.StringValue".`),
+		},
+	}, withGlobals(globals))
+}
+
+func newStrictTestRegistry(t *testing.T) *protoregistry.Types {
+	t.Helper()
+	var fdProto descriptorpb.FileDescriptorProto
+	err := prototext.Unmarshal([]byte(`
+		name: "strict_test.proto"
+		package: "skycfg.strict_test"
+		syntax: "proto3"
+		message_type: {
+			name: "DoubleMessage"
+			field: {
+				name: "r_double" json_name: "rDouble" number: 1
+				label: LABEL_REPEATED type: TYPE_DOUBLE
+			}
+			field: {
+				name: "map_double" json_name: "mapDouble" number: 2
+				label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".skycfg.strict_test.DoubleMessage.MapDoubleEntry"
+			}
+			nested_type: {
+				name: "MapDoubleEntry"
+				field: {name: "key" json_name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING}
+				field: {name: "value" json_name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_DOUBLE}
+				options: {map_entry: true}
+			}
+		}
+	`), &fdProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(&fdProto, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := newRegistry()
+	if err := registry.RegisterMessage(dynamicpb.NewMessageType(fd.Messages().ByName("DoubleMessage"))); err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestStrictFieldTypes(t *testing.T) {
+	registry := newStrictTestRegistry(t)
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "lenient int to float conversion",
+			srcFunc: `
+def fun():
+    pb = proto.package("skycfg.test_proto")
+    msg = pb.MessageV3(f_float32 = 1, f_DoubleValue = 2, f_Duration = 90)
+    msg.f_float64 = 3
+    doubles = proto.package("skycfg.strict_test").DoubleMessage(r_double = [4], map_double = {"a": 5})
+    doubles.r_double.append(6)
+    doubles.map_double["b"] = 7
+    return [msg.f_float32, msg.f_DoubleValue.value, msg.f_float64, list(doubles.r_double), doubles.map_double["b"], msg.f_Duration.seconds]
+`,
+			want: `[1, 2.0, 3, [4, 6], 7, 90]`,
+		},
+	}, withGlobals(starlark.StringDict{"proto": NewModule(registry)}))
+
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "float values",
+			srcFunc: `
+def fun():
+    pb = proto.package("skycfg.test_proto")
+    msg = pb.MessageV3(f_float32 = 1.0, f_DoubleValue = 3.0, f_int32 = 4)
+    msg.f_float64 = 2.5
+    doubles = proto.package("skycfg.strict_test").DoubleMessage(r_double = [6.0], map_double = {"a": 7.0})
+    doubles.r_double.append(8.0)
+    doubles.map_double["b"] = 9.0
+    return [msg.f_float32, msg.f_float64, msg.f_DoubleValue.value, list(doubles.r_double), doubles.map_double["b"]]
+`,
+			want: `[1.0, 2.5, 3.0, [6.0, 8.0], 9.0]`,
+		},
+		{
+			name: "other conversions",
+			srcFunc: `
+def fun():
+    msg = proto.package("skycfg.test_proto").MessageV3(f_Int32Value = 1, f_StringValue = "a", f_Duration = "1m30s")
+    return [msg.f_Int32Value.value, msg.f_StringValue.value, msg.f_Duration.seconds]
+`,
+			want: `[1, "a", 90]`,
+		},
+		{
+			name:    "int assigned to float",
+			src:     `proto.package("skycfg.test_proto").MessageV3(f_float32 = 1)`,
+			wantErr: errors.New(`TypeError: value 1 (type "int") can't be assigned to type "float".`),
+		},
+		{
+			name:    "int assigned to double wrapper",
+			src:     `proto.package("skycfg.test_proto").MessageV3(f_DoubleValue = 1)`,
+			wantErr: errors.New(`TypeError: value 1 (type "int") can't be assigned to type "google.protobuf.DoubleValue".`),
+		},
+		{
+			name:    "number assigned to duration",
+			src:     `proto.package("skycfg.test_proto").MessageV3(f_Duration = 1.5)`,
+			wantErr: errors.New(`TypeError: value 1.5 (type "float") can't be assigned to type "google.protobuf.Duration".`),
+		},
+		{
+			name: "int assigned to field",
+			srcFunc: `
+def fun():
+    msg = proto.package("skycfg.test_proto").MessageV3()
+    msg.f_float64 = 1
+`,
+			wantErr: errors.New(`TypeError: value 1 (type "int") can't be assigned to type "double".`),
+		},
+		{
+			name: "int assigned to field of decoded message",
+			srcFunc: `
+def fun():
+    msg = proto.decode_json(proto.package("skycfg.test_proto").MessageV3, '{"f_float64": 1}')
+    msg.f_float64 = 2
+`,
+			wantErr: errors.New(`TypeError: value 2 (type "int") can't be assigned to type "double".`),
+		},
+		{
+			name: "int assigned to field of cloned message",
+			srcFunc: `
+def fun():
+    msg = proto.clone(proto.package("skycfg.test_proto").MessageV3())
+    msg.f_float64 = 1
+`,
+			wantErr: errors.New(`TypeError: value 1 (type "int") can't be assigned to type "double".`),
+		},
+		{
+			name:    "int in list",
+			src:     `proto.package("skycfg.strict_test").DoubleMessage(r_double = [1.0, 2])`,
+			wantErr: errors.New(`TypeError: value 2 (type "int") can't be assigned to type "double".`),
+		},
+		{
+			name: "int appended to list",
+			srcFunc: `
+def fun():
+    msg = proto.package("skycfg.strict_test").DoubleMessage()
+    msg.r_double.append(1)
+`,
+			wantErr: errors.New(`TypeError: value 1 (type "int") can't be assigned to type "double".`),
+		},
+		{
+			name:    "int in map",
+			src:     `proto.package("skycfg.strict_test").DoubleMessage(map_double = {"a": 1})`,
+			wantErr: errors.New(`skycfg.strict_test.DoubleMessage.map_double: TypeError: value 1 (type "int") can't be assigned to type "double".`),
+		},
+		{
+			name: "int set in map",
+			srcFunc: `
+def fun():
+    msg = proto.package("skycfg.strict_test").DoubleMessage(map_double = {})
+    msg.map_double["a"] = 1
+`,
+			wantErr: errors.New(`TypeError: value 1 (type "int") can't be assigned to type "double".`),
+		},
+	}, withGlobals(starlark.StringDict{"proto": NewStrictModule(registry)}))
+}
+
 // Pre 1.0 Skycfg allowed maps to be constructed with None values for proto2 (see protoMap.SetKey)
 func TestMapNoneCompatibility(t *testing.T) {
 	runSkycfgTests(t, []skycfgTest{
@@ -1062,7 +1617,7 @@ def fun():
     )
     return msg
 `,
-			wantErr: fmt.Errorf(`TypeError: value None (type "NoneType") can't be assigned to type "string" in proto3 mode.`),
+			wantErr: fmt.Errorf(`skycfg.test_proto.MessageV3.map_string: TypeError: value None (type "NoneType") can't be assigned to type "string" in proto3 mode.`),
 		},
 		// An odd resulting behavior of both ensuring assignment does not copy
 		// and setting to None deletes is that assignment can mutate a raw starlark dict
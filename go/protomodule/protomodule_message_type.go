@@ -28,8 +28,9 @@ import (
 )
 
 // newMessageType creates a Starlark value representing a named Protobuf message
-// type that can be used for constructing new concrete protobuf objects.
-func newMessageType(registry *protoregistry.Types, msg protoreflect.ProtoMessage) starlark.Callable {
+// type that can be used for constructing new concrete protobuf objects. The
+// messages of a strict message type check field types strictly.
+func newMessageType(registry *protoregistry.Types, msg protoreflect.ProtoMessage, strict bool) starlark.Callable {
 	attrs := make(starlark.StringDict)
 
 	descriptor := msg.ProtoReflect().Type().Descriptor()
@@ -51,7 +52,7 @@ func newMessageType(registry *protoregistry.Types, msg protoreflect.ProtoMessage
 				childMsg = dynamicpb.NewMessageType(child)
 			}
 
-			attrs[string(child.Name())] = newMessageType(registry, childMsg.New().Interface())
+			attrs[string(child.Name())] = newMessageType(registry, childMsg.New().Interface(), strict)
 		}
 	}
 
@@ -62,6 +63,7 @@ func newMessageType(registry *protoregistry.Types, msg protoreflect.ProtoMessage
 		descriptor: descriptor,
 		attrs:      attrs,
 		emptyMsg:   emptyMsg,
+		strict:     strict,
 	}
 }
 
@@ -71,6 +73,9 @@ type protoMessageType struct {
 
 	// An empty protobuf message of the appropriate type.
 	emptyMsg protoreflect.ProtoMessage
+
+	// Whether messages of this type check field types strictly.
+	strict bool
 }
 
 var _ starlark.HasAttrs = (*protoMessageType)(nil)
@@ -140,10 +145,14 @@ func (t *protoMessageType) CallInternal(
 	}
 
 	// Instantiate a new message and populate the fields
-	out, err := NewMessage(t.emptyMsg)
+	out, err := newMessageOfType(t, t.emptyMsg)
 	if err != nil {
 		return nil, err
 	}
+	// Frame 0 is this constructor; frame 1 is its caller.
+	if thread.CallStackDepth() > 1 {
+		out.pos = thread.CallFrame(1).Pos
+	}
 	for fieldName, starlarkValue := range parsedKwargs {
 		if *starlarkValue == nil {
 			continue
@@ -160,3 +169,16 @@ func (t *protoMessageType) CallInternal(
 func (t *protoMessageType) NewMessage() protoreflect.ProtoMessage {
 	return proto.Clone(t.emptyMsg)
 }
+
+// newMessageOfType returns a Starlark value for a message of the given
+// message type, which checks field types strictly if the type does.
+func newMessageOfType(msgType starlark.Value, msg proto.Message) (*protoMessage, error) {
+	out, err := NewMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := msgType.(*protoMessageType); ok {
+		out.strict = t.strict
+	}
+	return out, nil
+}
@@ -25,7 +25,7 @@ import (
 	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
-func starlarkPackageFn(registry *protoregistry.Types) starlark.Callable {
+func starlarkPackageFn(registry *protoregistry.Types, strict bool) starlark.Callable {
 	return starlark.NewBuiltin("proto.package", func(
 		t *starlark.Thread,
 		fn *starlark.Builtin,
@@ -40,7 +40,7 @@ func starlarkPackageFn(registry *protoregistry.Types) starlark.Callable {
 		if !packageName.IsValid() {
 			return nil, fmt.Errorf("invalid Protobuf package name %q", packageName)
 		}
-		return NewProtoPackage(registry, packageName), nil
+		return newProtoPackage(registry, packageName, strict), nil
 	})
 }
 
@@ -53,6 +53,14 @@ type protoPackage struct {
 func NewProtoPackage(
 	registry *protoregistry.Types,
 	packageName protoreflect.FullName,
+) *protoPackage {
+	return newProtoPackage(registry, packageName, false)
+}
+
+func newProtoPackage(
+	registry *protoregistry.Types,
+	packageName protoreflect.FullName,
+	strict bool,
 ) *protoPackage {
 	attrs := make(starlark.StringDict)
 
@@ -69,7 +77,7 @@ func NewProtoPackage(
 		desc := t.Descriptor()
 		name := desc.Name()
 		if packageName.Append(name) == desc.FullName() {
-			msgType := newMessageType(registry, t.New().Interface())
+			msgType := newMessageType(registry, t.New().Interface(), strict)
 			attrs[string(name)] = msgType
 		}
 		return true
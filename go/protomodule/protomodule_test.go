@@ -17,7 +17,9 @@
 package protomodule
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -55,7 +57,7 @@ func TestProtoPackage(t *testing.T) {
 		"proto": &starlarkstruct.Module{
 			Name: "proto",
 			Members: starlark.StringDict{
-				"package": starlarkPackageFn(newRegistry()),
+				"package": starlarkPackageFn(newRegistry(), false),
 			},
 		},
 	}
@@ -477,6 +479,56 @@ func TestProtoEnumEqual(t *testing.T) {
 	})
 }
 
+func TestProtoMessageHash(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "equal messages are one dict key",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    d = {}
+    d[pkg.MessageV3(f_string = "a", map_string = {"x": "1", "y": "2"})] = 1
+    d[pkg.MessageV3(map_string = {"y": "2", "x": "1"}, f_string = "a")] = 2
+    d[pkg.MessageV3(f_string = "b")] = 3
+    return len(d)
+`,
+			want: `2`,
+		},
+		{
+			name: "nested messages",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    d = {pkg.MessageV3(f_submsg = pkg.MessageV3(f_int32 = 1)): 1}
+    return d.get(pkg.MessageV3(f_submsg = pkg.MessageV3(f_int32 = 1)))
+`,
+			want: `1`,
+		},
+		{
+			name: "unset and zero proto3 fields",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    d = {pkg.MessageV3(): 1}
+    return d.get(pkg.MessageV3(f_int32 = 0))
+`,
+			want: `1`,
+		},
+		{
+			name: "modified after use as a key",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV3(f_string = "a")
+    d = {msg: 1}
+    msg.f_string = "b"
+    return [d.get(msg), d.get(pkg.MessageV3(f_string = "a")), len(d)]
+`,
+			want: `[None, None, 1]`,
+		},
+	})
+}
+
 func TestProtoText(t *testing.T) {
 	runSkycfgTests(t, []skycfgTest{
 		{
@@ -507,6 +559,26 @@ proto.encode_text(proto.package("skycfg.test_proto").MessageV3(
 			wantType:          "string",
 			removeRandomSpace: true,
 		},
+		{
+			name: "proto.encode_text deterministic",
+			src: `proto.encode_text(proto.package("skycfg.test_proto").MessageV3(
+    f_int32 = 1010,
+    f_string = "two  spaces",
+    f_submsg = proto.package("skycfg.test_proto").MessageV3(f_string = "a"),
+    map_string = {"b": "2", "a": "1"},
+))`,
+			want:     `"f_int32:1010 f_string:\"two  spaces\" f_submsg:{f_string:\"a\"} map_string:{key:\"a\" value:\"1\"} map_string:{key:\"b\" value:\"2\"}"`,
+			wantType: "string",
+		},
+		{
+			name: "proto.encode_text deterministic full",
+			src: `proto.encode_text(proto.package("skycfg.test_proto").MessageV3(
+    f_int32 = 1010,
+    f_submsg = proto.package("skycfg.test_proto").MessageV3(f_string = "a"),
+), compact=False, deterministic=True)`,
+			want:     `"f_int32: 1010\nf_submsg: {\n  f_string: \"a\"\n}\n"`,
+			wantType: "string",
+		},
 		{
 			name: "proto.decode_text",
 			src:  `proto.decode_text(proto.package("skycfg.test_proto").MessageV3, "f_int32: 1010").f_int32`,
@@ -515,6 +587,21 @@ proto.encode_text(proto.package("skycfg.test_proto").MessageV3(
 	})
 }
 
+func TestNormalizeTextSpace(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want string
+	}{
+		{`a:1  b:"x  y"  c:{d:2}`, `a:1 b:"x  y" c:{d:2}`},
+		{`a:'it\'s  ok'  b:"\"  \""`, `a:'it\'s  ok' b:"\"  \""`},
+		{"a:  1\nb:  {\n  c:  \"  \"\n}\n", "a: 1\nb: {\n  c: \"  \"\n}\n"},
+	} {
+		if got := string(normalizeTextSpace([]byte(test.text))); got != test.want {
+			t.Errorf("normalizeTextSpace(%q): wanted %q, got %q", test.text, test.want, got)
+		}
+	}
+}
+
 func TestProtoJson(t *testing.T) {
 	runSkycfgTests(t, []skycfgTest{
 		{
@@ -547,6 +634,11 @@ func TestProtoJson(t *testing.T) {
 			src:  `proto.decode_json(proto.package("skycfg.test_proto").MessageV3, "{\"f_int32\": 1010}").f_int32`,
 			want: "1010",
 		},
+		{
+			name: "proto.decode_json non-strict",
+			src:  `proto.decode_json(proto.package("skycfg.test_proto").MessageV3, "{\"f_strnig\": \"x\", \"f_int32\": 1010}", strict=False).f_int32`,
+			want: "1010",
+		},
 		{
 			// This is a bit of a weird test. Protobuf's have complex behavior around whether a field is present.
 			// Reference: https://github.com/protocolbuffers/protobuf/blob/main/docs/field_presence.md
@@ -565,6 +657,213 @@ func TestProtoJson(t *testing.T) {
 	})
 }
 
+func TestProtoJsonEmitDefaults(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "proto.encode_json emit_defaults",
+			src: `proto.encode_json(
+				proto.package("skycfg.test_proto").MessageV3.NestedMessage(),
+				emit_defaults=True,
+			)`,
+			want:     `"{\"f_string\":\"\"}"`,
+			wantType: "string",
+		},
+	})
+
+	for _, test := range []struct {
+		src       string
+		wantField string
+	}{
+		{
+			src:       `proto.encode_json(proto.package("skycfg.test_proto").MessageV3(f_string = "x"), emit_defaults=True)`,
+			wantField: "f_int32",
+		},
+		{
+			src:       `proto.encode_json(proto.package("skycfg.test_proto").MessageV3(f_string = "x"), emit_defaults=True, use_proto_names=False)`,
+			wantField: "fInt32",
+		},
+	} {
+		val, err := eval(test.src, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(val.(starlark.String)), &fields); err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if got, ok := fields[test.wantField]; !ok || got != 0.0 {
+			t.Errorf("%s: wanted %s = 0, got %v", test.src, test.wantField, fields)
+		}
+		submsgField := "f_submsg"
+		if test.wantField == "fInt32" {
+			submsgField = "fSubmsg"
+		}
+		if got, ok := fields[submsgField]; !ok || got != nil {
+			t.Errorf("%s: wanted %s = null, got %v", test.src, submsgField, fields)
+		}
+	}
+}
+
+func TestProtoJsonEnumNumbers(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "proto.encode_json enum names",
+			src: `proto.encode_json(proto.package("skycfg.test_proto").MessageV3(
+				f_toplevel_enum = proto.package("skycfg.test_proto").ToplevelEnumV3.TOPLEVEL_ENUM_V3_B,
+			))`,
+			want: `"{\"f_toplevel_enum\":\"TOPLEVEL_ENUM_V3_B\"}"`,
+		},
+		{
+			name: "proto.encode_json use_enum_numbers",
+			src: `proto.encode_json(proto.package("skycfg.test_proto").MessageV3(
+				f_nested_enum = proto.package("skycfg.test_proto").MessageV3.NestedEnum.NESTED_ENUM_B,
+			), use_enum_numbers=True)`,
+			want: `"{\"f_nested_enum\":1}"`,
+		},
+	})
+
+	src := `proto.encode_json(proto.package("skycfg.test_proto").MessageV3(
+		f_toplevel_enum = proto.package("skycfg.test_proto").ToplevelEnumV3.TOPLEVEL_ENUM_V3_B,
+	), use_enum_numbers=True, use_proto_names=False, emit_defaults=True)`
+	val, err := eval(src, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(val.(starlark.String)), &fields); err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	if got := fields["fToplevelEnum"]; got != 1.0 {
+		t.Errorf("%s: wanted fToplevelEnum = 1, got %v", src, fields)
+	}
+	if got, ok := fields["fNestedEnum"]; !ok || got != 0.0 {
+		t.Errorf("%s: wanted fNestedEnum = 0, got %v", src, fields)
+	}
+}
+
+func TestProtoJsonUnknownField(t *testing.T) {
+	for _, src := range []string{
+		`proto.decode_json(proto.package("skycfg.test_proto").MessageV3, "{\"f_strnig\": \"some string\"}")`,
+		`proto.decode_json(proto.package("skycfg.test_proto").MessageV3, "{\"f_strnig\": \"some string\"}", strict=True)`,
+	} {
+		_, err := eval(src, nil)
+		if err == nil {
+			t.Fatalf("%s: expected error", src)
+		}
+		// The protojson package randomly uses non-breaking spaces in its
+		// errors, to discourage depending on their exact text.
+		got := strings.ReplaceAll(err.Error(), "\u00a0", " ")
+		want := `proto.decode_json: skycfg.test_proto.MessageV3: proto: (line 1:2): unknown field "f_strnig"`
+		if got != want {
+			t.Fatalf("%s: Expected error\nwanted: %q\ngot   : %q", src, want, got)
+		}
+	}
+}
+
+func TestProtoTextParseError(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  `proto.decode_text(proto.package("skycfg.test_proto").MessageV3, "f_string: \"a\"\nf_strnig: \"b\"")`,
+			want: `proto.decode_text: skycfg.test_proto.MessageV3: proto: (line 2:1): unknown field: f_strnig`,
+		},
+		{
+			src:  `proto.decode_text(proto.package("skycfg.test_proto").MessageV3, "f_int32: 1\n  f_submsg { f_int32: x }")`,
+			want: `proto.decode_text: skycfg.test_proto.MessageV3: proto: (line 2:23): invalid value for int32 type: x`,
+		},
+	} {
+		_, err := eval(tc.src, nil)
+		if err == nil {
+			t.Fatalf("%s: expected error", tc.src)
+		}
+		// Like protojson, the prototext package randomly uses non-breaking
+		// spaces in its errors.
+		got := strings.ReplaceAll(err.Error(), "\u00a0", " ")
+		if got != tc.want {
+			t.Errorf("%s: Expected error\nwanted: %q\ngot   : %q", tc.src, tc.want, got)
+		}
+	}
+}
+
+func TestProtoYaml(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "proto.to_yaml",
+			src: `proto.to_yaml(proto.package("skycfg.test_proto").MessageV3(
+				f_string = "some string",
+				f_int64 = 1234567890123,
+				r_string = ["a", "b"],
+				f_submsg = proto.package("skycfg.test_proto").MessageV3(f_int32 = 1),
+			))`,
+			want:     `"f_int64: \"1234567890123\"\nf_string: some string\nf_submsg:\n  f_int32: 1\nr_string:\n  - a\n  - b\n"`,
+			wantType: "string",
+		},
+		{
+			name: "proto.to_yaml use_proto_names",
+			src: `proto.to_yaml(proto.package("skycfg.test_proto").MessageV3(
+				f_string = "some string",
+			), use_proto_names=False)`,
+			want:     `"fString: some string\n"`,
+			wantType: "string",
+		},
+		{
+			name: "proto.to_yaml empty",
+			src:  `proto.to_yaml(proto.package("skycfg.test_proto").MessageV3())`,
+			want: `"{}\n"`,
+		},
+		{
+			name:    "proto.to_yaml non-message",
+			src:     `proto.to_yaml({"f_string": "some string"})`,
+			wantErr: errors.New("proto.to_yaml: for parameter 1: got dict, want proto.Message"),
+		},
+		{
+			name: "proto.from_yaml",
+			src: `proto.from_yaml(proto.package("skycfg.test_proto").MessageV3, """
+f_string: some string
+f_int64: 1234567890123
+f_submsg:
+  f_int32: 1
+r_string: [a, b]
+""")`,
+			want:              `<skycfg.test_proto.MessageV3 f_int64:1234567890123 f_string:"some string" f_submsg:{f_int32:1} r_string:"a" r_string:"b">`,
+			removeRandomSpace: true,
+		},
+		{
+			name:              "proto.from_yaml type name",
+			src:               `proto.from_yaml("skycfg.test_proto.MessageV3", "fString: some string")`,
+			want:              `<skycfg.test_proto.MessageV3 f_string:"some string">`,
+			removeRandomSpace: true,
+		},
+		{
+			name: "proto.from_yaml round trip",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV3(f_string = "a", f_int64 = 1, map_string = {"k": "v"}, f_toplevel_enum = pkg.ToplevelEnumV3.TOPLEVEL_ENUM_V3_B)
+    return proto.from_yaml(pkg.MessageV3, proto.to_yaml(msg)) == msg
+`,
+			want: `True`,
+		},
+		{
+			name:    "proto.from_yaml unknown type",
+			src:     `proto.from_yaml("skycfg.test_proto.NoSuchMessage", "{}")`,
+			wantErr: errors.New(`proto.from_yaml: message type "skycfg.test_proto.NoSuchMessage" not found`),
+		},
+	})
+
+	_, err := eval(`proto.from_yaml(proto.package("skycfg.test_proto").MessageV3, "no_such_field: 1")`, nil)
+	if err == nil {
+		t.Fatal("proto.from_yaml unknown field: expected error")
+	}
+	got := strings.ReplaceAll(err.Error(), "\u00a0", " ")
+	want := `proto.from_yaml: skycfg.test_proto.MessageV3: proto: (line 1:2): unknown field "no_such_field"`
+	if got != want {
+		t.Fatalf("proto.from_yaml unknown field: Expected error\nwanted: %q\ngot   : %q", want, got)
+	}
+}
+
 func TestProtoToAnyV2(t *testing.T) {
 	val, err := eval(`proto.encode_any(proto.package("skycfg.test_proto").MessageV2(
 		f_string = "some string",
@@ -618,6 +917,404 @@ func TestProtoToAnyV3(t *testing.T) {
 	}
 }
 
+func TestProtoDiff(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "equal messages",
+			src: `proto.diff(
+				proto.package("skycfg.test_proto").MessageV3(f_string = "a", r_string = ["x"]),
+				proto.package("skycfg.test_proto").MessageV3(f_string = "a", r_string = ["x"]),
+			)`,
+			want: `""`,
+		},
+		{
+			name: "field differences",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    a = pkg.MessageV3(
+        f_int32 = 1,
+        f_string = "a",
+        f_submsg = pkg.MessageV3(f_int32 = 1, f_string = "a"),
+        r_string = ["x", "y"],
+        r_submsg = [pkg.MessageV3(f_int32 = 1)],
+        map_string = {"k1": "v1", "k2": "v2"},
+    )
+    b = pkg.MessageV3(
+        f_int64 = 2,
+        f_string = "b",
+        f_submsg = pkg.MessageV3(f_int32 = 2, f_string = "a"),
+        r_string = ["x", "z", "w"],
+        r_submsg = [pkg.MessageV3(f_int32 = 2)],
+        map_string = {"k1": "v1", "k3": "v3"},
+        map_submsg = {"k": pkg.MessageV3(f_string = "s", r_string = ["r"])},
+        f_toplevel_enum = pkg.ToplevelEnumV3.TOPLEVEL_ENUM_V3_B,
+    )
+    return proto.diff(a, b)
+`,
+			want: `"removed f_int32: 1\n` +
+				`added f_int64: 2\n` +
+				`changed f_string: \"a\" -> \"b\"\n` +
+				`changed f_submsg.f_int32: 1 -> 2\n` +
+				`changed r_string[1]: \"y\" -> \"z\"\n` +
+				`added r_string[2]: \"w\"\n` +
+				`changed r_submsg[0].f_int32: 1 -> 2\n` +
+				`removed map_string[\"k2\"]: \"v2\"\n` +
+				`added map_string[\"k3\"]: \"v3\"\n` +
+				`added map_submsg[\"k\"]: {f_string: \"s\", r_string: [\"r\"]}\n` +
+				`added f_toplevel_enum: TOPLEVEL_ENUM_V3_B"`,
+		},
+		{
+			name: "different types",
+			src: `proto.diff(
+				proto.package("skycfg.test_proto").MessageV2(),
+				proto.package("skycfg.test_proto").MessageV3(),
+			)`,
+			wantErr: errors.New("proto.diff: types are not the same: got skycfg.test_proto.MessageV2 and skycfg.test_proto.MessageV3"),
+		},
+		{
+			name:    "non-message",
+			src:     `proto.diff(proto.package("skycfg.test_proto").MessageV3(), {})`,
+			wantErr: errors.New("proto.diff: for parameter 2: got dict, want proto.Message"),
+		},
+	})
+}
+
+func TestProtoHas(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "unset fields",
+			src:  `[proto.has(proto.package("skycfg.test_proto").MessageV2(), f) for f in ["f_int32", "f_submsg", "r_string", "map_string", "f_submsg.f_int32"]]`,
+			want: `[False, False, False, False, False]`,
+		},
+		{
+			name: "proto2 fields set to zero values",
+			src: `[proto.has(proto.package("skycfg.test_proto").MessageV2(
+				f_int32 = 0,
+				f_string = "",
+				f_submsg = proto.package("skycfg.test_proto").MessageV2(),
+			), f) for f in ["f_int32", "f_string", "f_submsg", "f_submsg.f_int32"]]`,
+			want: `[True, True, True, False]`,
+		},
+		{
+			name: "proto3 fields",
+			src: `[proto.has(proto.package("skycfg.test_proto").MessageV3(
+				f_int32 = 0,
+				f_string = "a",
+				r_string = ["a"],
+				map_string = {},
+				f_submsg = proto.package("skycfg.test_proto").MessageV3(f_int32 = 1),
+			), f) for f in ["f_int32", "f_string", "r_string", "map_string", "f_submsg.f_int32"]]`,
+			want: `[False, True, True, False, True]`,
+		},
+		{
+			name:    "unknown field",
+			src:     `proto.has(proto.package("skycfg.test_proto").MessageV3(), "no_exist")`,
+			wantErr: errors.New("proto.has: AttributeError: `skycfg.test_proto.MessageV3' value has no field \"no_exist\""),
+		},
+		{
+			name:    "unknown nested field",
+			src:     `proto.has(proto.package("skycfg.test_proto").MessageV3(), "f_submsg.no_exist")`,
+			wantErr: errors.New("proto.has: AttributeError: `skycfg.test_proto.MessageV3' value has no field \"no_exist\""),
+		},
+		{
+			name:    "path through scalar",
+			src:     `proto.has(proto.package("skycfg.test_proto").MessageV3(f_string = "a"), "f_string.x")`,
+			wantErr: errors.New("proto.has: field \"f_string\" of skycfg.test_proto.MessageV3 is not a message"),
+		},
+	})
+}
+
+func TestProtoClone(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "mutating the clone",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV3(
+        f_string = "a",
+        f_submsg = pkg.MessageV3(f_int32 = 1),
+        r_string = ["a"],
+        r_submsg = [pkg.MessageV3(f_int32 = 1)],
+        map_string = {"a": "a"},
+        map_submsg = {"a": pkg.MessageV3(f_int32 = 1)},
+    )
+    clone = proto.clone(msg)
+    clone.f_string = "b"
+    clone.f_submsg.f_int32 = 2
+    clone.r_string.append("b")
+    clone.r_submsg[0].f_int32 = 2
+    clone.r_submsg.append(pkg.MessageV3())
+    clone.map_string["b"] = "b"
+    clone.map_submsg["a"].f_int32 = 2
+    clone.map_submsg["b"] = pkg.MessageV3()
+    return [msg.f_string, msg.f_submsg.f_int32, list(msg.r_string), len(msg.r_submsg), msg.r_submsg[0].f_int32, dict(msg.map_string), len(msg.map_submsg), msg.map_submsg["a"].f_int32]
+`,
+			want: `["a", 1, ["a"], 1, 1, {"a": "a"}, 1, 1]`,
+		},
+		{
+			name: "mutating the original",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV3(
+        f_submsg = pkg.MessageV3(f_int32 = 1),
+        r_submsg = [pkg.MessageV3(f_int32 = 1)],
+        map_submsg = {"a": pkg.MessageV3(f_int32 = 1)},
+    )
+    clone = proto.clone(msg)
+    msg.f_submsg.f_int32 = 2
+    msg.r_submsg[0].f_int32 = 2
+    msg.map_submsg["a"].f_int32 = 2
+    return [clone.f_submsg.f_int32, clone.r_submsg[0].f_int32, clone.map_submsg["a"].f_int32]
+`,
+			want: `[1, 1, 1]`,
+		},
+		{
+			name:    "not a message",
+			src:     `proto.clone({"f_string": "a"})`,
+			wantErr: errors.New("proto.clone: for parameter 1: got dict, want proto.Message"),
+		},
+	})
+}
+
+func TestProtoFields(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "unset fields",
+			src:  `proto.fields(proto.package("skycfg.test_proto").MessageV3())`,
+			want: `[]`,
+		},
+		{
+			name: "populated fields in field number order",
+			src: `proto.fields(proto.package("skycfg.test_proto").MessageV3(
+				f_string = "a",
+				f_int32 = 0,
+				r_string = ["b"],
+				f_submsg = proto.package("skycfg.test_proto").MessageV3(f_int32 = 1),
+				f_int64 = 2,
+			))`,
+			want:              `[("f_int64", 2), ("f_string", "a"), ("f_submsg", <skycfg.test_proto.MessageV3 f_int32:1>), ("r_string", ["b"])]`,
+			removeRandomSpace: true,
+		},
+		{
+			name: "nested messages are message values",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV3(f_submsg = pkg.MessageV3())
+    msg.f_submsg.f_int32 = 1
+    name, submsg = proto.fields(msg)[0]
+    submsg.f_string = "a"
+    return msg.f_submsg
+`,
+			want:              `<skycfg.test_proto.MessageV3 f_int32:1 f_string:"a">`,
+			removeRandomSpace: true,
+		},
+		{
+			name:    "not a message",
+			src:     `proto.fields(1)`,
+			wantErr: errors.New("proto.fields: for parameter 1: got int, want proto.Message"),
+		},
+	})
+}
+
+func TestProtoClearField(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "clears fields",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV2(
+        f_int32 = 0,
+        f_string = "a",
+        r_string = ["a"],
+        f_submsg = pkg.MessageV2(f_int32 = 1, f_string = "b"),
+        r_submsg = [pkg.MessageV2(f_string = "c"), pkg.MessageV2(f_int32 = 2, f_string = "d")],
+    )
+    for path in ["f_int32", "r_string", "f_submsg.f_string", "r_submsg[1].f_string", "f_submsg.f_submsg.f_string"]:
+        proto.clear_field(msg, path)
+    return msg
+`,
+			want: &pb.MessageV2{
+				FString: proto.String("a"),
+				FSubmsg: &pb.MessageV2{
+					FInt32: proto.Int32(1),
+				},
+				RSubmsg: []*pb.MessageV2{
+					{FString: proto.String("c")},
+					{FInt32: proto.Int32(2)},
+				},
+			},
+		},
+		{
+			name:    "unknown field",
+			src:     `proto.clear_field(proto.package("skycfg.test_proto").MessageV2(), "f_submsg.no_exist")`,
+			wantErr: errors.New("proto.clear_field: AttributeError: `skycfg.test_proto.MessageV2' value has no field \"no_exist\""),
+		},
+		{
+			name:    "index out of range",
+			src:     `proto.clear_field(proto.package("skycfg.test_proto").MessageV2(), "r_submsg[0].f_string")`,
+			wantErr: errors.New("proto.clear_field: index 0 out of range for field \"r_submsg\" of skycfg.test_proto.MessageV2"),
+		},
+		{
+			name:    "path through scalar",
+			src:     `proto.clear_field(proto.package("skycfg.test_proto").MessageV2(), "f_string.x")`,
+			wantErr: errors.New("proto.clear_field: invalid field path \"f_string.x\": \"f_string\" is not a message field of skycfg.test_proto.MessageV2"),
+		},
+		{
+			name:    "path ending with index",
+			src:     `proto.clear_field(proto.package("skycfg.test_proto").MessageV2(r_string = ["a"]), "r_string[0]")`,
+			wantErr: errors.New("proto.clear_field: invalid field path \"r_string[0]\": must end with a field name"),
+		},
+	})
+}
+
+func TestProtoOneof(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "which_oneof",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV3()
+    result = [proto.which_oneof(msg, "f_oneof")]
+    msg.f_oneof_a = ""
+    result.append(proto.which_oneof(msg, "f_oneof"))
+    msg.f_oneof_b = "b"
+    result.append(proto.which_oneof(msg, "f_oneof"))
+    result.append(proto.which_oneof(pkg.MessageV2(f_oneof_a = "a"), "f_oneof"))
+    return result
+`,
+			want: `[None, "f_oneof_a", "f_oneof_b", "f_oneof_a"]`,
+		},
+		{
+			name: "clear_oneof",
+			srcFunc: `
+def fun():
+    pkg = proto.package("skycfg.test_proto")
+    msg = pkg.MessageV3(f_string = "x", f_oneof_b = "b")
+    proto.clear_oneof(msg, "f_oneof")
+    proto.clear_oneof(msg, "f_oneof")
+    return msg
+`,
+			want: &pb.MessageV3{
+				FString: "x",
+			},
+		},
+		{
+			name:    "which_oneof unknown oneof",
+			src:     `proto.which_oneof(proto.package("skycfg.test_proto").MessageV3(), "f_string")`,
+			wantErr: errors.New("proto.which_oneof: AttributeError: `skycfg.test_proto.MessageV3' value has no oneof \"f_string\""),
+		},
+		{
+			name:    "clear_oneof unknown oneof",
+			src:     `proto.clear_oneof(proto.package("skycfg.test_proto").MessageV3(), "no_exist")`,
+			wantErr: errors.New("proto.clear_oneof: AttributeError: `skycfg.test_proto.MessageV3' value has no oneof \"no_exist\""),
+		},
+		{
+			name:    "which_oneof non-message",
+			src:     `proto.which_oneof(1, "f_oneof")`,
+			wantErr: errors.New("proto.which_oneof: for parameter 1: got int, want proto.Message"),
+		},
+	})
+}
+
+func TestProtoSize(t *testing.T) {
+	msg := &pb.MessageV3{
+		FString: "some string",
+		FInt64:  123456,
+		RString: []string{"a", "bc"},
+		FSubmsg: &pb.MessageV3{FString: "nested"},
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `proto.package("skycfg.test_proto").MessageV3(
+		f_string = "some string",
+		f_int64 = 123456,
+		r_string = ["a", "bc"],
+		f_submsg = proto.package("skycfg.test_proto").MessageV3(f_string = "nested"),
+	)`
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "message",
+			src:  `proto.size(` + src + `)`,
+			want: fmt.Sprintf("%d", len(data)),
+		},
+		{
+			name: "matches encoded length",
+			srcFunc: `
+def fun():
+    msg = ` + src + `
+    return proto.size(msg) == len(proto.encode_any(msg).value)
+`,
+			want: `True`,
+		},
+		{
+			name: "list",
+			src:  `proto.size([` + src + `, ` + src + `, proto.package("skycfg.test_proto").MessageV2()])`,
+			want: fmt.Sprintf("%d", 2*len(data)),
+		},
+		{
+			name: "empty",
+			src:  `[proto.size(proto.package("skycfg.test_proto").MessageV3()), proto.size([]), proto.size(())]`,
+			want: `[0, 0, 0]`,
+		},
+		{
+			name:    "non-message",
+			src:     `proto.size("abc")`,
+			wantErr: errors.New("proto.size: for parameter 1: got string, want proto.Message or list"),
+		},
+		{
+			name:    "list with non-message",
+			src:     `proto.size([proto.package("skycfg.test_proto").MessageV3(), 1])`,
+			wantErr: errors.New("proto.size: for parameter 1: element 1: got int, want proto.Message"),
+		},
+	})
+}
+
+func TestProtoAny(t *testing.T) {
+	runSkycfgTests(t, []skycfgTest{
+		{
+			name: "proto.unpack_any",
+			src: `proto.unpack_any(proto.pack_any(proto.package("skycfg.test_proto").MessageV3(
+				f_string = "some string",
+			)))`,
+			want: &pb.MessageV3{
+				FString: "some string",
+			},
+		},
+		{
+			name: "proto.decode_any",
+			src: `proto.decode_any(proto.encode_any(proto.package("skycfg.test_proto").MessageV2(
+				f_string = "some string",
+			)))`,
+			want: &pb.MessageV2{
+				FString: proto.String("some string"),
+			},
+		},
+		{
+			name: "proto.unpack_any unknown type URL",
+			srcFunc: `
+def fun():
+    any = proto.pack_any(proto.package("skycfg.test_proto").MessageV3())
+    any.type_url = "type.googleapis.com/skycfg.test_proto.NoExist"
+    return proto.unpack_any(any)
+`,
+			wantErr: errors.New(`proto.decode_any: no message type registered for type URL "type.googleapis.com/skycfg.test_proto.NoExist"`),
+		},
+		{
+			name:    "proto.unpack_any non-Any",
+			src:     `proto.unpack_any(proto.package("skycfg.test_proto").MessageV3())`,
+			wantErr: errors.New(`proto.decode_any: for parameter 1: got skycfg.test_proto.MessageV3, want google.protobuf.Any`),
+		},
+	})
+}
+
 type skycfgTest struct {
 	name     string
 	src      string
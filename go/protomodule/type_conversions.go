@@ -21,10 +21,14 @@ package protomodule
 import (
 	"fmt"
 	"math"
+	"time"
 
+	starlarktime "go.starlark.net/lib/time"
 	"go.starlark.net/starlark"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -244,11 +248,14 @@ func maybeConvertToWrapper(fieldDesc protoreflect.FieldDescriptor, val starlark.
 	BoolValueType := (&wrapperspb.BoolValue{}).ProtoReflect().Descriptor().FullName()
 	StringValueType := (&wrapperspb.StringValue{}).ProtoReflect().Descriptor().FullName()
 	DoubleValueType := (&wrapperspb.DoubleValue{}).ProtoReflect().Descriptor().FullName()
+	FloatValueType := (&wrapperspb.FloatValue{}).ProtoReflect().Descriptor().FullName()
 	Int32ValueType := (&wrapperspb.Int32Value{}).ProtoReflect().Descriptor().FullName()
 	Int64ValueType := (&wrapperspb.Int64Value{}).ProtoReflect().Descriptor().FullName()
 	BytesValueType := (&wrapperspb.BytesValue{}).ProtoReflect().Descriptor().FullName()
 	UInt32ValueType := (&wrapperspb.UInt32Value{}).ProtoReflect().Descriptor().FullName()
 	UInt64ValueType := (&wrapperspb.UInt64Value{}).ProtoReflect().Descriptor().FullName()
+	DurationType := (&durationpb.Duration{}).ProtoReflect().Descriptor().FullName()
+	TimestampType := (&timestamppb.Timestamp{}).ProtoReflect().Descriptor().FullName()
 
 	messageType := fieldDesc.Message().FullName()
 
@@ -288,6 +295,10 @@ func maybeConvertToWrapper(fieldDesc protoreflect.FieldDescriptor, val starlark.
 		if float64Val, ok := starlark.AsFloat(val); ok {
 			return NewMessage(&wrapperspb.DoubleValue{Value: float64Val})
 		}
+	case FloatValueType:
+		if float64Val, ok := starlark.AsFloat(val); ok {
+			return NewMessage(&wrapperspb.FloatValue{Value: float32(float64Val)})
+		}
 	case Int32ValueType:
 		switch val := val.(type) {
 		case starlark.Int:
@@ -306,10 +317,126 @@ func maybeConvertToWrapper(fieldDesc protoreflect.FieldDescriptor, val starlark.
 			}
 			return nil, fmt.Errorf("ValueError: value %v is not exactly representable as type `int64'.", val)
 		}
+	case DurationType:
+		duration, ok, err := durationFromStarlark(val)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return NewMessage(durationpb.New(duration))
+		}
+	case TimestampType:
+		switch val := val.(type) {
+		case starlarktime.Time:
+			return NewMessage(timestamppb.New(time.Time(val)))
+		}
 	}
 	return nil, nil
 }
 
+// durationFromStarlark converts a time.duration, a string accepted by
+// time.ParseDuration such as "1m30s", or a number of seconds to a duration.
+// Returns (_, false, nil) for values of any other type.
+func durationFromStarlark(val starlark.Value) (time.Duration, bool, error) {
+	switch val := val.(type) {
+	case starlarktime.Duration:
+		return time.Duration(val), true, nil
+	case starlark.String:
+		duration, err := time.ParseDuration(string(val))
+		if err != nil {
+			return 0, false, fmt.Errorf("ValueError: value %s is not a valid duration: %v", val, err)
+		}
+		return duration, true, nil
+	case starlark.Int, starlark.Float:
+		seconds, _ := starlark.AsFloat(val)
+		nanos := seconds * float64(time.Second)
+		if math.IsNaN(nanos) || nanos > math.MaxInt64 || nanos < math.MinInt64 {
+			return 0, false, fmt.Errorf("ValueError: value %v overflows type `google.protobuf.Duration'.", val)
+		}
+		return time.Duration(nanos), true, nil
+	}
+	return 0, false, nil
+}
+
+// checkStrictType returns an error if val is a number that is converted
+// implicitly to the type of fieldDesc unless field types are checked
+// strictly: an int assigned to a float or double field or to the FloatValue
+// and DoubleValue wrappers, or a number of seconds assigned to a Duration.
+// Other conversions, such as of a value to the wrapper of its own type or
+// of a string to a Duration, are allowed either way.
+func checkStrictType(fieldDesc protoreflect.FieldDescriptor, val starlark.Value) error {
+	var isInt bool
+	switch val.(type) {
+	case starlark.Int:
+		isInt = true
+	case starlark.Float:
+	default:
+		return nil
+	}
+	switch fieldDesc.Kind() {
+	case protoreflect.DoubleKind, protoreflect.FloatKind:
+		if isInt {
+			return typeError(fieldDesc, val, true)
+		}
+	case protoreflect.MessageKind:
+		switch fieldDesc.Message().FullName() {
+		case "google.protobuf.DoubleValue", "google.protobuf.FloatValue":
+			if isInt {
+				return typeError(fieldDesc, val, true)
+			}
+		case "google.protobuf.Duration":
+			return typeError(fieldDesc, val, true)
+		}
+	}
+	return nil
+}
+
+// checkStrictValue is checkStrictType for a value assigned to a field,
+// checking each element of a list or value of a map.
+func checkStrictValue(fieldDesc protoreflect.FieldDescriptor, val starlark.Value) error {
+	if fieldDesc.IsList() {
+		switch val := val.(type) {
+		case *starlark.List, *protoRepeated:
+			iter := val.(starlark.Iterable).Iterate()
+			defer iter.Done()
+			var elem starlark.Value
+			for iter.Next(&elem) {
+				if err := checkStrictType(fieldDesc, elem); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if fieldDesc.IsMap() {
+		var items []starlark.Tuple
+		switch val := val.(type) {
+		case *starlark.Dict:
+			items = val.Items()
+		case *protoMap:
+			items = val.Items()
+		}
+		for _, item := range items {
+			if err := checkStrictType(fieldDesc.MapValue(), item[1]); err != nil {
+				return mapFieldError(fieldDesc, err)
+			}
+		}
+		return nil
+	}
+	return checkStrictType(fieldDesc, val)
+}
+
+// makeStrict marks the default list or map of a field of a strict message
+// as strict, so values added to it are checked too.
+func makeStrict(val starlark.Value) {
+	switch val := val.(type) {
+	case *protoRepeated:
+		val.strict = true
+	case *protoMap:
+		val.strict = true
+	}
+}
+
 // Verify v can act as fieldDesc
 func scalarTypeCheck(fieldDesc protoreflect.FieldDescriptor, v starlark.Value) error {
 	_, err := scalarValueFromStarlark(fieldDesc, v)
@@ -0,0 +1,122 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package regexmodule defines a Starlark module of regular expression
+// functions.
+package regexmodule
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Starlark thread-local storage key for compiled patterns.
+const cacheKey = "regexmodule.cache" // has type map[string]*regexp.Regexp
+
+// NewModule returns a Starlark module of regular expression functions.
+//
+//  re = module(
+//    findall,
+//    match,
+//    sub,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "re",
+		Members: starlark.StringDict{
+			"findall": starlark.NewBuiltin("re.findall", reFindall),
+			"match":   starlark.NewBuiltin("re.match", reMatch),
+			"sub":     starlark.NewBuiltin("re.sub", reSub),
+		},
+	}
+}
+
+// compile returns the compiled pattern, reusing patterns already compiled by
+// the same thread.
+func compile(t *starlark.Thread, fn *starlark.Builtin, pattern string) (*regexp.Regexp, error) {
+	cache, ok := t.Local(cacheKey).(map[string]*regexp.Regexp)
+	if !ok {
+		cache = make(map[string]*regexp.Regexp)
+		t.SetLocal(cacheKey, cache)
+	}
+	if re, ok := cache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	cache[pattern] = re
+	return re, nil
+}
+
+func reMatch(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "s", &s); err != nil {
+		return nil, err
+	}
+	re, err := compile(t, fn, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(re.MatchString(s)), nil
+}
+
+func reFindall(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "s", &s); err != nil {
+		return nil, err
+	}
+	re, err := compile(t, fn, pattern)
+	if err != nil {
+		return nil, err
+	}
+	var matches []starlark.Value
+	for _, submatches := range re.FindAllStringSubmatch(s, -1) {
+		// As in Python, a pattern with groups returns the groups rather than
+		// the whole match.
+		switch groups := submatches[1:]; len(groups) {
+		case 0:
+			matches = append(matches, starlark.String(submatches[0]))
+		case 1:
+			matches = append(matches, starlark.String(groups[0]))
+		default:
+			tuple := make(starlark.Tuple, len(groups))
+			for i, group := range groups {
+				tuple[i] = starlark.String(group)
+			}
+			matches = append(matches, tuple)
+		}
+	}
+	return starlark.NewList(matches), nil
+}
+
+func reSub(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, repl, s string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern", &pattern, "repl", &repl, "s", &s); err != nil {
+		return nil, err
+	}
+	re, err := compile(t, fn, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(re.ReplaceAllString(s, repl)), nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package regexmodule
+
+import (
+	"regexp"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestRegex(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"re": NewModule(),
+	}
+
+	testCases := []struct {
+		expr string
+		want string
+	}{
+		{`re.match("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$", "my-service")`, `True`},
+		{`re.match("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$", "My_Service")`, `False`},
+		{`re.match("[0-9]+", "v1.2")`, `True`},
+		{`re.findall("[0-9]+", "v1.20.3")`, `["1", "20", "3"]`},
+		{`re.findall("v([0-9]+)", "v1 v2")`, `["1", "2"]`},
+		{`re.findall("([a-z]+)=([0-9]*)", "a=1,b=")`, `[("a", "1"), ("b", "")]`},
+		{`re.findall("x", "abc")`, `[]`},
+		{`re.sub("[^a-z0-9-]", "-", "My_Service.v2")`, `"-y--ervice-v2"`},
+		{`re.sub("([a-z]+)@([a-z.]+)", "${2}/$1", "admin@example.com")`, `"example.com/admin"`},
+	}
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.expr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.expr, err)
+			continue
+		}
+		if got := v.String(); got != testCase.want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", testCase.expr, testCase.want, got)
+		}
+	}
+
+	_, err := starlark.Eval(thread, "<expr>", `re.match("(a", "a")`, env)
+	wantErr := "re.match: error parsing regexp: missing closing ): `(a`"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Bad error from re.match\nExpected: %s\nGot     : %v", wantErr, err)
+	}
+
+	// Patterns are compiled once per thread.
+	cache := thread.Local(cacheKey).(map[string]*regexp.Regexp)
+	if _, ok := cache["[0-9]+"]; !ok {
+		t.Errorf("Expected cache to contain pattern %q, got %v", "[0-9]+", cache)
+	}
+	if _, ok := cache["(a"]; ok {
+		t.Errorf("Expected cache to not contain invalid pattern %q", "(a")
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tomlmodule defines a Starlark module of TOML-related functions.
+package tomlmodule
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/stripe/skycfg/go/yamlmodule"
+)
+
+// NewModule returns a Starlark module of TOML-related functions.
+//
+//  toml = module(
+//    decode,
+//    encode,
+//  )
+//
+// See `docs/modules.asciidoc` for details on the API of each function.
+func NewModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "toml",
+		Members: starlark.StringDict{
+			"decode": starlark.NewBuiltin("toml.decode", tomlDecode),
+			"encode": starlark.NewBuiltin("toml.encode", tomlEncode),
+		},
+	}
+}
+
+func tomlDecode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var blob string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "blob", &blob); err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	md, err := toml.Decode(blob, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		if _, ok := order[key.String()]; !ok {
+			order[key.String()] = i
+		}
+	}
+	return yamlmodule.ToStarlark(inflate(doc, nil, order))
+}
+
+// inflate converts a decoded TOML document into the Go types understood by
+// yamlmodule.ToStarlark. Tables become a yamlmodule.MapSlice, with keys in
+// the order they were first written in the document.
+func inflate(obj interface{}, key toml.Key, order map[string]int) interface{} {
+	switch obj := obj.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		position := func(name string) int {
+			if i, ok := order[childKey(key, name).String()]; ok {
+				return i
+			}
+			return math.MaxInt32
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if pi, pj := position(names[i]), position(names[j]); pi != pj {
+				return pi < pj
+			}
+			return names[i] < names[j]
+		})
+		items := make(yamlmodule.MapSlice, len(names))
+		for i, name := range names {
+			items[i] = yamlmodule.MapItem{Key: name, Value: inflate(obj[name], childKey(key, name), order)}
+		}
+		return items
+	case []map[string]interface{}:
+		slice := make([]interface{}, len(obj))
+		for i, table := range obj {
+			slice[i] = inflate(table, key, order)
+		}
+		return slice
+	case []interface{}:
+		slice := make([]interface{}, len(obj))
+		for i, element := range obj {
+			slice[i] = inflate(element, key, order)
+		}
+		return slice
+	}
+	return obj
+}
+
+func childKey(key toml.Key, name string) toml.Key {
+	child := make(toml.Key, len(key), len(key)+1)
+	copy(child, key)
+	return append(child, name)
+}
+
+func tomlEncode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "value", &v); err != nil {
+		return nil, err
+	}
+	inflated, err := yamlmodule.FromStarlark(v, "TOML")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := inflated.(yamlmodule.MapSlice); !ok {
+		return nil, fmt.Errorf("%s: for parameter value: got %s, want dict or struct", fn.Name(), v.Type())
+	}
+	obj, err := toEncodable(inflated)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(obj); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.String(buf.String()), nil
+}
+
+// toEncodable converts a value returned by yamlmodule.FromStarlark into
+// values that the toml package can encode. Mappings become tables, and lists
+// containing only mappings become arrays of tables.
+func toEncodable(obj interface{}) (interface{}, error) {
+	switch obj := obj.(type) {
+	case nil:
+		return nil, fmt.Errorf("None is not supported in TOML, except as the value of a table key")
+	case uint64:
+		if obj > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d is out of range for TOML", obj)
+		}
+		return int64(obj), nil
+	case *big.Int:
+		return nil, fmt.Errorf("integer %s is out of range for TOML", obj)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(obj), nil
+	case []interface{}:
+		if len(obj) > 0 && allMappings(obj) {
+			tables := make([]map[string]interface{}, len(obj))
+			for i, element := range obj {
+				table, err := toEncodable(element)
+				if err != nil {
+					return nil, err
+				}
+				tables[i] = table.(map[string]interface{})
+			}
+			return tables, nil
+		}
+		slice := make([]interface{}, len(obj))
+		for i, element := range obj {
+			v, err := toEncodable(element)
+			if err != nil {
+				return nil, err
+			}
+			slice[i] = v
+		}
+		return slice, nil
+	case yamlmodule.MapSlice:
+		table := make(map[string]interface{}, len(obj))
+		for _, item := range obj {
+			key, ok := item.Key.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s (%v) is not a supported key type", reflect.TypeOf(item.Key).Kind(), item.Key)
+			}
+			// TOML has no null value, so keys set to None are omitted.
+			if item.Value == nil {
+				continue
+			}
+			v, err := toEncodable(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			table[key] = v
+		}
+		return table, nil
+	}
+	return obj, nil
+}
+
+func allMappings(slice []interface{}) bool {
+	for _, element := range slice {
+		if _, ok := element.(yamlmodule.MapSlice); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tomlmodule
+
+import (
+	"strings"
+	"testing"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+type TomlTestCase struct {
+	skyExpr   string
+	expOutput string
+}
+
+func TestSkyToToml(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"toml":   NewModule(),
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"time":   starlarktime.Module,
+	}
+
+	testCases := []TomlTestCase{
+		{
+			skyExpr:   `toml.encode({"title": "example", "ports": [80, 443], "ratio": 0.5, "enabled": True})`,
+			expOutput: "enabled = true\nports = [80, 443]\nratio = 0.5\ntitle = \"example\"\n",
+		},
+		{
+			skyExpr: `toml.encode({"server": struct(host = "localhost", tls = {"cert": "a.pem"})})`,
+			expOutput: strings.Join([]string{
+				"[server]",
+				"  host = \"localhost\"",
+				"  [server.tls]",
+				"    cert = \"a.pem\"",
+				"",
+			}, "\n"),
+		},
+		{
+			skyExpr: `toml.encode({"items": [{"name": "a"}, {"name": "b"}]})`,
+			expOutput: strings.Join([]string{
+				"[[items]]",
+				"  name = \"a\"",
+				"",
+				"[[items]]",
+				"  name = \"b\"",
+				"",
+			}, "\n"),
+		},
+		{
+			skyExpr:   `toml.encode({"created": time.from_timestamp(296638320), "unset": None})`,
+			expOutput: "created = 1979-05-27T07:32:00Z\n",
+		},
+		{
+			skyExpr:   `toml.encode({"data": b"hello", "matrix": [[1, 2], []]})`,
+			expOutput: "data = \"aGVsbG8=\"\nmatrix = [[1, 2], []]\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		if got := string(v.(starlark.String)); got != testCase.expOutput {
+			t.Error(
+				"Bad return value from toml.encode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", got)
+		}
+	}
+
+	errorCases := []TomlTestCase{
+		{
+			skyExpr:   `toml.encode([1, 2])`,
+			expOutput: "toml.encode: for parameter value: got list, want dict or struct",
+		},
+		{
+			skyExpr:   `toml.encode({"a": [None]})`,
+			expOutput: "toml.encode: None is not supported in TOML, except as the value of a table key",
+		},
+		{
+			skyExpr:   `toml.encode({"a": 18446744073709551615})`,
+			expOutput: "toml.encode: integer 18446744073709551615 is out of range for TOML",
+		},
+		{
+			skyExpr:   `toml.encode({1: "a"})`,
+			expOutput: "toml.encode: int64 (1) is not a supported key type",
+		},
+		{
+			skyExpr:   `toml.encode({"a": toml})`,
+			expOutput: "TypeError: value <module \"toml\"> (type `module') can't be converted to TOML.",
+		},
+	}
+
+	for _, testCase := range errorCases {
+		_, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err == nil || err.Error() != testCase.expOutput {
+			t.Error(
+				"Bad error from toml.encode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", err)
+		}
+	}
+}
+
+func TestTomlToSky(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"toml": NewModule(),
+	}
+
+	testCases := []TomlTestCase{
+		{
+			skyExpr:   `toml.decode('title = "example"\nports = [80, 443]\nratio = 0.5\n')`,
+			expOutput: `{"title": "example", "ports": [80, 443], "ratio": 0.5}`,
+		},
+		{
+			skyExpr:   `toml.decode('[server]\nport = 80\nhost = "localhost"\n[server.tls]\ncert = "a.pem"\n')`,
+			expOutput: `{"server": {"port": 80, "host": "localhost", "tls": {"cert": "a.pem"}}}`,
+		},
+		{
+			skyExpr:   `toml.decode('[[items]]\nname = "a"\n[[items]]\nname = "b"\nsize = 2\n')`,
+			expOutput: `{"items": [{"name": "a"}, {"name": "b", "size": 2}]}`,
+		},
+		{
+			skyExpr:   `toml.decode('created = 1979-05-27T07:32:00Z\n')["created"].unix`,
+			expOutput: `296638320`,
+		},
+		{
+			skyExpr:   `toml.encode(toml.decode('day = 1979-05-27\nat = 07:32:00\n'))`,
+			expOutput: `"at = 07:32:00\nday = 1979-05-27\n"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(thread, "<expr>", testCase.skyExpr, env)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.skyExpr, err)
+			continue
+		}
+		if v.String() != testCase.expOutput {
+			t.Error(
+				"Bad return value from toml.decode", testCase.skyExpr,
+				"\nExpected:", testCase.expOutput,
+				"\nGot:", v)
+		}
+	}
+
+	_, err := starlark.Eval(thread, "<expr>", `toml.decode('a = ')`, env)
+	wantErr := `toml.decode: toml: line 0 (last key "a"): unexpected EOF; expected value`
+	if err == nil || err.Error() != wantErr {
+		t.Error("Bad error from toml.decode", "\nExpected:", wantErr, "\nGot:", err)
+	}
+}
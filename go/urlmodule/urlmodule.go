@@ -20,6 +20,7 @@ package urlmodule
 import (
 	"fmt"
 	"net/url"
+	"sort"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -29,6 +30,7 @@ import (
 //
 //  url = module(
 //    encode_query,
+//    parse_query,
 //  )
 //
 // See `docs/modules.asciidoc` for details on the API of each function.
@@ -37,6 +39,7 @@ func NewModule() *starlarkstruct.Module {
 		Name: "url",
 		Members: starlark.StringDict{
 			"encode_query": starlark.NewBuiltin("url.encode_query", encodeQuery),
+			"parse_query":  starlark.NewBuiltin("url.parse_query", parseQuery),
 		},
 	}
 }
@@ -58,13 +61,54 @@ func encodeQuery(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
 			return nil, fmt.Errorf("Key is not string: %+v", key)
 		}
 
-		valStr, valIsStr := value.(starlark.String)
-		if !valIsStr {
-			return nil, fmt.Errorf("Value is not string: %+v", value)
+		// A list or tuple of strings adds the key once for each value.
+		values := []starlark.Value{value}
+		switch value := value.(type) {
+		case *starlark.List, starlark.Tuple:
+			seq := value.(starlark.Indexable)
+			values = make([]starlark.Value, seq.Len())
+			for i := range values {
+				values[i] = seq.Index(i)
+			}
+		}
+		for _, value := range values {
+			valStr, valIsStr := value.(starlark.String)
+			if !valIsStr {
+				return nil, fmt.Errorf("Value is not string: %+v", value)
+			}
+			urlVals.Add(string(keyStr), string(valStr))
 		}
-
-		urlVals.Add(string(keyStr), string(valStr))
 	}
 
 	return starlark.String(urlVals.Encode()), nil
 }
+
+func parseQuery(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var query string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &query); err != nil {
+		return nil, err
+	}
+
+	urlVals, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+
+	keys := make([]string, 0, len(urlVals))
+	for key := range urlVals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	d := starlark.NewDict(len(keys))
+	for _, key := range keys {
+		values := make([]starlark.Value, len(urlVals[key]))
+		for i, value := range urlVals[key] {
+			values[i] = starlark.String(value)
+		}
+		if err := d.SetKey(starlark.String(key), starlark.NewList(values)); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
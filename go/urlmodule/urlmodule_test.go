@@ -42,6 +42,21 @@ func TestEncodeQuery(t *testing.T) {
 			skyExpr:   `{"a": "value1 value2", "b": "/test/path"}`,
 			expOutput: "a=value1+value2&b=%2Ftest%2Fpath",
 		},
+		UrlTestCase{
+			name:      "Keys are sorted",
+			skyExpr:   `{"b": "2", "a": "1"}`,
+			expOutput: "a=1&b=2",
+		},
+		UrlTestCase{
+			name:      "List values repeat the key",
+			skyExpr:   `{"tag": ["x", "y&z"], "id": ("1",)}`,
+			expOutput: "id=1&tag=x&tag=y%26z",
+		},
+		UrlTestCase{
+			name:    "List value not a string",
+			skyExpr: `{"a": ["x", 5]}`,
+			expErr:  true,
+		},
 		UrlTestCase{
 			name:    "Called with a non-dict value",
 			skyExpr: "abc",
@@ -91,3 +106,75 @@ func TestEncodeQuery(t *testing.T) {
 		}
 	}
 }
+
+func TestParseQuery(t *testing.T) {
+	thread := new(starlark.Thread)
+	env := starlark.StringDict{
+		"url": NewModule(),
+	}
+
+	testCases := []UrlTestCase{
+		UrlTestCase{
+			name:      "All good",
+			skyExpr:   `"b=%2Ftest%2Fpath&a=value1+value2"`,
+			expOutput: `{"a": ["value1 value2"], "b": ["/test/path"]}`,
+		},
+		UrlTestCase{
+			name:      "Repeated keys",
+			skyExpr:   `"tag=x&id=1&tag=y"`,
+			expOutput: `{"id": ["1"], "tag": ["x", "y"]}`,
+		},
+		UrlTestCase{
+			name:      "Empty query",
+			skyExpr:   `""`,
+			expOutput: `{}`,
+		},
+		UrlTestCase{
+			name:      "Round trip",
+			skyExpr:   `url.encode_query({"q": "a b", "tag": ["x", "y"]})`,
+			expOutput: `{"q": ["a b"], "tag": ["x", "y"]}`,
+		},
+		UrlTestCase{
+			name:    "Invalid escape",
+			skyExpr: `"a=%zz"`,
+			expErr:  true,
+		},
+		UrlTestCase{
+			name:    "Called with a non-string value",
+			skyExpr: `{"a": "b"}`,
+			expErr:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		v, err := starlark.Eval(
+			thread,
+			"<expr>",
+			fmt.Sprintf("url.parse_query(%s)", testCase.skyExpr),
+			env,
+		)
+		if testCase.expErr {
+			if err == nil {
+				t.Error(
+					"Bad eval err result for case", testCase.name,
+					"\nExpected error",
+					"\nGot", err)
+			}
+		} else {
+			if err != nil {
+				t.Error(
+					"Bad eval err result for case", testCase.name,
+					"\nExpected nil",
+					"\nGot", err)
+				continue
+			}
+			if v.String() != testCase.expOutput {
+				t.Error(
+					"Bad return value for case", testCase.name,
+					"\nExpected", testCase.expOutput,
+					"\nGot", v,
+				)
+			}
+		}
+	}
+}
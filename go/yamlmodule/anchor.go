@@ -0,0 +1,142 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// addAnchors replaces repeated sequences and mappings within node with
+// aliases of their first occurrence, which is given an anchor. Only values
+// containing at least minSize scalars are replaced.
+func addAnchors(node *yaml.Node, minSize int) {
+	a := &anchorer{
+		minSize: minSize,
+		counts:  make(map[string]int),
+		anchors: make(map[string]*yaml.Node),
+	}
+	a.count(node)
+	a.replace(node)
+}
+
+type anchorer struct {
+	minSize int
+
+	// Number of times each value is written out, keyed by its canonical
+	// form. Values within a repeated value aren't counted again, since
+	// they will be covered by the repeated value's alias.
+	counts map[string]int
+
+	// Anchored nodes, keyed by their canonical form.
+	anchors map[string]*yaml.Node
+}
+
+func (a *anchorer) count(node *yaml.Node) {
+	for _, child := range anchorCandidates(node) {
+		if key, ok := a.key(child); ok {
+			a.counts[key]++
+			if a.counts[key] > 1 {
+				continue
+			}
+		}
+		a.count(child)
+	}
+}
+
+func (a *anchorer) replace(node *yaml.Node) {
+	for _, child := range anchorCandidates(node) {
+		if key, ok := a.key(child); ok && a.counts[key] > 1 {
+			if anchored, ok := a.anchors[key]; ok {
+				*child = yaml.Node{
+					Kind:  yaml.AliasNode,
+					Value: anchored.Anchor,
+					Alias: anchored,
+				}
+				continue
+			}
+			child.Anchor = fmt.Sprintf("id%03d", len(a.anchors)+1)
+			a.anchors[key] = child
+		}
+		a.replace(child)
+	}
+}
+
+// key returns the canonical form of a node, if it may be anchored.
+func (a *anchorer) key(node *yaml.Node) (string, bool) {
+	if node.Kind != yaml.SequenceNode && node.Kind != yaml.MappingNode {
+		return "", false
+	}
+	if scalarCount(node) < a.minSize {
+		return "", false
+	}
+	var buf strings.Builder
+	writeCanonical(&buf, node)
+	return buf.String(), true
+}
+
+// anchorCandidates returns the children of node that may be replaced by an
+// alias, which excludes the keys of a mapping.
+func anchorCandidates(node *yaml.Node) []*yaml.Node {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		return node.Content
+	case yaml.MappingNode:
+		values := make([]*yaml.Node, 0, len(node.Content)/2)
+		for i := 1; i < len(node.Content); i += 2 {
+			values = append(values, node.Content[i])
+		}
+		return values
+	}
+	return nil
+}
+
+// scalarCount returns the number of scalar values within node, not
+// including the keys of mappings.
+func scalarCount(node *yaml.Node) int {
+	if node.Kind == yaml.ScalarNode {
+		return 1
+	}
+	count := 0
+	for _, child := range anchorCandidates(node) {
+		count += scalarCount(child)
+	}
+	return count
+}
+
+func writeCanonical(buf *strings.Builder, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		fmt.Fprintf(buf, "%s %s", node.ShortTag(), strconv.Quote(node.Value))
+	case yaml.SequenceNode, yaml.MappingNode:
+		open, close := "{", "}"
+		if node.Kind == yaml.SequenceNode {
+			open, close = "[", "]"
+		}
+		buf.WriteString(open)
+		for i, child := range node.Content {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeCanonical(buf, child)
+		}
+		buf.WriteString(close)
+	}
+}
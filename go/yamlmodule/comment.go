@@ -0,0 +1,168 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func yamlComment(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	c := &yamlCommented{}
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"value", &c.value,
+		"head?", &c.head,
+		"line?", &c.line,
+	); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// yamlCommented is a value returned by yaml.comment, which yaml.encode writes
+// with a comment on the line before it, or at the end of its line.
+type yamlCommented struct {
+	value starlark.Value
+	head  string
+	line  string
+}
+
+var _ starlark.Value = (*yamlCommented)(nil)
+var _ starlark.HasAttrs = (*yamlCommented)(nil)
+
+func (c *yamlCommented) String() string {
+	return fmt.Sprintf("yaml.comment(%s, head = %q, line = %q)", c.value, c.head, c.line)
+}
+
+func (c *yamlCommented) Type() string          { return "yaml.comment" }
+func (c *yamlCommented) Freeze()               { c.value.Freeze() }
+func (c *yamlCommented) Truth() starlark.Bool  { return starlark.True }
+func (c *yamlCommented) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", c.Type()) }
+
+func (c *yamlCommented) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "value":
+		return c.value, nil
+	case "head":
+		return starlark.String(c.head), nil
+	case "line":
+		return starlark.String(c.line), nil
+	}
+	return nil, nil
+}
+
+func (c *yamlCommented) AttrNames() []string {
+	return []string{"head", "line", "value"}
+}
+
+// dropBlankLines removes the blank lines of a comment, which the yaml package
+// would otherwise write as blank lines rather than comment lines.
+func dropBlankLines(comment string) string {
+	lines := strings.Split(comment, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// commentedValue is a value converted from a yamlCommented.
+type commentedValue struct {
+	head  string
+	line  string
+	value interface{}
+}
+
+func (v commentedValue) buildNode(style yaml.Style) (*yaml.Node, error) {
+	node, err := buildNode(v.value, style)
+	if err != nil {
+		return nil, err
+	}
+	node.HeadComment = v.head
+	if v.line != "" {
+		firstLine(node).LineComment = v.line
+	}
+	return node, nil
+}
+
+// firstLine returns the node whose line comment is written at the end of the
+// first line of node. A block sequence or mapping has no line of its own, so
+// this is the node written first within it.
+func firstLine(node *yaml.Node) *yaml.Node {
+	for !isInline(node) {
+		if node.Kind == yaml.MappingNode {
+			return entryLine(node.Content[0], node.Content[1])
+		}
+		node = node.Content[0]
+	}
+	return node
+}
+
+// entryLine returns the node whose line comment is written at the end of the
+// first line of a mapping entry. That is the value if it is written on the
+// same line as its key, and otherwise the key, unless the key is written on
+// a line of its own or followed by a tag.
+func entryLine(key, value *yaml.Node) *yaml.Node {
+	switch {
+	case isInline(value):
+		return value
+	case isSimpleKey(key) && (value.Tag == "!!map" || value.Tag == "!!seq"):
+		return key
+	}
+	return firstLine(value)
+}
+
+// isInline reports whether node is written within a single line, such as a
+// scalar or an empty sequence, so that it can have a line comment of its own.
+func isInline(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode || node.Style&yaml.FlowStyle != 0 || len(node.Content) == 0
+}
+
+// isSimpleKey reports whether a mapping key is written on the same line as
+// its value, rather than after a "?" indicator, which the yaml package does
+// for keys that span several lines or are longer than 128 characters.
+func isSimpleKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && len(key.Value) <= 128 && !strings.Contains(key.Value, "\n")
+}
+
+// sortItems returns the items of a mapping with their keys in the same order
+// as the yaml package uses when encoding a Go map.
+func sortItems(m orderedMap) (orderedMap, error) {
+	index := make(map[interface{}]int, len(m))
+	for i, item := range m {
+		index[item.Key] = i
+	}
+	var node yaml.Node
+	if err := node.Encode(index); err != nil {
+		return nil, err
+	}
+	sorted := make(orderedMap, 0, len(m))
+	for i := 1; i < len(node.Content); i += 2 {
+		n, err := strconv.Atoi(node.Content[i].Value)
+		if err != nil {
+			return nil, err
+		}
+		sorted = append(sorted, m[n])
+	}
+	return sorted, nil
+}
@@ -0,0 +1,367 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// MapSlice is a mapping with its items in order, such as a YAML mapping
+// decoded in document order. It is used in place of a Go map so that keys are
+// inserted into the resulting starlark.Dict in the same order they were
+// written.
+type MapSlice []MapItem
+
+// MapItem is a single key-value pair of a MapSlice.
+type MapItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// decodeOptions control how YAML documents are decoded.
+type decodeOptions struct {
+	// Reject mappings that define the same key more than once, rather
+	// than keeping the last value.
+	strict bool
+
+	// Convert mapping keys that are sequences or mappings into strings,
+	// rather than reporting an error.
+	coerceKeys bool
+
+	// Keep custom tags, such as "!Ref", by wrapping tagged values in a
+	// taggedValue rather than discarding the tag.
+	keepTags bool
+
+	// Decode mappings to a yamlOrderedDict, which keeps the order of the
+	// document. Duplicate keys are always rejected, as with strict.
+	ordered bool
+
+	// Decode floats with no fractional part, such as 1.0, to ints, unless
+	// they're explicitly tagged !!float.
+	preferInt bool
+}
+
+// decoder converts a tree of YAML nodes into plain Go values, which can then
+// be converted to Starlark with toStarlarkValue.
+type decoder struct {
+	decodeOptions
+
+	// Aliases currently being expanded, used to detect recursive anchors.
+	aliases map[*yaml.Node]bool
+}
+
+func newDecoder(opts decodeOptions) *decoder {
+	return &decoder{
+		decodeOptions: opts,
+		aliases:       make(map[*yaml.Node]bool),
+	}
+}
+
+func (d *decoder) decode(node *yaml.Node) (interface{}, error) {
+	value, err := d.decodeNode(node)
+	if err != nil || !d.keepTags || !hasCustomTag(node) {
+		return value, err
+	}
+	return taggedValue{node.Tag, value}, nil
+}
+
+func (d *decoder) decodeNode(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case 0:
+		// Zero value, from decoding an empty input.
+		return nil, nil
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return d.decode(node.Content[0])
+	case yaml.AliasNode:
+		if d.aliases[node.Alias] {
+			return nil, nodeErrorf(node, "anchor %q value contains itself", node.Value)
+		}
+		d.aliases[node.Alias] = true
+		defer delete(d.aliases, node.Alias)
+		return d.decode(node.Alias)
+	case yaml.ScalarNode:
+		return d.scalar(node)
+	case yaml.SequenceNode:
+		slice := make([]interface{}, len(node.Content))
+		for i, child := range node.Content {
+			value, err := d.decode(child)
+			if err != nil {
+				return nil, err
+			}
+			slice[i] = value
+		}
+		return slice, nil
+	case yaml.MappingNode:
+		return d.mapping(node)
+	}
+	return nil, nodeErrorf(node, "unknown node kind %d", node.Kind)
+}
+
+func (d *decoder) scalar(node *yaml.Node) (interface{}, error) {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		msg := err.Error()
+		if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+			msg = typeErr.Errors[0]
+		}
+		msg = strings.TrimPrefix(lineErrorPattern.ReplaceAllString(msg, ""), "yaml: ")
+		return nil, nodeErrorf(node, "%s", msg)
+	}
+	// The yaml package decodes binary data to a string, which would be
+	// indistinguishable from text.
+	if s, ok := value.(string); ok && node.ShortTag() == binaryTag {
+		return []byte(s), nil
+	}
+	if f, ok := value.(float64); ok && d.preferInt && node.Style&yaml.TaggedStyle == 0 {
+		if i, ok := integralFloat(f); ok {
+			return i, nil
+		}
+	}
+	return value, nil
+}
+
+// integralFloat returns f as an int64 if it has no fractional part and is
+// within the range of an int64.
+func integralFloat(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func (d *decoder) mapping(node *yaml.Node) (interface{}, error) {
+	var items MapSlice
+	index := make(map[interface{}]int)
+	keyLines := make(map[interface{}]int)
+
+	// Keys set explicitly in this mapping replace earlier values. Keys
+	// merged in with "<<" never replace a value that is already present,
+	// so that explicit keys (and earlier merge sources) take precedence.
+	set := func(key, value interface{}, replace bool) {
+		if isComparable(key) {
+			if i, ok := index[key]; ok {
+				if replace {
+					items[i].Value = value
+				}
+				return
+			}
+			index[key] = len(items)
+		}
+		items = append(items, MapItem{key, value})
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		if isMerge(keyNode) {
+			sources, err := d.mergeSources(valueNode)
+			if err != nil {
+				return nil, err
+			}
+			for _, source := range sources {
+				for _, item := range source {
+					set(item.Key, item.Value, false)
+				}
+			}
+			continue
+		}
+		// Tags on keys are never kept, since a tagged value can't be
+		// used as a dict key.
+		key, err := d.decodeNode(keyNode)
+		if err != nil {
+			return nil, err
+		}
+		keyText := keyNode.Value
+		if d.coerceKeys {
+			if key, err = coerceKey(key); err != nil {
+				return nil, nodeErrorf(keyNode, "%v", err)
+			}
+			if s, ok := key.(string); ok {
+				keyText = s
+			}
+		}
+		if (d.strict || d.ordered) && isComparable(key) {
+			if line, ok := keyLines[key]; ok {
+				return nil, nodeErrorf(keyNode, "mapping key %q already defined at line %d", keyText, line)
+			}
+			keyLines[key] = keyNode.Line
+		}
+		value, err := d.decode(valueNode)
+		if err != nil {
+			return nil, err
+		}
+		set(key, value, true)
+	}
+	return items, nil
+}
+
+// mergeSources returns the mappings referenced by the value of a "<<" key,
+// which may be a single mapping or a sequence of mappings.
+func (d *decoder) mergeSources(node *yaml.Node) ([]MapSlice, error) {
+	nodes := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		nodes = node.Content
+	}
+	var sources []MapSlice
+	for _, n := range nodes {
+		value, err := d.decode(n)
+		if err != nil {
+			return nil, err
+		}
+		if tagged, ok := value.(taggedValue); ok {
+			value = tagged.value
+		}
+		source, ok := value.(MapSlice)
+		if !ok {
+			return nil, nodeErrorf(n, "map merge requires map or sequence of maps as the value")
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// coerceKey converts a sequence or mapping key into its canonical string
+// form, which is the key encoded as single-line YAML in flow style with any
+// mapping keys sorted. Other keys are returned unchanged.
+func coerceKey(key interface{}) (interface{}, error) {
+	switch key.(type) {
+	case []interface{}, MapSlice:
+	default:
+		return key, nil
+	}
+	obj, err := toEncodable(key, encodeOptions{sortKeys: true})
+	if err != nil {
+		return nil, err
+	}
+	node, err := buildNode(obj, yaml.FlowStyle)
+	if err != nil {
+		return nil, err
+	}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// quoteStrings sets every string scalar within node, including mapping keys,
+// to double-quoted style.
+func quoteStrings(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	for _, child := range node.Content {
+		quoteStrings(child)
+	}
+}
+
+const binaryTag = "!!binary"
+
+func isMerge(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!merge"
+}
+
+// isEmptyDocument reports whether a document has no content, for example
+// the implicit document following a trailing "---".
+func isEmptyDocument(doc *yaml.Node) bool {
+	if len(doc.Content) == 0 {
+		return true
+	}
+	content := doc.Content[0]
+	return content.Kind == yaml.ScalarNode && content.Tag == "!!null" && content.Value == "" && content.Style == 0
+}
+
+func isComparable(v interface{}) bool {
+	rt := reflect.TypeOf(v)
+	return rt == nil || rt.Comparable()
+}
+
+// decodeError is an error found while decoding a YAML document, annotated
+// with its position and a snippet of the offending source line.
+type decodeError struct {
+	line    int // 1-based
+	column  int // 1-based, or 0 if unknown
+	msg     string
+	snippet string
+}
+
+func (e *decodeError) Error() string {
+	var buf strings.Builder
+	if e.column > 0 {
+		fmt.Fprintf(&buf, "yaml: line %d, column %d: %s", e.line, e.column, e.msg)
+	} else {
+		fmt.Fprintf(&buf, "yaml: line %d: %s", e.line, e.msg)
+	}
+	if e.snippet != "" {
+		fmt.Fprintf(&buf, "\n  %s", e.snippet)
+		if e.column > 0 && e.column <= len(e.snippet) {
+			fmt.Fprintf(&buf, "\n  %s^", strings.Repeat(" ", e.column-1))
+		}
+	}
+	return buf.String()
+}
+
+func nodeErrorf(node *yaml.Node, format string, args ...interface{}) error {
+	return &decodeError{
+		line:   node.Line,
+		column: node.Column,
+		msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// Matches the position prefix of errors reported by the yaml package, such
+// as "yaml: line 3: did not find expected key".
+var lineErrorPattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// maxSnippetLen is the maximum length of a source snippet in a decodeError.
+const maxSnippetLen = 80
+
+// annotateError adds position information and a source snippet to an error
+// returned while decoding src. Errors without a known position are returned
+// unchanged.
+func annotateError(err error, src string) error {
+	decodeErr, ok := err.(*decodeError)
+	if !ok {
+		match := lineErrorPattern.FindStringSubmatch(err.Error())
+		if match == nil {
+			return err
+		}
+		line, _ := strconv.Atoi(match[1])
+		decodeErr = &decodeError{
+			line: line,
+			msg:  err.Error()[len(match[0]):],
+		}
+	}
+	lines := strings.Split(src, "\n")
+	if decodeErr.line >= 1 && decodeErr.line <= len(lines) {
+		snippet := strings.TrimRight(lines[decodeErr.line-1], "\r")
+		if len(snippet) > maxSnippetLen {
+			snippet = snippet[:maxSnippetLen] + "..."
+		}
+		decodeErr.snippet = snippet
+	}
+	return decodeErr
+}
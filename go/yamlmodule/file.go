@@ -0,0 +1,111 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"go.starlark.net/starlark"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Starlark thread-local storage key for the reader used by yaml.decode_file.
+const fileReaderKey = "yamlmodule.filereader" // has type *threadFileReader
+
+// A FileReader resolves and reads the files decoded by yaml.decode_file. It
+// has the same methods as skycfg.FileReader, so that YAML files are found in
+// the same way as modules passed to load().
+type FileReader interface {
+	Resolve(ctx context.Context, name, fromPath string) (path string, err error)
+	ReadFile(ctx context.Context, path string) ([]byte, error)
+}
+
+type threadFileReader struct {
+	ctx    context.Context
+	reader FileReader
+}
+
+// SetFileReader sets the FileReader used by yaml.decode_file when called
+// from the given thread. Files are read with the given context.
+func SetFileReader(thread *starlark.Thread, ctx context.Context, r FileReader) {
+	thread.SetLocal(fileReaderKey, &threadFileReader{ctx, r})
+}
+
+func yamlDecodeFile(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var multi bool
+	var opts decodeOptions
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"path", &name,
+		"multi?", &multi,
+		"strict?", &opts.strict,
+		"coerce_keys?", &opts.coerceKeys,
+		"keep_tags?", &opts.keepTags,
+		"ordered?", &opts.ordered,
+		"prefer_int?", &opts.preferInt,
+	); err != nil {
+		return nil, err
+	}
+	r, ok := t.Local(fileReaderKey).(*threadFileReader)
+	if !ok {
+		return nil, fmt.Errorf("%s: no FileReader is configured", fn.Name())
+	}
+
+	// Paths are resolved relative to the calling module, as for load().
+	var fromPath string
+	if t.CallStackDepth() > 1 {
+		fromPath = t.CallFrame(1).Pos.Filename()
+	}
+	path, err := r.reader.Resolve(r.ctx, name, fromPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.reader.ReadFile(r.ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// As for yaml.decode, a file with no document at all is an error.
+	if !multi && len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("%s: %s: empty YAML input", fn.Name(), path)
+	}
+
+	value, err := decodeReader(bytes.NewReader(data), multi, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, annotateError(err, string(data)))
+	}
+	return value, nil
+}
+
+// decodeReader decodes a YAML stream without first copying it into a string.
+func decodeReader(r io.Reader, multi bool, opts decodeOptions) (starlark.Value, error) {
+	if multi {
+		return decodeAll(r, opts)
+	}
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil && err != io.EOF {
+		return nil, err
+	}
+	inflated, err := newDecoder(opts).decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	return toStarlarkDocument(inflated, opts)
+}
@@ -1,116 +0,0 @@
-// Copyright (c) 2017 The Bazel Authors.  All rights reserved.
-//
-// Redistribution and use in source and binary forms, with or without
-// modification, are permitted provided that the following conditions are
-// met:
-//
-// 1. Redistributions of source code must retain the above copyright
-//    notice, this list of conditions and the following disclaimer.
-//
-// 2. Redistributions in binary form must reproduce the above copyright
-//    notice, this list of conditions and the following disclaimer in the
-//    documentation and/or other materials provided with the
-//    distribution.
-//
-// 3. Neither the name of the copyright holder nor the names of its
-//    contributors may be used to endorse or promote products derived
-//    from this software without specific prior written permission.
-//
-// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
-// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
-// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
-// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
-// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
-// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
-// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
-// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
-// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
-// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
-// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
-//
-// SPDX-License-Identifier: BSD-3-Clause
-
-package yamlmodule
-
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-
-	"go.starlark.net/starlark"
-)
-
-// Adapted from struct-specific JSON function:
-// https://github.com/google/starlark-go/blob/67717b5898061eb621519a94a4b89cedede9bca0/skylarkstruct/struct.go#L321
-func writeJSON(out *bytes.Buffer, v starlark.Value) error {
-	if marshaler, ok := v.(json.Marshaler); ok {
-		jsonData, err := marshaler.MarshalJSON()
-		if err != nil {
-			return err
-		}
-		out.Write(jsonData)
-		return nil
-	}
-
-	switch v := v.(type) {
-	case starlark.NoneType:
-		out.WriteString("null")
-	case starlark.Bool:
-		fmt.Fprintf(out, "%t", v)
-	case starlark.Int:
-		out.WriteString(v.String())
-	case starlark.Float:
-		fmt.Fprintf(out, "%g", v)
-	case starlark.String:
-		s := string(v)
-		if goQuoteIsSafe(s) {
-			fmt.Fprintf(out, "%q", s)
-		} else {
-			// vanishingly rare for text strings
-			data, _ := json.Marshal(s)
-			out.Write(data)
-		}
-	case starlark.Indexable: // Tuple, List
-		out.WriteByte('[')
-		for i, n := 0, starlark.Len(v); i < n; i++ {
-			if i > 0 {
-				out.WriteString(", ")
-			}
-			if err := writeJSON(out, v.Index(i)); err != nil {
-				return err
-			}
-		}
-		out.WriteByte(']')
-	case *starlark.Dict:
-		out.WriteByte('{')
-		for i, itemPair := range v.Items() {
-			key := itemPair[0]
-			value := itemPair[1]
-			if i > 0 {
-				out.WriteString(", ")
-			}
-			if err := writeJSON(out, key); err != nil {
-				return err
-			}
-			out.WriteString(": ")
-			if err := writeJSON(out, value); err != nil {
-				return err
-			}
-		}
-		out.WriteByte('}')
-	default:
-		return fmt.Errorf("TypeError: value %s (type `%s') can't be converted to JSON.", v.String(), v.Type())
-	}
-	return nil
-}
-
-func goQuoteIsSafe(s string) bool {
-	for _, r := range s {
-		// JSON doesn't like Go's \xHH escapes for ASCII control codes,
-		// nor its \UHHHHHHHH escapes for runes >16 bits.
-		if r < 0x20 || r >= 0x10000 {
-			return false
-		}
-	}
-	return true
-}
@@ -0,0 +1,281 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func yamlDecodeNode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var blob string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "blob", &blob); err != nil {
+		return nil, err
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(blob), doc); err != nil {
+		return nil, annotateError(err, blob)
+	}
+	if doc.Kind == 0 {
+		doc = &yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!null"}},
+		}
+	}
+	return &yamlNode{doc: doc}, nil
+}
+
+func yamlEncodeNode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var node *yamlNode
+	indent := 2
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"node", &node,
+		"indent?", &indent,
+	); err != nil {
+		return nil, err
+	}
+	if indent < 2 || indent > 9 {
+		return nil, fmt.Errorf("%s: for parameter indent: got %d, want a value between 2 and 9", fn.Name(), indent)
+	}
+	yamlBytes, err := marshalNode(node.doc, indent)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(yamlBytes), nil
+}
+
+var yamlNodeMethods = map[string]func(*yamlNode) starlark.Value{
+	"get": (*yamlNode).wrapGet,
+	"set": (*yamlNode).wrapSet,
+}
+
+// yamlNode is a YAML document returned from yaml.decode_node, which retains
+// the comments and formatting of the original document so that it can be
+// edited and encoded again with yaml.encode_node.
+type yamlNode struct {
+	doc    *yaml.Node
+	frozen bool
+}
+
+var _ starlark.Value = (*yamlNode)(nil)
+var _ starlark.HasAttrs = (*yamlNode)(nil)
+
+func (n *yamlNode) String() string        { return "<yaml.node>" }
+func (n *yamlNode) Type() string          { return "yaml.node" }
+func (n *yamlNode) Freeze()               { n.frozen = true }
+func (n *yamlNode) Truth() starlark.Bool  { return starlark.True }
+func (n *yamlNode) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", n.Type()) }
+
+func (n *yamlNode) Attr(name string) (starlark.Value, error) {
+	if wrapper, ok := yamlNodeMethods[name]; ok {
+		return wrapper(n), nil
+	}
+	return nil, nil
+}
+
+func (n *yamlNode) AttrNames() []string {
+	return []string{"get", "set"}
+}
+
+func (n *yamlNode) wrapGet() starlark.Value {
+	impl := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+		}
+		node, err := n.find(args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		inflated, err := newDecoder(decodeOptions{}).decode(node)
+		if err != nil {
+			return nil, err
+		}
+		return toStarlarkValue(inflated)
+	}
+	return starlark.NewBuiltin("get", impl).BindReceiver(n)
+}
+
+func (n *yamlNode) wrapSet() starlark.Value {
+	impl := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+		}
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s: got %d arguments, want a path and a value", b.Name(), len(args))
+		}
+		if n.frozen {
+			return nil, fmt.Errorf("%s: cannot modify frozen %s", b.Name(), n.Type())
+		}
+		path, value := args[:len(args)-1], args[len(args)-1]
+		if err := n.set(path, value); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		return starlark.None, nil
+	}
+	return starlark.NewBuiltin("set", impl).BindReceiver(n)
+}
+
+// find returns the node at a path of mapping keys and sequence indices.
+func (n *yamlNode) find(path starlark.Tuple) (*yaml.Node, error) {
+	node := n.doc.Content[0]
+	for i, elem := range path {
+		for node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		switch node.Kind {
+		case yaml.MappingNode:
+			key, ok := starlark.AsString(elem)
+			if !ok {
+				return nil, fmt.Errorf("at %s: got %s, want string key for mapping", formatPath(path[:i]), elem.Type())
+			}
+			_, value := mappingEntry(node, key)
+			if value == nil {
+				return nil, fmt.Errorf("at %s: key %q not found", formatPath(path[:i]), key)
+			}
+			node = value
+		case yaml.SequenceNode:
+			index, err := starlark.AsInt32(elem)
+			if err != nil {
+				return nil, fmt.Errorf("at %s: got %s, want int index for sequence", formatPath(path[:i]), elem.Type())
+			}
+			if index < 0 || index >= len(node.Content) {
+				return nil, fmt.Errorf("at %s: index %d out of range", formatPath(path[:i]), index)
+			}
+			node = node.Content[index]
+		default:
+			return nil, fmt.Errorf("at %s: cannot index scalar value", formatPath(path[:i]))
+		}
+	}
+	return node, nil
+}
+
+// set replaces the node at the given path with a new value, keeping any
+// comments of the node being replaced. A missing key of a mapping is added,
+// as is an index one past the end of a sequence.
+func (n *yamlNode) set(path starlark.Tuple, v starlark.Value) error {
+	parent, err := n.find(path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	for parent.Kind == yaml.AliasNode {
+		parent = parent.Alias
+	}
+	value, err := encodeNode(v, encodeOptions{sortKeys: true})
+	if err != nil {
+		return err
+	}
+
+	elem := path[len(path)-1]
+	where := formatPath(path[:len(path)-1])
+	switch parent.Kind {
+	case yaml.MappingNode:
+		key, ok := starlark.AsString(elem)
+		if !ok {
+			return fmt.Errorf("at %s: got %s, want string key for mapping", where, elem.Type())
+		}
+		if i, old := mappingEntry(parent, key); old != nil {
+			parent.Content[i] = replaceNode(old, value)
+			moveHeadComment(parent.Content[i-1], parent.Content[i])
+		} else {
+			keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+			moveHeadComment(keyNode, value)
+			parent.Content = append(parent.Content, keyNode, value)
+		}
+	case yaml.SequenceNode:
+		index, err := starlark.AsInt32(elem)
+		if err != nil {
+			return fmt.Errorf("at %s: got %s, want int index for sequence", where, elem.Type())
+		}
+		switch {
+		case index >= 0 && index < len(parent.Content):
+			parent.Content[index] = replaceNode(parent.Content[index], value)
+		case index == len(parent.Content):
+			parent.Content = append(parent.Content, value)
+		default:
+			return fmt.Errorf("at %s: index %d out of range", where, index)
+		}
+	default:
+		return fmt.Errorf("at %s: cannot index scalar value", where)
+	}
+	return nil
+}
+
+// replaceNode returns the node that should replace old in its parent. The
+// comments of old are kept, and scalars are updated in place so that any
+// aliases of old see the new value.
+func replaceNode(old, value *yaml.Node) *yaml.Node {
+	if old.Kind == yaml.ScalarNode && value.Kind == yaml.ScalarNode {
+		// Custom tags, such as "!Ref", are kept.
+		if !strings.HasPrefix(old.Tag, "!") || strings.HasPrefix(old.Tag, "!!") {
+			old.Tag = value.Tag
+		}
+		// Quoting is kept only if the value is still a string.
+		if value.ShortTag() != "!!str" {
+			old.Style = 0
+		}
+		old.Value = value.Value
+		return old
+	}
+	// Comments given to the new value with yaml.comment are kept where
+	// the old node has none.
+	if old.HeadComment != "" {
+		value.HeadComment = old.HeadComment
+	}
+	if old.LineComment != "" {
+		value.LineComment = old.LineComment
+	}
+	value.FootComment = old.FootComment
+	value.Anchor = old.Anchor
+	return value
+}
+
+// moveHeadComment moves the head comment of a mapping value to its key, which
+// is where it must be to be written before the key. A comment the key
+// already has is kept instead.
+func moveHeadComment(key, value *yaml.Node) {
+	if key.HeadComment == "" {
+		key.HeadComment = value.HeadComment
+	}
+	value.HeadComment = ""
+}
+
+// mappingEntry returns the index within node.Content and value of the
+// mapping entry with the given key, or a nil value if it isn't present.
+func mappingEntry(node *yaml.Node, key string) (int, *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		if keyNode.Kind == yaml.ScalarNode && keyNode.Value == key && !isMerge(keyNode) {
+			return i + 1, node.Content[i+1]
+		}
+	}
+	return 0, nil
+}
+
+// formatPath formats a path of keys and indices for error messages.
+func formatPath(path starlark.Tuple) string {
+	if len(path) == 0 {
+		return "document root"
+	}
+	elems := make([]string, len(path))
+	for i, elem := range path {
+		elems[i] = elem.String()
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
@@ -0,0 +1,101 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// yamlOrderedDict is a mapping decoded by yaml.decode with ordered, whose
+// keys are in the order they were written in the document. It can't be
+// modified, so that its order always reflects the document.
+type yamlOrderedDict struct {
+	dict *starlark.Dict
+}
+
+var _ starlark.IterableMapping = (*yamlOrderedDict)(nil)
+var _ starlark.Sequence = (*yamlOrderedDict)(nil)
+var _ starlark.HasAttrs = (*yamlOrderedDict)(nil)
+var _ starlark.Comparable = (*yamlOrderedDict)(nil)
+
+func (d *yamlOrderedDict) String() string       { return d.dict.String() }
+func (d *yamlOrderedDict) Type() string         { return "yaml.ordered_dict" }
+func (d *yamlOrderedDict) Freeze()              { d.dict.Freeze() }
+func (d *yamlOrderedDict) Truth() starlark.Bool { return d.dict.Truth() }
+func (d *yamlOrderedDict) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: %s", d.Type())
+}
+
+func (d *yamlOrderedDict) Get(k starlark.Value) (starlark.Value, bool, error) { return d.dict.Get(k) }
+func (d *yamlOrderedDict) Items() []starlark.Tuple                            { return d.dict.Items() }
+func (d *yamlOrderedDict) Iterate() starlark.Iterator                         { return d.dict.Iterate() }
+func (d *yamlOrderedDict) Len() int                                           { return d.dict.Len() }
+
+// Only the methods of a dict that don't modify it are supported.
+var yamlOrderedDictMethods = []string{"get", "items", "keys", "values"}
+
+func (d *yamlOrderedDict) Attr(name string) (starlark.Value, error) {
+	for _, method := range yamlOrderedDictMethods {
+		if name == method {
+			return d.dict.Attr(name)
+		}
+	}
+	return nil, nil
+}
+
+func (d *yamlOrderedDict) AttrNames() []string {
+	return yamlOrderedDictMethods
+}
+
+func (d *yamlOrderedDict) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	other := y.(*yamlOrderedDict)
+	switch op {
+	case syntax.EQL, syntax.NEQ:
+		// Equal dicts must also have their keys in the same order.
+		eq, err := starlark.EqualDepth(d.dict, other.dict, depth-1)
+		if err != nil {
+			return false, err
+		}
+		if eq {
+			eq, err = starlark.EqualDepth(starlark.Tuple(d.dict.Keys()), starlark.Tuple(other.dict.Keys()), depth-1)
+		}
+		return eq == (op == syntax.EQL), err
+	}
+	return false, fmt.Errorf("%s %s %s not implemented", d.Type(), op, other.Type())
+}
+
+// orderDicts replaces every dict within a decoded value with a
+// yamlOrderedDict.
+func orderDicts(v starlark.Value) starlark.Value {
+	switch v := v.(type) {
+	case *starlark.Dict:
+		for _, item := range v.Items() {
+			v.SetKey(item[0], orderDicts(item[1]))
+		}
+		return &yamlOrderedDict{dict: v}
+	case *starlark.List:
+		for i := 0; i < v.Len(); i++ {
+			v.SetIndex(i, orderDicts(v.Index(i)))
+		}
+	case *yamlTagged:
+		v.value = orderDicts(v.value)
+	}
+	return v
+}
@@ -0,0 +1,575 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.starlark.net/starlark"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func yamlValidate(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var blob string
+	var schemaValue starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"blob", &blob,
+		"schema", &schemaValue,
+	); err != nil {
+		return nil, err
+	}
+	switch schemaValue.(type) {
+	case *starlark.Dict, starlark.Bool:
+	default:
+		return nil, fmt.Errorf("%s: for parameter schema: got %s, want dict or bool", fn.Name(), schemaValue.Type())
+	}
+	schema, err := fromStarlarkValue(schemaValue)
+	if err != nil {
+		return nil, fmt.Errorf("%s: for parameter schema: %v", fn.Name(), err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(blob), &doc); err != nil {
+		return nil, annotateError(err, blob)
+	}
+	inflated, err := newDecoder(decodeOptions{}).decode(&doc)
+	if err != nil {
+		return nil, annotateError(err, blob)
+	}
+
+	v := &validator{}
+	if err := v.validate(toJSONValue(inflated), toJSONValue(schema), "#", "#"); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	if len(v.violations) > 0 {
+		return nil, fmt.Errorf("%s: document does not match schema:\n  %s", fn.Name(), strings.Join(v.violations, "\n  "))
+	}
+	return starlark.None, nil
+}
+
+// jsonObject is a mapping from the JSON data model, with its keys in order.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// toJSONValue converts a decoded YAML value into the JSON data model, so
+// that it can be validated against a JSON Schema. Mappings become
+// jsonObjects with string keys, integers become *big.Int, and timestamps
+// and binary data become strings.
+func toJSONValue(obj interface{}) interface{} {
+	switch obj := obj.(type) {
+	case int:
+		return big.NewInt(int64(obj))
+	case int64:
+		return big.NewInt(obj)
+	case uint64:
+		return new(big.Int).SetUint64(obj)
+	case *big.Int:
+		return obj
+	case time.Time:
+		return obj.Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(obj)
+	case []interface{}:
+		ret := make([]interface{}, len(obj))
+		for i, element := range obj {
+			ret[i] = toJSONValue(element)
+		}
+		return ret
+	case MapSlice:
+		ret := &jsonObject{values: make(map[string]interface{}, len(obj))}
+		for _, item := range obj {
+			key, ok := item.Key.(string)
+			if !ok {
+				key = fmt.Sprint(item.Key)
+			}
+			if _, ok := ret.values[key]; !ok {
+				ret.keys = append(ret.keys, key)
+			}
+			ret.values[key] = toJSONValue(item.Value)
+		}
+		return ret
+	}
+	return obj
+}
+
+type validator struct {
+	violations []string
+}
+
+func (v *validator) violation(path string, format string, args ...interface{}) {
+	v.violations = append(v.violations, path+": "+fmt.Sprintf(format, args...))
+}
+
+// validate checks value (at path in the document) against schema (at
+// schemaPath in the schema), recording any violations. An error is returned
+// only if the schema itself is invalid.
+func (v *validator) validate(value, schema interface{}, path, schemaPath string) error {
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			v.violation(path, "value is not allowed")
+		}
+		return nil
+	case *jsonObject:
+		for _, keyword := range schema.keys {
+			if err := v.keyword(value, schema, keyword, path, schemaPath+"/"+escapePointer(keyword)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid schema at %s: got %s, want object or boolean", schemaPath, jsonType(schema))
+}
+
+func (v *validator) keyword(value interface{}, schema *jsonObject, keyword, path, schemaPath string) error {
+	arg := schema.values[keyword]
+	switch keyword {
+	case "type":
+		var types []string
+		switch arg := arg.(type) {
+		case string:
+			types = []string{arg}
+		case []interface{}:
+			for _, t := range arg {
+				s, ok := t.(string)
+				if !ok {
+					return fmt.Errorf("invalid schema at %s: got %s, want string", schemaPath, jsonType(t))
+				}
+				types = append(types, s)
+			}
+		default:
+			return fmt.Errorf("invalid schema at %s: got %s, want string or array", schemaPath, jsonType(arg))
+		}
+		for _, t := range types {
+			if hasType(value, t) {
+				return nil
+			}
+		}
+		v.violation(path, "got %s, want %s", jsonType(value), strings.Join(types, " or "))
+	case "enum":
+		values, ok := arg.([]interface{})
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want array", schemaPath, jsonType(arg))
+		}
+		for _, want := range values {
+			if jsonEqual(value, want) {
+				return nil
+			}
+		}
+		v.violation(path, "got %s, want one of %s", jsonString(value), jsonString(arg))
+	case "const":
+		if !jsonEqual(value, arg) {
+			v.violation(path, "got %s, want %s", jsonString(value), jsonString(arg))
+		}
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+		limit, ok := toFloat(arg)
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want number", schemaPath, jsonType(arg))
+		}
+		n, ok := toFloat(value)
+		if !ok {
+			return nil
+		}
+		switch {
+		case keyword == "minimum" && n < limit:
+			v.violation(path, "got %s, want at least %s", jsonString(value), jsonString(arg))
+		case keyword == "maximum" && n > limit:
+			v.violation(path, "got %s, want at most %s", jsonString(value), jsonString(arg))
+		case keyword == "exclusiveMinimum" && n <= limit:
+			v.violation(path, "got %s, want more than %s", jsonString(value), jsonString(arg))
+		case keyword == "exclusiveMaximum" && n >= limit:
+			v.violation(path, "got %s, want less than %s", jsonString(value), jsonString(arg))
+		case keyword == "multipleOf":
+			if limit <= 0 {
+				return fmt.Errorf("invalid schema at %s: got %s, want a positive number", schemaPath, jsonString(arg))
+			}
+			if q := n / limit; math.Abs(q-math.Round(q)) > 1e-9 {
+				v.violation(path, "got %s, want a multiple of %s", jsonString(value), jsonString(arg))
+			}
+		}
+	case "minLength", "maxLength":
+		limit, err := schemaCount(arg, schemaPath)
+		if err != nil {
+			return err
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		length := utf8.RuneCountInString(s)
+		if keyword == "minLength" && length < limit {
+			v.violation(path, "got string of length %d, want at least %d", length, limit)
+		} else if keyword == "maxLength" && length > limit {
+			v.violation(path, "got string of length %d, want at most %d", length, limit)
+		}
+	case "pattern":
+		s, ok := arg.(string)
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want string", schemaPath, jsonType(arg))
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return fmt.Errorf("invalid schema at %s: %v", schemaPath, err)
+		}
+		if str, ok := value.(string); ok && !re.MatchString(str) {
+			v.violation(path, "got %q, want a string matching %q", str, s)
+		}
+	case "minItems", "maxItems":
+		limit, err := schemaCount(arg, schemaPath)
+		if err != nil {
+			return err
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		if keyword == "minItems" && len(items) < limit {
+			v.violation(path, "got array of length %d, want at least %d", len(items), limit)
+		} else if keyword == "maxItems" && len(items) > limit {
+			v.violation(path, "got array of length %d, want at most %d", len(items), limit)
+		}
+	case "uniqueItems":
+		unique, ok := arg.(bool)
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want boolean", schemaPath, jsonType(arg))
+		}
+		items, ok := value.([]interface{})
+		if !ok || !unique {
+			return nil
+		}
+		for i := range items {
+			for j := 0; j < i; j++ {
+				if jsonEqual(items[i], items[j]) {
+					v.violation(path, "items %d and %d are equal, want unique items", j, i)
+					return nil
+				}
+			}
+		}
+	case "items":
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		if tuple, ok := arg.([]interface{}); ok {
+			for i, itemSchema := range tuple {
+				if i >= len(items) {
+					break
+				}
+				if err := v.validate(items[i], itemSchema, fmt.Sprintf("%s/%d", path, i), fmt.Sprintf("%s/%d", schemaPath, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for i, item := range items {
+			if err := v.validate(item, arg, fmt.Sprintf("%s/%d", path, i), schemaPath); err != nil {
+				return err
+			}
+		}
+	case "additionalItems":
+		tuple, ok := schema.values["items"].([]interface{})
+		items, isArray := value.([]interface{})
+		if !ok || !isArray {
+			return nil
+		}
+		for i := len(tuple); i < len(items); i++ {
+			if err := v.validate(items[i], arg, fmt.Sprintf("%s/%d", path, i), schemaPath); err != nil {
+				return err
+			}
+		}
+	case "required":
+		names, ok := arg.([]interface{})
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want array", schemaPath, jsonType(arg))
+		}
+		obj, ok := value.(*jsonObject)
+		if !ok {
+			return nil
+		}
+		for _, name := range names {
+			s, ok := name.(string)
+			if !ok {
+				return fmt.Errorf("invalid schema at %s: got %s, want string", schemaPath, jsonType(name))
+			}
+			if _, ok := obj.values[s]; !ok {
+				v.violation(path, "missing required property %q", s)
+			}
+		}
+	case "minProperties", "maxProperties":
+		limit, err := schemaCount(arg, schemaPath)
+		if err != nil {
+			return err
+		}
+		obj, ok := value.(*jsonObject)
+		if !ok {
+			return nil
+		}
+		if keyword == "minProperties" && len(obj.keys) < limit {
+			v.violation(path, "got object with %d properties, want at least %d", len(obj.keys), limit)
+		} else if keyword == "maxProperties" && len(obj.keys) > limit {
+			v.violation(path, "got object with %d properties, want at most %d", len(obj.keys), limit)
+		}
+	case "properties":
+		properties, ok := arg.(*jsonObject)
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want object", schemaPath, jsonType(arg))
+		}
+		obj, ok := value.(*jsonObject)
+		if !ok {
+			return nil
+		}
+		for _, name := range properties.keys {
+			if propValue, ok := obj.values[name]; ok {
+				if err := v.validate(propValue, properties.values[name], path+"/"+escapePointer(name), schemaPath+"/"+escapePointer(name)); err != nil {
+					return err
+				}
+			}
+		}
+	case "patternProperties":
+		patterns, ok := arg.(*jsonObject)
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want object", schemaPath, jsonType(arg))
+		}
+		obj, ok := value.(*jsonObject)
+		if !ok {
+			return nil
+		}
+		for _, pattern := range patterns.keys {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid schema at %s: %v", schemaPath+"/"+escapePointer(pattern), err)
+			}
+			for _, name := range obj.keys {
+				if re.MatchString(name) {
+					if err := v.validate(obj.values[name], patterns.values[pattern], path+"/"+escapePointer(name), schemaPath+"/"+escapePointer(pattern)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	case "additionalProperties":
+		obj, ok := value.(*jsonObject)
+		if !ok {
+			return nil
+		}
+		properties, _ := schema.values["properties"].(*jsonObject)
+		patterns, _ := schema.values["patternProperties"].(*jsonObject)
+		for _, name := range obj.keys {
+			if properties != nil {
+				if _, ok := properties.values[name]; ok {
+					continue
+				}
+			}
+			if patterns != nil && matchesAnyPattern(name, patterns.keys) {
+				continue
+			}
+			if arg == false {
+				v.violation(path, "unexpected property %q", name)
+				continue
+			}
+			if err := v.validate(obj.values[name], arg, path+"/"+escapePointer(name), schemaPath); err != nil {
+				return err
+			}
+		}
+	case "allOf", "anyOf", "oneOf":
+		schemas, ok := arg.([]interface{})
+		if !ok {
+			return fmt.Errorf("invalid schema at %s: got %s, want array", schemaPath, jsonType(arg))
+		}
+		matches := 0
+		for i, subschema := range schemas {
+			sub := &validator{}
+			if err := sub.validate(value, subschema, path, fmt.Sprintf("%s/%d", schemaPath, i)); err != nil {
+				return err
+			}
+			if len(sub.violations) == 0 {
+				matches++
+			} else if keyword == "allOf" {
+				v.violations = append(v.violations, sub.violations...)
+			}
+		}
+		switch {
+		case keyword == "anyOf" && matches == 0:
+			v.violation(path, "value does not match any schema in anyOf")
+		case keyword == "oneOf" && matches == 0:
+			v.violation(path, "value does not match any schema in oneOf")
+		case keyword == "oneOf" && matches > 1:
+			v.violation(path, "value matches %d schemas in oneOf, want exactly one", matches)
+		}
+	case "not":
+		sub := &validator{}
+		if err := sub.validate(value, arg, path, schemaPath); err != nil {
+			return err
+		}
+		if len(sub.violations) == 0 {
+			v.violation(path, "value must not match the schema in not")
+		}
+	case "$ref":
+		return fmt.Errorf("invalid schema at %s: $ref is not supported", schemaPath)
+	}
+	// Other keywords are annotations, or aren't supported, and are ignored.
+	return nil
+}
+
+func schemaCount(arg interface{}, schemaPath string) (int, error) {
+	if i, ok := arg.(*big.Int); ok && i.Sign() >= 0 && i.IsInt64() && i.Int64() <= math.MaxInt32 {
+		return int(i.Int64()), nil
+	}
+	return 0, fmt.Errorf("invalid schema at %s: got %s, want a non-negative integer", schemaPath, jsonString(arg))
+}
+
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil && re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePointer escapes a property name for use in a JSON pointer.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func hasType(value interface{}, t string) bool {
+	actual := jsonType(value)
+	return actual == t || (t == "number" && actual == "integer") || (t == "integer" && isIntegral(value))
+}
+
+// jsonType returns the JSON Schema type name of a value.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case *big.Int:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case *jsonObject:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func isIntegral(value interface{}) bool {
+	f, ok := value.(float64)
+	return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(value).Float64()
+		return f, true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// jsonEqual reports whether two values are equal in the JSON data model,
+// in which integers and floats of the same value are equal and the order
+// of object properties is insignificant.
+func jsonEqual(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			if i, ok := a.(*big.Int); ok {
+				if j, ok := b.(*big.Int); ok {
+					return i.Cmp(j) == 0
+				}
+			}
+			return x == y
+		}
+		return false
+	}
+	switch a := a.(type) {
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case *jsonObject:
+		b, ok := b.(*jsonObject)
+		if !ok || len(a.keys) != len(b.keys) {
+			return false
+		}
+		for _, key := range a.keys {
+			other, ok := b.values[key]
+			if !ok || !jsonEqual(a.values[key], other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// jsonString formats a value as JSON, for use in error messages.
+func jsonString(value interface{}) string {
+	out, err := json.Marshal(toMarshalable(value))
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
+
+func toMarshalable(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []interface{}:
+		ret := make([]interface{}, len(value))
+		for i, element := range value {
+			ret[i] = toMarshalable(element)
+		}
+		return ret
+	case *jsonObject:
+		ret := make(map[string]interface{}, len(value.keys))
+		for _, key := range value.keys {
+			ret[key] = toMarshalable(value.values[key])
+		}
+		return ret
+	case *big.Int:
+		return json.Number(value.String())
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Sprint(value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+// Copyright 2026 The Skycfg Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package yamlmodule
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// taggedValue is a decoded YAML value with a custom tag, such as "!Ref",
+// which is kept when decoding with keep_tags so that it can be encoded again.
+type taggedValue struct {
+	tag   string
+	value interface{}
+}
+
+func (v taggedValue) buildNode(style yaml.Style) (*yaml.Node, error) {
+	node, err := buildNode(v.value, style)
+	if err != nil {
+		return nil, err
+	}
+	node.Tag = v.tag
+	// The explicit tag resolves the type of the scalar, so it only needs
+	// to be quoted where its content requires it.
+	if node.Kind == yaml.ScalarNode {
+		node.Style &^= yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle
+	}
+	return node, nil
+}
+
+// hasCustomTag reports whether a node was written with an explicit tag other
+// than one of the standard "!!" tags.
+func hasCustomTag(node *yaml.Node) bool {
+	return node.Style&yaml.TaggedStyle != 0 && !strings.HasPrefix(node.ShortTag(), "!!")
+}
+
+// yamlTagged is a value decoded by yaml.decode with keep_tags, which retains
+// the custom tag of the original document so that yaml.encode can emit it.
+type yamlTagged struct {
+	tag   string
+	value starlark.Value
+}
+
+var _ starlark.Value = (*yamlTagged)(nil)
+var _ starlark.HasAttrs = (*yamlTagged)(nil)
+var _ starlark.Comparable = (*yamlTagged)(nil)
+
+func (t *yamlTagged) String() string        { return fmt.Sprintf("yaml.tagged(%q, %s)", t.tag, t.value) }
+func (t *yamlTagged) Type() string          { return "yaml.tagged" }
+func (t *yamlTagged) Freeze()               { t.value.Freeze() }
+func (t *yamlTagged) Truth() starlark.Bool  { return starlark.True }
+func (t *yamlTagged) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", t.Type()) }
+
+func (t *yamlTagged) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "tag":
+		return starlark.String(t.tag), nil
+	case "value":
+		return t.value, nil
+	}
+	return nil, nil
+}
+
+func (t *yamlTagged) AttrNames() []string {
+	return []string{"tag", "value"}
+}
+
+func (t *yamlTagged) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	other := y.(*yamlTagged)
+	switch op {
+	case syntax.EQL, syntax.NEQ:
+		eq := t.tag == other.tag
+		if eq {
+			var err error
+			if eq, err = starlark.EqualDepth(t.value, other.value, depth-1); err != nil {
+				return false, err
+			}
+		}
+		return eq == (op == syntax.EQL), nil
+	}
+	return false, fmt.Errorf("%s %s %s not implemented", t.Type(), op, other.Type())
+}
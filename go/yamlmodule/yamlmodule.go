@@ -19,20 +19,38 @@ package yamlmodule
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	starlarktime "go.starlark.net/lib/time"
 	"go.starlark.net/starlark"
-	"go.starlark.net/starlarkjson"
 	"go.starlark.net/starlarkstruct"
-	yaml "gopkg.in/yaml.v2"
+	"go.starlark.net/syntax"
+	yaml "gopkg.in/yaml.v3"
 )
 
 // NewModule returns a Starlark module of YAML-related functions.
 //
 //  yaml = module(
+//    comment,
 //    decode,
+//    decode_file,
+//    decode_node,
+//    encodable,
 //    encode,
+//    encode_node,
+//    validate,
 //  )
 //
 // See `docs/modules.asciidoc` for details on the API of each function.
@@ -40,45 +58,625 @@ func NewModule() *starlarkstruct.Module {
 	return &starlarkstruct.Module{
 		Name: "yaml",
 		Members: starlark.StringDict{
-			"decode":    starlark.NewBuiltin("yaml.decode", yamlDecode),
-			"encode":    starlark.NewBuiltin("yaml.encode", yamlEncode),
+			"comment":     starlark.NewBuiltin("yaml.comment", yamlComment),
+			"decode":      starlark.NewBuiltin("yaml.decode", yamlDecode),
+			"decode_file": starlark.NewBuiltin("yaml.decode_file", yamlDecodeFile),
+			"decode_node": starlark.NewBuiltin("yaml.decode_node", yamlDecodeNode),
+			"encodable":   starlark.NewBuiltin("yaml.encodable", yamlEncodable),
+			"encode":      starlark.NewBuiltin("yaml.encode", yamlEncode),
+			"encode_node": starlark.NewBuiltin("yaml.encode_node", yamlEncodeNode),
+			"validate":    starlark.NewBuiltin("yaml.validate", yamlValidate),
 		},
 	}
 }
 
 func yamlDecode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var blob string
-	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &blob); err != nil {
+	var multi bool
+	var opts decodeOptions
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"blob", &blob,
+		"multi?", &multi,
+		"strict?", &opts.strict,
+		"coerce_keys?", &opts.coerceKeys,
+		"keep_tags?", &opts.keepTags,
+		"ordered?", &opts.ordered,
+		"prefer_int?", &opts.preferInt,
+	); err != nil {
 		return nil, err
 	}
-	var inflated interface{}
-	if err := yaml.Unmarshal([]byte(blob), &inflated); err != nil {
-		return nil, err
+	if multi {
+		docs, err := decodeAll(strings.NewReader(blob), opts)
+		if err != nil {
+			return nil, annotateError(err, blob)
+		}
+		return docs, nil
 	}
-	return toStarlarkValue(inflated)
+	// An explicit null decodes to None, but input with no document at all
+	// is more likely a mistake.
+	if strings.TrimSpace(blob) == "" {
+		return nil, fmt.Errorf("%s: empty YAML input", fn.Name())
+	}
+	return decodeValue([]byte(blob), opts)
 }
 
-var jsonEncode = starlarkjson.Module.Members["encode"]
+// Unmarshal decodes a YAML document into a Starlark value, in the same way
+// as the yaml.decode builtin with its default options. It may be called
+// without a running Starlark thread.
+func Unmarshal(data []byte) (starlark.Value, error) {
+	return decodeValue(data, decodeOptions{})
+}
+
+func decodeValue(data []byte, opts decodeOptions) (starlark.Value, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, annotateError(err, string(data))
+	}
+	inflated, err := newDecoder(opts).decode(&doc)
+	if err != nil {
+		return nil, annotateError(err, string(data))
+	}
+	return toStarlarkDocument(inflated, opts)
+}
+
+// toStarlarkDocument converts a decoded document to a Starlark value.
+func toStarlarkDocument(inflated interface{}, opts decodeOptions) (starlark.Value, error) {
+	value, err := toStarlarkValue(inflated)
+	if err != nil || !opts.ordered {
+		return value, err
+	}
+	return orderDicts(value), nil
+}
+
+// decodeAll decodes each document of a multi-document YAML stream, skipping
+// any documents that are empty.
+func decodeAll(r io.Reader, opts decodeOptions) (starlark.Value, error) {
+	decoder := yaml.NewDecoder(r)
+	var docs []starlark.Value
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if isEmptyDocument(&doc) {
+			continue
+		}
+		inflated, err := newDecoder(opts).decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		value, err := toStarlarkDocument(inflated, opts)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, value)
+	}
+	return starlark.NewList(docs), nil
+}
 
 func yamlEncode(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var v starlark.Value
-	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &v); err != nil {
+	var multi, explicitStart bool
+	style := "block"
+	floatFormat := "default"
+	opts := defaultEncodeOptions
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"value", &v,
+		"multi?", &multi,
+		"indent?", &opts.indent,
+		"style?", &style,
+		"sort_keys?", &opts.sortKeys,
+		"float_format?", &floatFormat,
+		"use_anchors?", &opts.useAnchors,
+		"min_anchor_size?", &opts.minAnchorSize,
+		"explicit_start?", &explicitStart,
+		"quote_strings?", &opts.quoteStrings,
+		"omit_empty?", &opts.omitEmpty,
+	); err != nil {
 		return nil, err
 	}
+	if opts.indent < 2 || opts.indent > 9 {
+		return nil, fmt.Errorf("%s: for parameter indent: got %d, want a value between 2 and 9", fn.Name(), opts.indent)
+	}
+	if opts.minAnchorSize < 1 {
+		return nil, fmt.Errorf("%s: for parameter min_anchor_size: got %d, want a positive value", fn.Name(), opts.minAnchorSize)
+	}
+	switch style {
+	case "block":
+	case "flow":
+		opts.style = yaml.FlowStyle
+	default:
+		return nil, fmt.Errorf("%s: for parameter style: got %q, want \"block\" or \"flow\"", fn.Name(), style)
+	}
+	switch floatFormat {
+	case "default":
+	case "plain":
+		opts.plainFloats = true
+	default:
+		return nil, fmt.Errorf("%s: for parameter float_format: got %q, want \"default\" or \"plain\"", fn.Name(), floatFormat)
+	}
+	if !multi {
+		yamlBytes, err := encodeValue(v, opts)
+		if err != nil {
+			return nil, err
+		}
+		if explicitStart {
+			return starlark.String("---\n" + string(yamlBytes)), nil
+		}
+		return starlark.String(yamlBytes), nil
+	}
 
+	var docs starlark.Indexable
+	switch v := v.(type) {
+	case *starlark.List, starlark.Tuple:
+		docs = v.(starlark.Indexable)
+	default:
+		return nil, fmt.Errorf("%s: for parameter value: got %s, want list when multi = True", fn.Name(), v.Type())
+	}
 	var buf bytes.Buffer
-	if err := writeJSON(&buf, v); err != nil {
+	if err := encodeDocuments(&buf, docs, opts, explicitStart); err != nil {
+		return nil, err
+	}
+	return starlark.String(buf.String()), nil
+}
+
+// EncodeStream writes a list or tuple of Starlark values to w as a stream of
+// YAML documents separated by "---", in the same format as the yaml.encode
+// builtin with multi = True and its default options.
+//
+// Each document is written to w as soon as it has been encoded, so that a
+// large stream doesn't have to be held in memory.
+func EncodeStream(w io.Writer, v starlark.Value) error {
+	switch v.(type) {
+	case *starlark.List, starlark.Tuple:
+	default:
+		return fmt.Errorf("yamlmodule.EncodeStream: got %s, want list", v.Type())
+	}
+	return encodeDocuments(w, v.(starlark.Indexable), defaultEncodeOptions, false)
+}
+
+// encodeDocuments writes each element of docs to w as a YAML document.
+func encodeDocuments(w io.Writer, docs starlark.Indexable, opts encodeOptions, explicitStart bool) error {
+	for i := 0; i < docs.Len(); i++ {
+		yamlBytes, err := encodeValue(docs.Index(i), opts)
+		if err != nil {
+			return err
+		}
+		if i > 0 || explicitStart {
+			yamlBytes = append([]byte("---\n"), yamlBytes...)
+		}
+		if _, err := w.Write(yamlBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func yamlEncodable(t *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	var fail bool
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"value", &v,
+		"fail?", &fail,
+	); err != nil {
+		return nil, err
+	}
+	// The same conversions as encodeNode, without encoding the result.
+	inflated, err := fromStarlarkValue(v)
+	if err == nil {
+		_, err = toEncodable(inflated, defaultEncodeOptions)
+	}
+	if err == nil {
+		return starlark.True, nil
+	}
+	if !fail {
+		return starlark.False, nil
+	}
+	if convErr, ok := err.(*conversionError); ok {
+		return nil, fmt.Errorf("%s: value at %s: %v", fn.Name(), convErr.path, convErr.err)
+	}
+	return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+}
+
+// encodeOptions control the formatting of encoded YAML documents.
+type encodeOptions struct {
+	// Number of spaces used for each level of indentation.
+	indent int
+
+	// Style of every sequence and mapping, or zero for block style.
+	style yaml.Style
+
+	// Sort the keys of every mapping, rather than preserving the iteration
+	// order of the encoded dictionary.
+	sortKeys bool
+
+	// Format floats in decimal notation, rather than using an exponent for
+	// very small or large magnitudes.
+	plainFloats bool
+
+	// Write every string scalar in double-quoted style, rather than only
+	// strings that would otherwise be decoded as another type.
+	quoteStrings bool
+
+	// Drop mapping entries whose value is None, an empty string, or an
+	// empty sequence or mapping, including mappings emptied by dropping
+	// their own entries.
+	omitEmpty bool
+
+	// Replace repeated sequences and mappings with aliases of an anchor,
+	// if they contain at least minAnchorSize scalar values.
+	useAnchors    bool
+	minAnchorSize int
+}
+
+// defaultEncodeOptions are the options used by Marshal, and by yaml.encode
+// when called without any options.
+var defaultEncodeOptions = encodeOptions{
+	indent:        2,
+	sortKeys:      true,
+	minAnchorSize: 2,
+}
+
+// Marshal returns the YAML encoding of a Starlark value, in the same format
+// as the yaml.encode builtin with its default options. Values are converted
+// directly to YAML, without an intermediate encoding.
+func Marshal(v starlark.Value) ([]byte, error) {
+	return encodeValue(v, defaultEncodeOptions)
+}
+
+// encodeValue marshals a single Starlark value to a YAML document.
+func encodeValue(v starlark.Value, opts encodeOptions) ([]byte, error) {
+	node, err := encodeNode(v, opts)
+	if err != nil {
 		return nil, err
 	}
-	var jsonObj interface{}
-	if err := yaml.Unmarshal(buf.Bytes(), &jsonObj); err != nil {
+	return marshalNode(node, opts.indent)
+}
+
+// encodeNode converts a Starlark value to a YAML node.
+func encodeNode(v starlark.Value, opts encodeOptions) (*yaml.Node, error) {
+	inflated, err := fromStarlarkValue(v)
+	if err != nil {
 		return nil, err
 	}
-	yamlBytes, err := yaml.Marshal(jsonObj)
+	obj, err := toEncodable(inflated, opts)
 	if err != nil {
 		return nil, err
 	}
-	return starlark.String(yamlBytes), nil
+	node, err := buildNode(obj, opts.style)
+	if err != nil {
+		return nil, err
+	}
+	if opts.quoteStrings {
+		quoteStrings(node)
+	}
+	if opts.useAnchors {
+		addAnchors(node, opts.minAnchorSize)
+	}
+	return node, nil
+}
+
+// nodeBuilder is implemented by encodable values that build their own YAML
+// node, such as a mapping or a commented value.
+type nodeBuilder interface {
+	buildNode(style yaml.Style) (*yaml.Node, error)
+}
+
+// buildNode returns the YAML node for a value returned by toEncodable, with
+// every sequence and mapping in the given style. The node is built in a
+// single pass, in the form the yaml package would decode from its own
+// encoding of the value.
+func buildNode(obj interface{}, style yaml.Style) (*yaml.Node, error) {
+	switch obj := obj.(type) {
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case nodeBuilder:
+		return obj.buildNode(style)
+	case yaml.Marshaler:
+		v, err := obj.MarshalYAML()
+		if err != nil {
+			return nil, err
+		}
+		return v.(*yaml.Node), nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: style, Tag: "!!seq"}
+		for _, element := range obj {
+			child, err := buildNode(element, style)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case string:
+		if !utf8.ValidString(obj) {
+			return buildNode(binaryValue(obj), style)
+		}
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: obj}
+		switch {
+		case strings.Contains(obj, "\n"):
+			node.Style = yaml.LiteralStyle
+		case needsQuotes(obj):
+			node.Style = yaml.DoubleQuotedStyle
+		}
+		return node, nil
+	case time.Time:
+		return resolvedScalar(obj.Format(time.RFC3339Nano)), nil
+	}
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Bool:
+		return resolvedScalar(strconv.FormatBool(v.Bool())), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return resolvedScalar(strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return resolvedScalar(strconv.FormatUint(v.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		return resolvedScalar(formatFloat(v.Float())), nil
+	}
+	// Any other scalar, such as a *big.Int mapping key, is left to the yaml
+	// package.
+	var node yaml.Node
+	if err := node.Encode(obj); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// resolvedScalar returns a plain scalar node, tagged with the type it
+// resolves to.
+func resolvedScalar(value string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	node.Tag = node.ShortTag()
+	return node
+}
+
+// formatFloat formats a float in the same way as the yaml package.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return ".nan"
+	case math.IsInf(f, 1):
+		return ".inf"
+	case math.IsInf(f, -1):
+		return "-.inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// base60Pattern matches the sexagesimal numbers of YAML 1.1, such as
+// "1:20", which the yaml package quotes when encoding a string.
+var base60Pattern = regexp.MustCompile(`^[-+]?[0-9][0-9_]*(?::[0-5]?[0-9])+(?:\.[0-9_]*)?$`)
+
+// needsQuotes reports whether a single-line string must be quoted so that
+// it isn't decoded as another type, either by YAML 1.2 or as a YAML 1.1
+// bool or sexagesimal number, as the yaml package does when encoding it.
+func needsQuotes(s string) bool {
+	switch s {
+	case "y", "Y", "yes", "Yes", "YES", "on", "On", "ON",
+		"n", "N", "no", "No", "NO", "off", "Off", "OFF":
+		return true
+	}
+	plain := yaml.Node{Kind: yaml.ScalarNode, Value: s}
+	return plain.ShortTag() != "!!str" || base60Pattern.MatchString(s)
+}
+
+func marshalNode(node *yaml.Node, indent int) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// binaryValue is binary data, which is encoded as a base64 "!!binary" scalar.
+type binaryValue []byte
+
+func (b binaryValue) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   binaryTag,
+		Value: base64.StdEncoding.EncodeToString(b),
+	}, nil
+}
+
+// bigIntValue is an integer too large for int64 or uint64.
+type bigIntValue struct{ *big.Int }
+
+func (i bigIntValue) MarshalYAML() (interface{}, error) {
+	// Left untagged, since the yaml package would otherwise add an explicit
+	// "!!int" tag to a value it would resolve as a float.
+	return &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Value: i.String(),
+	}, nil
+}
+
+// plainFloat is a float that is encoded in decimal notation.
+type plainFloat float64
+
+func (f plainFloat) MarshalYAML() (interface{}, error) {
+	var value string
+	switch v := float64(f); {
+	case math.IsNaN(v):
+		value = ".nan"
+	case math.IsInf(v, 1):
+		value = ".inf"
+	case math.IsInf(v, -1):
+		value = "-.inf"
+	default:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+		// Keep a fractional part so the value isn't decoded as an int.
+		if !strings.Contains(value, ".") {
+			value += ".0"
+		}
+	}
+	return &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   "!!float",
+		Value: value,
+	}, nil
+}
+
+// orderedMap is a mapping that is encoded with its keys in order.
+type orderedMap MapSlice
+
+func (m orderedMap) buildNode(style yaml.Style) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Style: style, Tag: "!!map"}
+	for _, item := range m {
+		key, err := buildNode(item.Key, style)
+		if err != nil {
+			return nil, err
+		}
+		c, commented := item.Value.(commentedValue)
+		if !commented {
+			value, err := buildNode(item.Value, style)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, key, value)
+			continue
+		}
+		// The comments of a mapping value are only written in the right
+		// place if they are attached to its key, except for the line
+		// comment of a value written on the same line as its key.
+		value, err := buildNode(c.value, style)
+		if err != nil {
+			return nil, err
+		}
+		key.HeadComment = c.head
+		if c.line != "" {
+			entryLine(key, value).LineComment = c.line
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return node, nil
+}
+
+// toEncodable converts a decoded YAML document into values that the yaml
+// package will encode with the same types. If opts.sortKeys is false,
+// mappings are encoded with their keys in the original order; otherwise they
+// are sorted in the same order as the yaml package sorts a Go map.
+func toEncodable(obj interface{}, opts encodeOptions) (interface{}, error) {
+	switch obj := obj.(type) {
+	case float64:
+		if opts.plainFloats {
+			return plainFloat(obj), nil
+		}
+	case []byte:
+		return binaryValue(obj), nil
+	case *big.Int:
+		return bigIntValue{obj}, nil
+	case taggedValue:
+		v, err := toEncodable(obj.value, opts)
+		if err != nil {
+			return nil, err
+		}
+		return taggedValue{obj.tag, v}, nil
+	case commentedValue:
+		v, err := toEncodable(obj.value, opts)
+		if err != nil {
+			return nil, err
+		}
+		return commentedValue{obj.head, obj.line, v}, nil
+	case []interface{}:
+		for i, element := range obj {
+			v, err := toEncodable(element, opts)
+			if err != nil {
+				return nil, err
+			}
+			obj[i] = v
+		}
+		return obj, nil
+	case MapSlice:
+		ordered := make(orderedMap, 0, len(obj))
+		for _, item := range obj {
+			if !isComparable(item.Key) {
+				return nil, fmt.Errorf("%s (%v) is not a supported key type", reflect.TypeOf(item.Key).Kind(), item.Key)
+			}
+			v, err := toEncodable(item.Value, opts)
+			if err != nil {
+				return nil, err
+			}
+			if opts.omitEmpty && isEmpty(v) {
+				continue
+			}
+			ordered = append(ordered, MapItem{item.Key, v})
+		}
+		if !opts.sortKeys {
+			return ordered, nil
+		}
+		return sortItems(ordered)
+	}
+	return obj, nil
+}
+
+// isEmpty reports whether an encodable value is dropped from a mapping by
+// the omit_empty option of yaml.encode. Zero numbers and false are kept.
+func isEmpty(obj interface{}) bool {
+	switch obj := obj.(type) {
+	case nil:
+		return true
+	case string:
+		return obj == ""
+	case []interface{}:
+		return len(obj) == 0
+	case orderedMap:
+		return len(obj) == 0
+	}
+	return false
+}
+
+// ToStarlark converts a tree of Go values into Starlark values, in the same
+// way as yaml.decode. Mappings may be a MapSlice, which keeps its order, or
+// a Go map with scalar keys.
+//
+// It is intended for modules that decode other formats into Go values.
+func ToStarlark(obj interface{}) (starlark.Value, error) {
+	return toStarlarkValue(obj)
+}
+
+// FromStarlark converts a Starlark value into a tree of Go values, in the
+// same way as yaml.encode. Dicts and structs are returned as a MapSlice, in
+// iteration order. Protobuf messages are converted using their JSON mapping.
+//
+// It is intended for modules that encode Starlark values to other formats,
+// and format is the name of that format used in errors, such as "JSON".
+func FromStarlark(v starlark.Value, format string) (interface{}, error) {
+	return fromStarlark(v, format)
+}
+
+// sortedSetElements returns the elements of a set in sorted order, so that
+// sets are encoded deterministically. Elements that can't be compared with
+// each other, such as a mix of strings and ints, are kept in insertion order.
+func sortedSetElements(set *starlark.Set) []starlark.Value {
+	var elements []starlark.Value
+	iter := set.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for iter.Next(&x) {
+		elements = append(elements, x)
+	}
+	sorted := append([]starlark.Value(nil), elements...)
+	var sortErr error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		less, err := starlark.Compare(syntax.LT, sorted[i], sorted[j])
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		return less
+	})
+	if sortErr != nil {
+		return elements
+	}
+	return sorted
 }
 
 // toStarlarkScalarValue converts a scalar [obj] value to its starlark Value
@@ -86,6 +684,14 @@ func toStarlarkScalarValue(obj interface{}) (starlark.Value, bool) {
 	if obj == nil {
 		return starlark.None, true
 	}
+	switch obj := obj.(type) {
+	case time.Time:
+		return starlarktime.Time(obj), true
+	case []byte:
+		return starlark.Bytes(obj), true
+	case *big.Int:
+		return starlark.MakeBigInt(obj), true
+	}
 	rt := reflect.TypeOf(obj)
 	v := reflect.ValueOf(obj)
 	switch rt.Kind() {
@@ -109,16 +715,41 @@ func toStarlarkValue(obj interface{}) (starlark.Value, error) {
 	if objval, ok := toStarlarkScalarValue(obj); ok {
 		return objval, nil
 	}
+	if tagged, ok := obj.(taggedValue); ok {
+		value, err := toStarlarkValue(tagged.value)
+		if err != nil {
+			return nil, err
+		}
+		return &yamlTagged{tag: tagged.tag, value: value}, nil
+	}
+	if items, ok := obj.(MapSlice); ok {
+		ret := starlark.NewDict(len(items))
+		for _, item := range items {
+			keyval, ok := toStarlarkScalarValue(item.Key)
+			if !ok {
+				return nil, fmt.Errorf("%s (%v) is not a supported key type", reflect.TypeOf(item.Key).Kind(), item.Key)
+			}
+			starval, err := toStarlarkValue(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			if err = ret.SetKey(keyval, starval); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
 	rt := reflect.TypeOf(obj)
 	switch rt.Kind() {
 	case reflect.Map:
 		ret := &starlark.Dict{}
-		for k, v := range obj.(map[interface{}]interface{}) {
-			keyval, ok := toStarlarkScalarValue(k)
+		iter := reflect.ValueOf(obj).MapRange()
+		for iter.Next() {
+			keyval, ok := toStarlarkScalarValue(iter.Key().Interface())
 			if !ok {
 				return nil, fmt.Errorf("%s (%v) is not a supported key type", rt.Kind(), obj)
 			}
-			starval, err := toStarlarkValue(v)
+			starval, err := toStarlarkValue(iter.Value().Interface())
 			if err != nil {
 				return nil, err
 			}
@@ -142,3 +773,178 @@ func toStarlarkValue(obj interface{}) (starlark.Value, error) {
 		return nil, fmt.Errorf("%s (%v) is not a supported type", rt.Kind(), obj)
 	}
 }
+
+// fromStarlarkValue is the inverse of toStarlarkValue, translating a Starlark
+// value into the same Go types returned when decoding YAML.
+func fromStarlarkValue(v starlark.Value) (interface{}, error) {
+	return fromStarlark(v, "YAML")
+}
+
+// fromStarlark implements fromStarlarkValue, naming format in the error for
+// values that can't be converted.
+func fromStarlark(v starlark.Value, format string) (interface{}, error) {
+	return converter{format, make(map[starlark.Value]bool)}.convert(v)
+}
+
+// A converter converts Starlark values for fromStarlark. It tracks the lists,
+// dicts, and sets being converted, so that a value containing itself is an
+// error rather than endless recursion.
+type converter struct {
+	format string
+	active map[starlark.Value]bool
+}
+
+func (c converter) convert(v starlark.Value) (interface{}, error) {
+	switch v.(type) {
+	case *starlark.List, *starlark.Dict, *starlark.Set:
+		if c.active[v] {
+			return nil, fmt.Errorf("ValueError: value %s (type `%s') contains itself and can't be converted to %s.", v.String(), v.Type(), c.format)
+		}
+		c.active[v] = true
+		defer delete(c.active, v)
+	}
+
+	// Protobuf messages are encoded using the same JSON mapping and field
+	// names as proto.encode_json.
+	if marshaler, ok := v.(json.Marshaler); ok {
+		jsonData, err := marshaler.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(jsonData, &doc); err != nil {
+			return nil, err
+		}
+		return newDecoder(decodeOptions{}).decode(&doc)
+	}
+
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		if i, ok := v.Uint64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return []byte(v), nil
+	case starlarktime.Time:
+		return time.Time(v), nil
+	case *yamlTagged:
+		value, err := c.convert(v.value)
+		if err != nil {
+			return nil, err
+		}
+		// Other formats have no tags, so only the value is converted.
+		if c.format != "YAML" {
+			return value, nil
+		}
+		return taggedValue{v.tag, value}, nil
+	case *starlark.List, starlark.Tuple:
+		seq := v.(starlark.Indexable)
+		slice := make([]interface{}, seq.Len())
+		for i := range slice {
+			element, err := c.convert(seq.Index(i))
+			if err != nil {
+				return nil, atPath(err, fmt.Sprintf("[%d]", i))
+			}
+			slice[i] = element
+		}
+		return slice, nil
+	case *yamlOrderedDict:
+		return c.convert(v.dict)
+	case *yamlCommented:
+		value, err := c.convert(v.value)
+		if err != nil {
+			return nil, err
+		}
+		// Other formats have no comments, so only the value is converted.
+		if c.format != "YAML" {
+			return value, nil
+		}
+		return commentedValue{dropBlankLines(v.head), dropBlankLines(v.line), value}, nil
+	case *starlark.Set:
+		elements := sortedSetElements(v)
+		slice := make([]interface{}, len(elements))
+		for i, element := range elements {
+			value, err := c.convert(element)
+			if err != nil {
+				return nil, atPath(err, fmt.Sprintf("[%d]", i))
+			}
+			slice[i] = value
+		}
+		return slice, nil
+	case *starlark.Dict:
+		items := make(MapSlice, 0, v.Len())
+		for _, item := range v.Items() {
+			key, err := c.convert(item[0])
+			if err != nil {
+				return nil, atPath(err, keyPath(item[0]))
+			}
+			value, err := c.convert(item[1])
+			if err != nil {
+				return nil, atPath(err, keyPath(item[0]))
+			}
+			items = append(items, MapItem{key, value})
+		}
+		return items, nil
+	case *starlarkstruct.Struct:
+		names := v.AttrNames()
+		items := make(MapSlice, 0, len(names))
+		for _, name := range names {
+			attr, err := v.Attr(name)
+			if err != nil {
+				return nil, err
+			}
+			value, err := c.convert(attr)
+			if err != nil {
+				return nil, atPath(err, "."+name)
+			}
+			items = append(items, MapItem{name, value})
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("TypeError: value %s (type `%s') can't be converted to %s.", v.String(), v.Type(), c.format)
+}
+
+// conversionError is an error converting a value nested within a Starlark
+// value, such as an element of a list, along with the path to that value.
+// Its message is that of the underlying error.
+type conversionError struct {
+	path string // for example `.spec.items[2]`
+	err  error
+}
+
+func (e *conversionError) Error() string { return e.err.Error() }
+func (e *conversionError) Unwrap() error { return e.err }
+
+// atPath prepends an element to the path of a conversionError, or wraps err
+// in a new conversionError.
+func atPath(err error, elem string) error {
+	if convErr, ok := err.(*conversionError); ok {
+		convErr.path = elem + convErr.path
+		return convErr
+	}
+	return &conversionError{path: elem, err: err}
+}
+
+// identPattern matches dict keys that are written as ".key" in a path,
+// rather than as a quoted string between brackets.
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// keyPath returns the path element for a dict key.
+func keyPath(key starlark.Value) string {
+	if s, ok := key.(starlark.String); ok && identPattern.MatchString(string(s)) {
+		return "." + string(s)
+	}
+	return "[" + key.String() + "]"
+}
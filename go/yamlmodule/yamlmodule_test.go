@@ -17,23 +17,65 @@
 package yamlmodule
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path"
+	"strings"
 	"testing"
 
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
 )
 
 type YamlTestCase struct {
 	skyExpr   string
 	expOutput string
+	wantErr   string
 }
 
-func TestSkyToYaml(t *testing.T) {
-	thread := new(starlark.Thread)
-	env := starlark.StringDict{
-		"yaml": NewModule(),
+// testGlobals returns the yaml module, struct, and set, along with any extra
+// values used by a test.
+func testGlobals(extra starlark.StringDict) starlark.StringDict {
+	globals := starlark.StringDict{
+		"yaml":   NewModule(),
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"set":    starlark.Universe["set"],
+	}
+	for name, value := range extra {
+		globals[name] = value
+	}
+	return globals
+}
+
+// evalExpr evaluates expr with globals. If wantErr is set, it checks that
+// evaluation fails with that error. It returns false unless evaluation
+// succeeded as expected and the value should be checked.
+func evalExpr(t *testing.T, globals starlark.StringDict, expr, wantErr string) (starlark.Value, bool) {
+	t.Helper()
+	v, err := starlark.Eval(new(starlark.Thread), "<expr>", expr, globals)
+	if wantErr != "" {
+		if err == nil || err.Error() != wantErr {
+			t.Error("Bad error from", expr, "\nExpected", wantErr, "\nGot", err)
+		}
+		return nil, false
+	}
+	if err != nil {
+		t.Error("Error from eval", "\nExpected nil", "\nGot", err)
+		return nil, false
 	}
+	return v, true
+}
+
+func TestSkyToYaml(t *testing.T) {
+	// 200 characters, with spaces where a line could be folded.
+	long := strings.Repeat("some text ", 20)
+	env := testGlobals(starlark.StringDict{
+		"long": starlark.String(long),
+	})
 
+	const labels = `{"app": "web", "tier": "frontend"}`
+	const sortable = `{"b": 1, "a": {"z": 1, "y": [{"d": 1, "c": 2}]}, 10: "x", 9: "y"}`
 	testCases := []YamlTestCase{
 		YamlTestCase{
 			skyExpr: "123",
@@ -46,6 +88,20 @@ func TestSkyToYaml(t *testing.T) {
 a: 5
 k:
   k2: v
+`,
+		},
+		YamlTestCase{
+			skyExpr: `struct(a=1, b=[struct(c=2)])`,
+			expOutput: `a: 1
+b:
+  - c: 2
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{"s": struct(z=struct(), y=None)}`,
+			expOutput: `s:
+  "y": null
+  z: {}
 `,
 		},
 		YamlTestCase{
@@ -61,17 +117,372 @@ k:
 - k: v
 `,
 		},
+		YamlTestCase{
+			skyExpr: `{"a": [len]}`,
+			wantErr: "TypeError: value <built-in function len> (type `builtin_function_or_method') can't be converted to YAML.",
+		},
+
+		// Numbers
+		YamlTestCase{skyExpr: `(1 << 53) + 1`, expOutput: "9007199254740993\n"},
+		YamlTestCase{skyExpr: `-((1 << 53) + 1)`, expOutput: "-9007199254740993\n"},
+		YamlTestCase{skyExpr: `-((1 << 63))`, expOutput: "-9223372036854775808\n"},
+		YamlTestCase{skyExpr: `(1 << 64) - 1`, expOutput: "18446744073709551615\n"},
+		YamlTestCase{skyExpr: `(1 << 100) + 1`, expOutput: "1267650600228229401496703205377\n"},
+		YamlTestCase{skyExpr: `-((1 << 100)) - 1`, expOutput: "-1267650600228229401496703205377\n"},
+		YamlTestCase{skyExpr: `{"big": [(1 << 64)]}`, expOutput: "big:\n  - 18446744073709551616\n"},
+		YamlTestCase{skyExpr: `3.141592653589793`, expOutput: "3.141592653589793\n"},
+		YamlTestCase{skyExpr: `0.5`, expOutput: "0.5\n"},
+
+		// float_format
+		YamlTestCase{skyExpr: `0.0001`, expOutput: "0.0001\n"},
+		YamlTestCase{skyExpr: `0.00001`, expOutput: "1e-05\n"},
+		YamlTestCase{skyExpr: `0.00001, float_format = "plain"`, expOutput: "0.00001\n"},
+		YamlTestCase{skyExpr: `1.5e-10, float_format = "plain"`, expOutput: "0.00000000015\n"},
+		YamlTestCase{skyExpr: `1e21`, expOutput: "1e+21\n"},
+		YamlTestCase{skyExpr: `1e21, float_format = "plain"`, expOutput: "1000000000000000000000.0\n"},
+		YamlTestCase{skyExpr: `-2.5e22, float_format = "plain"`, expOutput: "-25000000000000000000000.0\n"},
+		YamlTestCase{skyExpr: `[1.0, 0.5], float_format = "plain"`, expOutput: "- 1.0\n- 0.5\n"},
+		YamlTestCase{skyExpr: `float("nan")`, expOutput: ".nan\n"},
+		YamlTestCase{skyExpr: `[float("inf"), float("-inf")]`, expOutput: "- .inf\n- -.inf\n"},
+		YamlTestCase{skyExpr: `float("nan"), float_format = "plain"`, expOutput: ".nan\n"},
+		YamlTestCase{skyExpr: `{"a": float("inf"), "b": float("-inf")}, float_format = "plain"`, expOutput: "a: .inf\nb: -.inf\n"},
+		YamlTestCase{
+			skyExpr: `1.0, float_format = "fixed"`,
+			wantErr: `yaml.encode: for parameter float_format: got "fixed", want "default" or "plain"`,
+		},
+
+		// use_anchors
+		YamlTestCase{
+			skyExpr:   `[{"name": "a", "labels": ` + labels + `}, {"name": "b", "labels": ` + labels + `}], use_anchors = True`,
+			expOutput: "- labels: &id001\n    app: web\n    tier: frontend\n  name: a\n- labels: *id001\n  name: b\n",
+		},
+		YamlTestCase{
+			// The repeated list is anchored as a whole, not its elements.
+			skyExpr:   `{"a": [` + labels + `, [1, 2]], "b": [` + labels + `, [1, 2]], "c": [1, 2]}, use_anchors = True`,
+			expOutput: "a: &id001\n  - app: web\n    tier: frontend\n  - &id002\n    - 1\n    - 2\nb: *id001\nc: *id002\n",
+		},
+		YamlTestCase{
+			skyExpr:   `[{"a": 1}, {"a": 1}, [1], [1]], use_anchors = True`,
+			expOutput: "- a: 1\n- a: 1\n- - 1\n- - 1\n",
+		},
+		YamlTestCase{
+			skyExpr:   `[{"a": 1}, {"a": 1}], use_anchors = True, min_anchor_size = 1`,
+			expOutput: "- &id001\n  a: 1\n- *id001\n",
+		},
+		YamlTestCase{
+			// Values of different types are distinct.
+			skyExpr:   `[[1, 2], ["1", "2"]], use_anchors = True`,
+			expOutput: "- - 1\n  - 2\n- - \"1\"\n  - \"2\"\n",
+		},
+		YamlTestCase{
+			skyExpr:   `[` + labels + `, ` + labels + `]`,
+			expOutput: "- app: web\n  tier: frontend\n- app: web\n  tier: frontend\n",
+		},
+		YamlTestCase{
+			skyExpr: `[], use_anchors = True, min_anchor_size = 0`,
+			wantErr: "yaml.encode: for parameter min_anchor_size: got 0, want a positive value",
+		},
+
+		// multi
+		YamlTestCase{
+			skyExpr: `[{"a": 1}, ["b"], "c"], multi=True`,
+			expOutput: `a: 1
+---
+- b
+---
+c
+`,
+		},
+		YamlTestCase{
+			skyExpr:   `[], multi=True`,
+			expOutput: ``,
+		},
+		YamlTestCase{
+			skyExpr: `{"a": 1}, multi=True`,
+			wantErr: "yaml.encode: for parameter value: got dict, want list when multi = True",
+		},
+
+		// Sets
+		YamlTestCase{
+			skyExpr:   `set([3, 1, 2])`,
+			expOutput: "- 1\n- 2\n- 3\n",
+		},
+		YamlTestCase{
+			skyExpr:   `{"names": set(["b", "c", "a"])}, style="flow"`,
+			expOutput: "{names: [a, b, c]}\n",
+		},
+		YamlTestCase{
+			skyExpr:   `set([2, "a", 1])`,
+			expOutput: "- 2\n- a\n- 1\n",
+		},
+		YamlTestCase{
+			skyExpr:   `set()`,
+			expOutput: "[]\n",
+		},
+
+		// Comments
+		YamlTestCase{
+			skyExpr: `yaml.comment({"b": 1, "a": 2}, head = "generated from foo.sky")`,
+			expOutput: `# generated from foo.sky
+a: 2
+b: 1
+`,
+		},
+		YamlTestCase{
+			skyExpr: `yaml.comment({"a": yaml.comment(1, head = "nested")}, head = "first\n\nsecond")`,
+			expOutput: `# first
+# second
+# nested
+a: 1
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{"b": yaml.comment(1, head = "head of b", line = "line of b"), "a": yaml.comment({"c": [1]}, head = "head of a", line = "line of a")}`,
+			expOutput: `# head of a
+a: # line of a
+  c:
+    - 1
+# head of b
+b: 1 # line of b
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{"b": yaml.comment(1, line = "unsorted"), "a": 2}, sort_keys = False`,
+			expOutput: `b: 1 # unsorted
+a: 2
+`,
+		},
+		YamlTestCase{
+			skyExpr: `[yaml.comment("x", head = "first\nitem", line = "# already prefixed"), "y"]`,
+			expOutput: `# first
+# item
+- x # already prefixed
+- "y"
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{10: yaml.comment("x", line = "ten"), 9: "y", "a": "z"}`,
+			expOutput: `9: "y"
+10: x # ten
+a: z
+`,
+		},
+		YamlTestCase{
+			skyExpr: `[yaml.comment({"a": 1, "b": 2}, line = "first"), yaml.comment([[1], 2], line = "second")]`,
+			expOutput: `- a: 1 # first
+  b: 2
+- - - 1 # second
+  - 2
+`,
+		},
+		YamlTestCase{
+			skyExpr: `yaml.comment({"a": {}, "b": 2}, head = "h", line = "l")`,
+			expOutput: `# h
+a: {} # l
+b: 2
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{"a": yaml.comment([], line = "empty"), "b": yaml.comment(yaml.decode("!Ref [x]", keep_tags = True), line = "tagged")}`,
+			expOutput: `a: [] # empty
+b: !Ref
+  - x # tagged
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{"a": yaml.comment({"b": [1]}, head = "h", line = "l"), "c": yaml.comment([2], line = "m")}, style = "flow"`,
+			expOutput: `{
+  # h
+  a: {b: [1]} # l
+, c: [2] # m
+}
+`,
+		},
+
+		// explicit_start
+		YamlTestCase{
+			skyExpr: `{"a": 1}, explicit_start=True`,
+			expOutput: `---
+a: 1
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{"a": 1}, explicit_start=False`,
+			expOutput: `a: 1
+`,
+		},
+		YamlTestCase{
+			skyExpr: `[{"a": 1}, "b"], multi=True, explicit_start=True`,
+			expOutput: `---
+a: 1
+---
+b
+`,
+		},
+		YamlTestCase{
+			skyExpr:   `[], multi=True, explicit_start=True`,
+			expOutput: ``,
+		},
+
+		// indent
+		YamlTestCase{
+			skyExpr: `{"a": {"b": [1, {"c": 2}]}}, indent=2`,
+			expOutput: `a:
+  b:
+    - 1
+    - c: 2
+`,
+		},
+		YamlTestCase{
+			skyExpr: `{"a": {"b": [1, {"c": 2}]}}, indent=4`,
+			expOutput: `a:
+    b:
+        - 1
+        - c: 2
+`,
+		},
+		YamlTestCase{skyExpr: `{}, indent=0`, wantErr: "yaml.encode: for parameter indent: got 0, want a value between 2 and 9"},
+		YamlTestCase{skyExpr: `{}, indent=-1`, wantErr: "yaml.encode: for parameter indent: got -1, want a value between 2 and 9"},
+		YamlTestCase{skyExpr: `{}, indent=10`, wantErr: "yaml.encode: for parameter indent: got 10, want a value between 2 and 9"},
+		YamlTestCase{skyExpr: `{}, indent="4"`, wantErr: `yaml.encode: for parameter "indent": got string, want int`},
+
+		// Long strings aren't folded.
+		YamlTestCase{skyExpr: `long`, expOutput: "'" + long + "'\n"},
+		YamlTestCase{skyExpr: `{"a": {"b": long}}`, expOutput: "a:\n  b: '" + long + "'\n"},
+		YamlTestCase{skyExpr: `[long], style="flow"`, expOutput: "['" + long + "']\n"},
+
+		// style
+		YamlTestCase{
+			skyExpr: `{"a": 1, "b": [1, 2]}, style="block"`,
+			expOutput: `a: 1
+b:
+  - 1
+  - 2
+`,
+		},
+		YamlTestCase{
+			skyExpr:   `{"a": 1, "b": [1, 2]}, style="flow"`,
+			expOutput: "{a: 1, b: [1, 2]}\n",
+		},
+		YamlTestCase{
+			skyExpr:   `[{"k": "v"}, [], {}, "text"], style="flow"`,
+			expOutput: "[{k: v}, [], {}, text]\n",
+		},
+		YamlTestCase{
+			skyExpr:   `"scalar", style="flow"`,
+			expOutput: "scalar\n",
+		},
+		YamlTestCase{
+			skyExpr:   `[{"a": 1}, [2]], multi=True, style="flow"`,
+			expOutput: "{a: 1}\n---\n[2]\n",
+		},
+		YamlTestCase{
+			skyExpr: `{}, style="compact"`,
+			wantErr: `yaml.encode: for parameter style: got "compact", want "block" or "flow"`,
+		},
+
+		// quote_strings. Strings that would be decoded as another type are
+		// always quoted.
+		YamlTestCase{
+			skyExpr:   `["true", "01234", "1.0", "text"]`,
+			expOutput: "- \"true\"\n- \"01234\"\n- \"1.0\"\n- text\n",
+		},
+		YamlTestCase{
+			skyExpr:   `["true", "01234", "1.0", "text"], quote_strings=True`,
+			expOutput: "- \"true\"\n- \"01234\"\n- \"1.0\"\n- \"text\"\n",
+		},
+		YamlTestCase{
+			skyExpr:   `{"zip": "01234", "n": 1, "ok": True, "lines": "a\nb", "b": b"hi"}, quote_strings=True`,
+			expOutput: "\"b\": !!binary aGk=\n\"lines\": \"a\\nb\"\n\"n\": 1\n\"ok\": true\n\"zip\": \"01234\"\n",
+		},
+
+		// omit_empty
+		YamlTestCase{
+			skyExpr:   `{"a": None, "b": "", "c": [], "d": {}, "e": 0, "f": False, "g": 0.0}`,
+			expOutput: "a: null\nb: \"\"\nc: []\nd: {}\ne: 0\nf: false\ng: 0\n",
+		},
+		YamlTestCase{
+			skyExpr:   `{"a": None, "b": "", "c": [], "d": {}, "e": 0, "f": False, "g": 0.0}, omit_empty=True`,
+			expOutput: "e: 0\nf: false\ng: 0\n",
+		},
+		// Nested mappings are pruned, including mappings within lists, and
+		// mappings left empty are dropped.
+		YamlTestCase{
+			skyExpr:   `{"spec": {"replicas": 0, "labels": {}, "env": [{"name": "A", "value": None}]}, "status": {"conditions": None}}, omit_empty=True`,
+			expOutput: "spec:\n  env:\n    - name: A\n  replicas: 0\n",
+		},
+		// List elements are kept, even if empty.
+		YamlTestCase{
+			skyExpr:   `{"items": [None, "", {}]}, omit_empty=True`,
+			expOutput: "items:\n  - null\n  - \"\"\n  - {}\n",
+		},
+		YamlTestCase{
+			skyExpr:   `{"b": None, "a": 1}, omit_empty=True, sort_keys=False`,
+			expOutput: "a: 1\n",
+		},
+
+		// sort_keys
+		YamlTestCase{
+			skyExpr: sortable,
+			expOutput: `9: "y"
+10: x
+a:
+  "y":
+    - c: 2
+      d: 1
+  z: 1
+b: 1
+`,
+		},
+		YamlTestCase{
+			skyExpr: sortable + `, sort_keys=True`,
+			expOutput: `9: "y"
+10: x
+a:
+  "y":
+    - c: 2
+      d: 1
+  z: 1
+b: 1
+`,
+		},
+		YamlTestCase{
+			skyExpr: sortable + `, sort_keys=False`,
+			expOutput: `b: 1
+a:
+  z: 1
+  "y":
+    - d: 1
+      c: 2
+10: x
+9: "y"
+`,
+		},
+		YamlTestCase{
+			skyExpr:   sortable + `, sort_keys=False, style="flow"`,
+			expOutput: "{b: 1, a: {z: 1, \"y\": [{d: 1, c: 2}]}, 10: x, 9: \"y\"}\n",
+		},
+		YamlTestCase{
+			// Deeply nested values are encoded in linear time.
+			skyExpr:   strings.Repeat(`{"a": `, 1000) + "1" + strings.Repeat("}", 1000) + `, sort_keys=False`,
+			expOutput: deeplyNested(1000),
+		},
+
+		// Keys of other types are quoted so that they decode to the same type.
+		YamlTestCase{
+			skyExpr:   `{1: "a", "1": "b", "x": "c", 2: "d", -3: "e"}`,
+			expOutput: "-3: e\n1: a\n2: d\n\"1\": b\nx: c\n",
+		},
+		YamlTestCase{
+			skyExpr:   `{True: 1, None: 2, 1.5: 3}`,
+			expOutput: "true: 1\n1.5: 3\nnull: 2\n",
+		},
 	}
 
 	for _, testCase := range testCases {
-		v, err := starlark.Eval(
-			thread,
-			"<expr>",
-			fmt.Sprintf("yaml.encode(%s)", testCase.skyExpr),
-			env,
-		)
-		if err != nil {
-			t.Error("Error from eval", "\nExpected nil", "\nGot", err)
+		v, ok := evalExpr(t, env, fmt.Sprintf("yaml.encode(%s)", testCase.skyExpr), testCase.wantErr)
+		if !ok {
+			continue
 		}
 		exp := starlark.String(testCase.expOutput)
 		if v != exp {
@@ -86,6 +497,111 @@ k:
 	}
 }
 
+// deeplyNested returns the YAML encoding of depth nested mappings with the
+// key "a", ending in the value 1.
+func deeplyNested(depth int) string {
+	var buf strings.Builder
+	for i := 0; i < depth-1; i++ {
+		buf.WriteString(strings.Repeat("  ", i) + "a:\n")
+	}
+	buf.WriteString(strings.Repeat("  ", depth-1) + "a: 1\n")
+	return buf.String()
+}
+
+func TestMarshal(t *testing.T) {
+	dict := starlark.NewDict(2)
+	dict.SetKey(starlark.String("b"), starlark.NewList([]starlark.Value{starlark.MakeInt(1), starlark.Float(0.5)}))
+	dict.SetKey(starlark.String("a"), starlark.MakeUint64(1<<63+1))
+	got, err := Marshal(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a: 9223372036854775809\nb:\n  - 1\n  - 0.5\n"; string(got) != want {
+		t.Error("Bad return value from Marshal", "\nExpected", want, "\nGot", string(got))
+	}
+
+	_, err = Marshal(NewModule())
+	wantErr := "TypeError: value <module \"yaml\"> (type `module') can't be converted to YAML."
+	if err == nil || err.Error() != wantErr {
+		t.Error("Bad error from Marshal", "\nExpected", wantErr, "\nGot", err)
+	}
+
+	// Other formats have no comments.
+	commented, ok := evalExpr(t, testGlobals(nil), `{"a": yaml.comment([1], head = "h")}`, "")
+	if !ok {
+		return
+	}
+	inflated, err := FromStarlark(commented, "JSON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[{a [1]}]"; fmt.Sprint(inflated) != want {
+		t.Error("Bad return value from FromStarlark", "\nExpected", want, "\nGot", inflated)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	got, err := Unmarshal([]byte("b: [1, 0.5]\na: hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"b": [1, 0.5], "a": "hello"}`; got.String() != want {
+		t.Error("Bad return value from Unmarshal", "\nExpected", want, "\nGot", got)
+	}
+
+	_, err = Unmarshal([]byte("a: 1\n  b: 2\n"))
+	wantErr := "yaml: line 2: mapping values are not allowed in this context\n    b: 2"
+	if err == nil || err.Error() != wantErr {
+		t.Error("Bad error from Unmarshal", "\nExpected", wantErr, "\nGot", err)
+	}
+}
+
+// writeRecorder records each call to Write, failing once it has recorded
+// failAfter writes.
+type writeRecorder struct {
+	writes    []string
+	failAfter int
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	if w.failAfter > 0 && len(w.writes) == w.failAfter {
+		return 0, errors.New("write failed")
+	}
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestEncodeStream(t *testing.T) {
+	docs := starlark.NewList([]starlark.Value{
+		starlark.MakeInt(1),
+		starlark.NewList([]starlark.Value{starlark.String("a")}),
+		starlark.String("b"),
+	})
+	w := &writeRecorder{}
+	if err := EncodeStream(w, docs); err != nil {
+		t.Fatal(err)
+	}
+	// Each document is written separately.
+	want := []string{"1\n", "---\n- a\n", "---\nb\n"}
+	if fmt.Sprint(w.writes) != fmt.Sprint(want) {
+		t.Error("Bad writes from EncodeStream", "\nExpected", want, "\nGot", w.writes)
+	}
+
+	w = &writeRecorder{failAfter: 1}
+	if err := EncodeStream(w, docs); err == nil || err.Error() != "write failed" {
+		t.Error("Bad error from EncodeStream", "\nExpected write failed", "\nGot", err)
+	}
+	if len(w.writes) != 1 {
+		t.Error("Bad writes from EncodeStream after error", "\nGot", w.writes)
+	}
+
+	err := EncodeStream(&writeRecorder{}, starlark.String("a"))
+	wantErr := "yamlmodule.EncodeStream: got string, want list"
+	if err == nil || err.Error() != wantErr {
+		t.Error("Bad error from EncodeStream", "\nExpected", wantErr, "\nGot", err)
+	}
+}
+
 func TestYamlToSky(t *testing.T) {
 	thread := new(starlark.Thread)
 	env := starlark.StringDict{
@@ -239,3 +755,899 @@ func TestYamlToSky(t *testing.T) {
 		})
 	}
 }
+
+// TestYamlExpr checks the value of expressions that use the yaml module,
+// such as yaml.decode, by comparing their String().
+func TestYamlExpr(t *testing.T) {
+	cyclic := starlark.NewDict(1)
+	cyclic.SetKey(starlark.String("self"), cyclic)
+	env := testGlobals(starlark.StringDict{
+		"cyclic": cyclic,
+		// Tokens that YAML 1.1 would decode as a bool, null, or number.
+		"tokens": starlark.NewList(nil),
+	})
+	for _, token := range []string{
+		"y", "Y", "yes", "Yes", "YES", "n", "N", "no", "No", "NO",
+		"on", "On", "ON", "off", "Off", "OFF",
+		"true", "True", "false", "FALSE", "null", "Null", "~", "",
+		"012", "0x1F", "0o17", "0b11", "1_000", "1:20", "+12", "1e3", ".inf", ".NaN",
+	} {
+		env["tokens"].(*starlark.List).Append(starlark.String(token))
+	}
+	if d, ok := evalExpr(t, env, `yaml.decode("b: 1\na: [{d: 2, c: 3}]\n", ordered=True)`, ""); ok {
+		env["d"] = d
+	}
+
+	const labels = `{"app": "web", "tier": "frontend"}`
+	const anchored = `[{"a": ` + labels + `, "b": [` + labels + `, ` + labels + `]}, {"a": ` + labels + `}]`
+	const schema = `{
+		"type": "object",
+		"required": ["name", "spec"],
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z-]+$", "maxLength": 10},
+			"spec": {
+				"type": "object",
+				"additionalProperties": False,
+				"properties": {
+					"replicas": {"type": "integer", "minimum": 1},
+					"ports": {"type": "array", "items": {"type": "integer", "maximum": 65535}, "uniqueItems": True},
+					"mode": {"enum": ["fast", "slow"]},
+					"a/b": {"const": 1.0},
+				},
+			},
+		},
+	}`
+	validate := func(blob, schema string) string {
+		return fmt.Sprintf("yaml.validate(%q, %s)", blob, schema)
+	}
+
+	for _, testCase := range []struct {
+		name    string
+		skyExpr string
+		want    string
+		wantErr string
+	}{
+		// Round trips through yaml.encode
+		{
+			name:    "large integers round trip",
+			skyExpr: `yaml.decode(yaml.encode([(1 << 53) + 1, -((1 << 63)), (1 << 64) - 1]))`,
+			want:    "[9007199254740993, -9223372036854775808, 18446744073709551615]",
+		},
+		{
+			name:    "plain floats round trip",
+			skyExpr: `yaml.decode(yaml.encode([0.00001, 1e21, 1.0, float("inf")], float_format = "plain"))`,
+			want:    "[1e-05, 1e+21, 1.0, +inf]",
+		},
+		{
+			name:    "anchors round trip",
+			skyExpr: fmt.Sprintf("yaml.decode(yaml.encode(%s, use_anchors = True)) == %s", anchored, anchored),
+			want:    "True",
+		},
+		{
+			// Sequences are always decoded as lists.
+			name:    "sets round trip",
+			skyExpr: `[(type(v), v) for v in [yaml.decode(yaml.encode(set([1, 2])))]]`,
+			want:    `[("list", [1, 2])]`,
+		},
+		{
+			// Comments aren't part of the decoded value.
+			name:    "comments round trip",
+			skyExpr: `yaml.decode(yaml.encode({"a": yaml.comment(1, head = "h", line = "l")}))`,
+			want:    `{"a": 1}`,
+		},
+		{
+			// Decoding restores the same keys, including their types.
+			name:    "keys round trip",
+			skyExpr: `[yaml.decode(yaml.encode(v)) == v for v in [{1: "a", "1": "b", "x": "c", 2: "d", -3: "e"}, {True: 1, None: 2, 1.5: 3}]]`,
+			want:    `[True, True]`,
+		},
+		{
+			name:    "ambiguous strings round trip",
+			skyExpr: `[t for t in tokens if yaml.decode(yaml.encode(t)) != t]`,
+			want:    `[]`,
+		},
+		{
+			// Only YAML 1.2 booleans are decoded as bools.
+			name:    "YAML 1.1 booleans",
+			skyExpr: `[yaml.decode(t) for t in ["yes", "no", "on", "off", "y", "n"]]`,
+			want:    `["yes", "no", "on", "off", "y", "n"]`,
+		},
+
+		// multi
+		{
+			name:    "multi",
+			skyExpr: `yaml.decode("a: 1\n---\nb: 2\n", multi=True)`,
+			want:    `[{"a": 1}, {"b": 2}]`,
+		},
+		{
+			name:    "multi with explicit starts",
+			skyExpr: `yaml.decode("---\n- a\n---\n- b\n---\n", multi=True)`,
+			want:    `[["a"], ["b"]]`,
+		},
+		{
+			name:    "multi single document",
+			skyExpr: `yaml.decode("hello", multi=True)`,
+			want:    `["hello"]`,
+		},
+		{
+			name:    "multi empty",
+			skyExpr: `yaml.decode("", multi=True)`,
+			want:    `[]`,
+		},
+
+		// Key order
+		{
+			name:    "key order",
+			skyExpr: `yaml.decode("z: 1\na: 2\nm: 3\n")`,
+			want:    `{"z": 1, "a": 2, "m": 3}`,
+		},
+		{
+			name:    "nested key order",
+			skyExpr: `yaml.decode("z:\n  x: 1\n  b: [{d: 1, c: 2}]\na: 2\n")`,
+			want:    `{"z": {"x": 1, "b": [{"d": 1, "c": 2}]}, "a": 2}`,
+		},
+		{
+			name:    "key order within lists",
+			skyExpr: `yaml.decode("- {z: 1, a: 2}\n- [{z: 1, a: 2}]\n")`,
+			want:    `[{"z": 1, "a": 2}, [{"z": 1, "a": 2}]]`,
+		},
+		{
+			name:    "integer key order",
+			skyExpr: `yaml.decode("3: c\n1: a\n2: b\n")`,
+			want:    `{3: "c", 1: "a", 2: "b"}`,
+		},
+		{
+			name:    "empty mapping",
+			skyExpr: `yaml.decode("{}")`,
+			want:    `{}`,
+		},
+
+		// prefer_int
+		{
+			name:    "prefer int default",
+			skyExpr: `[(v, type(v)) for v in yaml.decode("[1, 1.0, !!float 1]")]`,
+			want:    `[(1, "int"), (1.0, "float"), (1.0, "float")]`,
+		},
+		{
+			name:    "prefer int",
+			skyExpr: `[(v, type(v)) for v in yaml.decode("[1, 1.0, !!float 1]", prefer_int=True)]`,
+			want:    `[(1, "int"), (1, "int"), (1.0, "float")]`,
+		},
+		{
+			name:    "prefer int explicit tags",
+			skyExpr: `yaml.decode("[!!float 2.0, !!int 3, !!float 1e3]", prefer_int=True)`,
+			want:    `[2.0, 3, 1000.0]`,
+		},
+		{
+			name:    "prefer int integral floats",
+			skyExpr: `yaml.decode("[-2.0, 1e3, 0.0, -0.0, 2.5e1]", prefer_int=True)`,
+			want:    `[-2, 1000, 0, 0, 25]`,
+		},
+		{
+			name:    "prefer int non-integral floats",
+			skyExpr: `yaml.decode("[1.5, -0.25, .inf, -.inf, 1e300]", prefer_int=True)`,
+			want:    `[1.5, -0.25, +inf, -inf, 1e+300]`,
+		},
+		{
+			name:    "prefer int nested",
+			skyExpr: `yaml.decode("spec:\n  replicas: 3.0\n  ratio: 0.5\n", prefer_int=True)`,
+			want:    `{"spec": {"replicas": 3, "ratio": 0.5}}`,
+		},
+		{
+			name:    "prefer int strings",
+			skyExpr: `yaml.decode("['1.0', \"2.0\"]", prefer_int=True)`,
+			want:    `["1.0", "2.0"]`,
+		},
+		{
+			name:    "prefer int keys",
+			skyExpr: `yaml.decode("1.0: a", prefer_int=True)`,
+			want:    `{1: "a"}`,
+		},
+		{
+			name:    "prefer int multi",
+			skyExpr: `yaml.decode("1.0\n---\n2.0\n", multi=True, prefer_int=True)`,
+			want:    `[1, 2]`,
+		},
+
+		// Numbers
+		{
+			name:    "exponents",
+			skyExpr: `[(v, type(v)) for v in yaml.decode("[1e3, 1E3, -1e3, +1e3, 1.5e-3, 12e03, 6.8523015e+5]")]`,
+			want:    `[(1000.0, "float"), (1000.0, "float"), (-1000.0, "float"), (1000.0, "float"), (0.0015, "float"), (12000.0, "float"), (685230.15, "float")]`,
+		},
+		{
+			name:    "octal",
+			skyExpr: `yaml.decode("[0o17, 0O17, -0o17, 0o8]")`,
+			want:    `[15, 15, -15, "0o8"]`,
+		},
+		{
+			name:    "hexadecimal",
+			skyExpr: `yaml.decode("[0xff, 0xFF, -0xff, +0xff, 0xfg]")`,
+			want:    `[255, 255, -255, 255, "0xfg"]`,
+		},
+		{
+			name:    "binary numbers",
+			skyExpr: `yaml.decode("[0b101, -0b101]")`,
+			want:    `[5, -5]`,
+		},
+		{
+			name:    "underscores",
+			skyExpr: `[(v, type(v)) for v in yaml.decode("[1_000_000, 685_230.15, 0x_ff, 0x1_0, _1]")]`,
+			want:    `[(1000000, "int"), (685230.15, "float"), (255, "int"), (16, "int"), ("_1", "string")]`,
+		},
+		{
+			name:    "number tags",
+			skyExpr: `[(v, type(v)) for v in yaml.decode("[!!int 1_000, !!int 0o17, !!int 0xff, !!float 1, !!float 0xff, !!float 1e3]")]`,
+			want:    `[(1000, "int"), (15, "int"), (255, "int"), (1.0, "float"), (255.0, "float"), (1000.0, "float")]`,
+		},
+		{
+			name:    "64-bit range",
+			skyExpr: `yaml.decode("[9223372036854775807, -9223372036854775808, 18446744073709551615, 0xffffffffffffffff]")`,
+			want:    `[9223372036854775807, -9223372036854775808, 18446744073709551615, 18446744073709551615]`,
+		},
+		{
+			name:    "beyond 64 bits",
+			skyExpr: `[(v, type(v)) for v in yaml.decode("[18446744073709551616, !!float 18446744073709551616, '18446744073709551616']")]`,
+			want:    `[(1.8446744073709552e+19, "float"), (1.8446744073709552e+19, "float"), ("18446744073709551616", "string")]`,
+		},
+
+		// ordered
+		{
+			name:    "ordered document order",
+			skyExpr: `yaml.decode("b: 1\na: [{d: 2, c: 3}]\n", ordered=True)`,
+			want:    `{"b": 1, "a": [{"d": 2, "c": 3}]}`,
+		},
+		{
+			name:    "ordered types",
+			skyExpr: `[type(d), type(d["a"][0]), type(yaml.decode("b: 1\n"))]`,
+			want:    `["yaml.ordered_dict", "yaml.ordered_dict", "dict"]`,
+		},
+		{
+			name:    "ordered indexing and iteration",
+			skyExpr: `[d["b"], "a" in d, "z" in d, len(d), [k for k in d], d.keys(), d.get("z", 0), dict(d)["b"]]`,
+			want:    `[1, True, False, 2, ["b", "a"], ["b", "a"], 0, 1]`,
+		},
+		{
+			name:    "ordered equality depends on order",
+			skyExpr: `[d == yaml.decode("b: 1\na: [{d: 2, c: 3}]\n", ordered=True), d == yaml.decode("a: [{d: 2, c: 3}]\nb: 1\n", ordered=True)]`,
+			want:    `[True, False]`,
+		},
+		{
+			name:    "ordered encode",
+			skyExpr: `yaml.encode(d, sort_keys=False)`,
+			want:    `"b: 1\na:\n  - d: 2\n    c: 3\n"`,
+		},
+		{
+			name:    "ordered multi",
+			skyExpr: `[type(v) for v in yaml.decode("a: 1\n---\nb: 2\n", multi=True, ordered=True)]`,
+			want:    `["yaml.ordered_dict", "yaml.ordered_dict"]`,
+		},
+		{
+			name:    "ordered duplicate keys",
+			skyExpr: `yaml.decode("a: 1\na: 2\n", ordered=True)`,
+			wantErr: "yaml: line 2, column 1: mapping key \"a\" already defined at line 1\n  a: 2\n  ^",
+		},
+		{
+			name:    "ordered read only",
+			skyExpr: `d.update({"c": 1})`,
+			wantErr: "yaml.ordered_dict has no .update field or method",
+		},
+
+		// Anchors, merge keys, and block scalars
+		{
+			name:    "anchors and aliases",
+			skyExpr: `yaml.decode("a: &x [1, 2]\nb: *x\n")`,
+			want:    `{"a": [1, 2], "b": [1, 2]}`,
+		},
+		{
+			name:    "recursive alias",
+			skyExpr: `yaml.decode("a: &x [*x]\n")`,
+			wantErr: "yaml: line 1, column 8: anchor \"x\" value contains itself\n  a: &x [*x]\n         ^",
+		},
+		{
+			name:    "merge keys",
+			skyExpr: `yaml.decode("base: &base {a: 1, b: 2}\nchild:\n  <<: *base\n  b: 3\n  c: 4\n")`,
+			want:    `{"base": {"a": 1, "b": 2}, "child": {"a": 1, "b": 3, "c": 4}}`,
+		},
+		{
+			name:    "merge keys after keys",
+			skyExpr: `yaml.decode("child:\n  b: 3\n  <<: {a: 1, b: 2}\n")`,
+			want:    `{"child": {"b": 3, "a": 1}}`,
+		},
+		{
+			name:    "merge key list",
+			skyExpr: `yaml.decode("x: &x {a: 1}\ny: &y {a: 2, b: 2}\nz:\n  <<: [*x, *y]\n")`,
+			want:    `{"x": {"a": 1}, "y": {"a": 2, "b": 2}, "z": {"a": 1, "b": 2}}`,
+		},
+		{
+			name:    "literal and folded scalars",
+			skyExpr: `yaml.decode("literal: |\n  line one\n  line two\nfolded: >\n  line one\n  line two\n")`,
+			want:    `{"literal": "line one\nline two\n", "folded": "line one line two\n"}`,
+		},
+		{
+			name:    "chomping indicators",
+			skyExpr: `yaml.decode("strip: |-\n  text\nkeep: |+\n  text\n\n")`,
+			want:    `{"strip": "text", "keep": "text\n\n"}`,
+		},
+
+		// strict
+		{
+			name:    "lenient keeps last value",
+			skyExpr: `yaml.decode("a: 1\na: 2\n")`,
+			want:    `{"a": 2}`,
+		},
+		{
+			name:    "strict top-level duplicate",
+			skyExpr: `yaml.decode("a: 1\na: 2\n", strict=True)`,
+			wantErr: "yaml: line 2, column 1: mapping key \"a\" already defined at line 1\n  a: 2\n  ^",
+		},
+		{
+			name:    "strict nested duplicate",
+			skyExpr: `yaml.decode("a:\n  b: 1\n  c: 2\n  b: 3\n", strict=True)`,
+			wantErr: "yaml: line 4, column 3: mapping key \"b\" already defined at line 2\n    b: 3\n    ^",
+		},
+		{
+			name:    "strict duplicate within list",
+			skyExpr: `yaml.decode("- {x: 1, x: 2}\n", strict=True)`,
+			wantErr: "yaml: line 1, column 10: mapping key \"x\" already defined at line 1\n  - {x: 1, x: 2}\n           ^",
+		},
+		{
+			name:    "strict allows overriding merged keys",
+			skyExpr: `yaml.decode("a:\n  <<: {b: 1}\n  b: 2\n", strict=True)`,
+			want:    `{"a": {"b": 2}}`,
+		},
+		{
+			name:    "strict multi-document",
+			skyExpr: `yaml.decode("a: 1\n---\nb: 1\nb: 2\n", multi=True, strict=True)`,
+			wantErr: "yaml: line 4, column 1: mapping key \"b\" already defined at line 3\n  b: 2\n  ^",
+		},
+
+		// Empty documents
+		{
+			name:    "empty string",
+			skyExpr: `yaml.decode("")`,
+			wantErr: "yaml.decode: empty YAML input",
+		},
+		{
+			name:    "whitespace only",
+			skyExpr: `yaml.decode(" \n\t\n")`,
+			wantErr: "yaml.decode: empty YAML input",
+		},
+		{
+			name:    "null",
+			skyExpr: `yaml.decode("null")`,
+			want:    `None`,
+		},
+		{
+			name:    "tilde",
+			skyExpr: `yaml.decode("~")`,
+			want:    `None`,
+		},
+		{
+			name:    "comment only",
+			skyExpr: `yaml.decode("# nothing here\n")`,
+			want:    `None`,
+		},
+
+		// Timestamps
+		{
+			name:    "timestamp type",
+			skyExpr: `type(yaml.decode("2001-12-14T21:59:43.10-05:00"))`,
+			want:    `"time.time"`,
+		},
+		{
+			name:    "date",
+			skyExpr: `yaml.decode("2001-12-14").year`,
+			want:    `2001`,
+		},
+		{
+			name:    "timestamp with space",
+			skyExpr: `yaml.decode("2001-12-14 21:59:43.10").hour`,
+			want:    `21`,
+		},
+		{
+			name:    "timestamp arithmetic",
+			skyExpr: `str(yaml.decode("b: 2001-12-15\na: 2001-12-14\n")["b"] - yaml.decode("2001-12-14"))`,
+			want:    `"24h0m0s"`,
+		},
+		// Quoted and explicitly tagged scalars follow the usual YAML rules.
+		{
+			name:    "quoted timestamp",
+			skyExpr: `yaml.decode("'2001-12-14'")`,
+			want:    `"2001-12-14"`,
+		},
+		{
+			name:    "timestamp tagged as string",
+			skyExpr: `yaml.decode("!!str 2001-12-14")`,
+			want:    `"2001-12-14"`,
+		},
+		{
+			name:    "string tagged as timestamp",
+			skyExpr: `type(yaml.decode("!!timestamp '2001-12-14'"))`,
+			want:    `"time.time"`,
+		},
+		{
+			name:    "encode timestamp",
+			skyExpr: `yaml.encode(yaml.decode("2001-12-14T21:59:43.10-05:00"))`,
+			want:    `"2001-12-14T21:59:43.1-05:00\n"`,
+		},
+		{
+			name:    "encode date",
+			skyExpr: `yaml.encode({"t": yaml.decode("2001-12-14")})`,
+			want:    `"t: 2001-12-14T00:00:00Z\n"`,
+		},
+		{
+			name:    "timestamp round trip",
+			skyExpr: `yaml.decode(yaml.encode(yaml.decode("2001-12-14t21:59:43.10Z"))) == yaml.decode("2001-12-14T21:59:43.1Z")`,
+			want:    `True`,
+		},
+
+		// Binary
+		{
+			name:    "binary",
+			skyExpr: `yaml.decode("!!binary aGVsbG8=")`,
+			want:    `b"hello"`,
+		},
+		{
+			name:    "binary non-UTF-8",
+			skyExpr: `yaml.decode("!!binary /wD+gA==")`,
+			want:    `b"\xff\x00\xfe\x80"`,
+		},
+		{
+			name:    "binary block",
+			skyExpr: `yaml.decode("data: !!binary |\n  R0lG\n  ODlh\n")`,
+			want:    `{"data": b"GIF89a"}`,
+		},
+		{
+			name:    "untagged base64",
+			skyExpr: `yaml.decode("'aGVsbG8='")`,
+			want:    `"aGVsbG8="`,
+		},
+		{
+			name:    "encode binary",
+			skyExpr: `yaml.encode(b"hello")`,
+			want:    `"!!binary aGVsbG8=\n"`,
+		},
+		{
+			name:    "encode nested binary",
+			skyExpr: `yaml.encode({"data": [b"\xff\x00\xfe\x80"]})`,
+			want:    `"data:\n  - !!binary /wD+gA==\n"`,
+		},
+		{
+			name:    "binary round trip",
+			skyExpr: `yaml.decode(yaml.encode(b"\xff\x00\xfe\x80")) == b"\xff\x00\xfe\x80"`,
+			want:    `True`,
+		},
+		{
+			name:    "empty binary round trip",
+			skyExpr: `yaml.decode(yaml.encode({"a": b"", "b": "text"}))`,
+			want:    `{"a": b"", "b": "text"}`,
+		},
+
+		// coerce_keys
+		{
+			name:    "sequence key without coercion",
+			skyExpr: `yaml.decode("? [a, b]\n: 1\n")`,
+			wantErr: "slice ([a b]) is not a supported key type",
+		},
+		{
+			name:    "sequence key",
+			skyExpr: `yaml.decode("? [a, b]\n: 1\n? - c\n  - 'd'\n: 2\n", coerce_keys=True)`,
+			want:    `{"[a, b]": 1, "[c, d]": 2}`,
+		},
+		{
+			name:    "mapping key",
+			skyExpr: `yaml.decode("? {z: 1, a: [true, null]}\n: x\n", coerce_keys=True)`,
+			want:    `{"{a: [true, null], z: 1}": "x"}`,
+		},
+		{
+			name:    "nested mapping key",
+			skyExpr: `yaml.decode("a:\n  [1, '2']: b\n", coerce_keys=True)`,
+			want:    `{"a": {"[1, \"2\"]": "b"}}`,
+		},
+		{
+			name:    "scalar keys are unchanged",
+			skyExpr: `yaml.decode("1: a\nb: c\n", coerce_keys=True)`,
+			want:    `{1: "a", "b": "c"}`,
+		},
+		{
+			name:    "strict duplicate coerced key",
+			skyExpr: `yaml.decode("[a, b]: 1\n[a,b]: 2\n", coerce_keys=True, strict=True)`,
+			wantErr: "yaml: line 2, column 1: mapping key \"[a, b]\" already defined at line 1\n  [a,b]: 2\n  ^",
+		},
+
+		// keep_tags
+		{
+			name:    "tags resolved by default",
+			skyExpr: `yaml.decode("a: !Ref b\nc: !Custom {d: 1}\n")`,
+			want:    `{"a": "b", "c": {"d": 1}}`,
+		},
+		{
+			name:    "scalar tag kept",
+			skyExpr: `yaml.decode("a: !Ref b\n", keep_tags=True)`,
+			want:    `{"a": yaml.tagged("!Ref", "b")}`,
+		},
+		{
+			name:    "tag attributes",
+			skyExpr: `[(v.tag, v.value) for v in [yaml.decode("!Ref b", keep_tags=True)]]`,
+			want:    `[("!Ref", "b")]`,
+		},
+		{
+			name:    "standard tags resolved",
+			skyExpr: `yaml.decode("a: !!str 1\nb: !!int '2'\n", keep_tags=True)`,
+			want:    `{"a": "1", "b": 2}`,
+		},
+		{
+			name:    "scalar tag round trip",
+			skyExpr: `yaml.encode(yaml.decode("a: !Ref b\nc: !Sub 1\nd: !Sub 'x: y'\n", keep_tags=True))`,
+			want:    `"a: !Ref b\nc: !Sub 1\nd: !Sub 'x: y'\n"`,
+		},
+		{
+			name:    "mapping tag round trip",
+			skyExpr: `yaml.encode(yaml.decode("a: !Custom\n  b: 1\n  c: [x, !Ref y]\n", keep_tags=True))`,
+			want:    `"a: !Custom\n  b: 1\n  c:\n    - x\n    - !Ref y\n"`,
+		},
+		{
+			name:    "tagged merge keys",
+			skyExpr: `yaml.decode("base: &base !Custom {a: 1}\nb:\n  <<: *base\n  c: 2\n", keep_tags=True)["b"]`,
+			want:    `{"a": 1, "c": 2}`,
+		},
+		{
+			name:    "tag equality",
+			skyExpr: `[yaml.decode("!A x", keep_tags=True) == yaml.decode("!A x", keep_tags=True), yaml.decode("!A x", keep_tags=True) == yaml.decode("!B x", keep_tags=True)]`,
+			want:    `[True, False]`,
+		},
+
+		// yaml.encodable
+		{
+			name:    "encodable",
+			skyExpr: `yaml.encodable({"a": [1, "b", struct(c = None)], 2: b"x"})`,
+			want:    "True",
+		},
+		{
+			name:    "not encodable",
+			skyExpr: `yaml.encodable({"a": [len]})`,
+			want:    "False",
+		},
+		{
+			name:    "unsupported key",
+			skyExpr: `yaml.encodable({(1, 2): "a"})`,
+			want:    "False",
+		},
+		{
+			name:    "path of nested value",
+			skyExpr: `yaml.encodable(struct(spec = {"items": [1, 2, {"weird": len}]}), fail = True)`,
+			wantErr: "yaml.encodable: value at .spec.items[2].weird: TypeError: value <built-in function len> (type `builtin_function_or_method') can't be converted to YAML.",
+		},
+		{
+			name:    "path of quoted key",
+			skyExpr: `yaml.encodable({"a-b": {1: len}}, fail = True)`,
+			wantErr: "yaml.encodable: value at [\"a-b\"][1]: TypeError: value <built-in function len> (type `builtin_function_or_method') can't be converted to YAML.",
+		},
+		{
+			name:    "top-level value",
+			skyExpr: `yaml.encodable(len, fail = True)`,
+			wantErr: "yaml.encodable: TypeError: value <built-in function len> (type `builtin_function_or_method') can't be converted to YAML.",
+		},
+		{
+			name:    "value containing itself",
+			skyExpr: `yaml.encodable([cyclic], fail = True)`,
+			wantErr: "yaml.encodable: value at [0].self: ValueError: value {\"self\": {...}} (type `dict') contains itself and can't be converted to YAML.",
+		},
+		{
+			name:    "shared value",
+			skyExpr: `[yaml.encodable([x, {"a": x}]) for x in [[1]]][0]`,
+			want:    "True",
+		},
+
+		// yaml.validate
+		{
+			name:    "valid document",
+			skyExpr: validate("name: web\nspec:\n  replicas: 3\n  ports: [80, 443]\n  mode: fast\n  a/b: 1\n", schema),
+			want:    "None",
+		},
+		{
+			name:    "integral float is an integer",
+			skyExpr: validate("2.0", `{"type": "integer"}`),
+			want:    "None",
+		},
+		{
+			name:    "true schema",
+			skyExpr: validate("[anything]", `True`),
+			want:    "None",
+		},
+		{
+			name:    "all violations are reported",
+			skyExpr: validate("name: Web_Server_Name\nspec:\n  replicas: '3'\n  ports: [80, 80, 70000]\n  mode: medium\n  a/b: 2\n  extra: true\n", schema),
+			wantErr: `yaml.validate: document does not match schema:
+  #/name: got "Web_Server_Name", want a string matching "^[a-z-]+$"
+  #/name: got string of length 15, want at most 10
+  #/spec: unexpected property "extra"
+  #/spec/replicas: got string, want integer
+  #/spec/ports/2: got 70000, want at most 65535
+  #/spec/ports: items 0 and 1 are equal, want unique items
+  #/spec/mode: got "medium", want one of ["fast","slow"]
+  #/spec/a~1b: got 2, want 1`,
+		},
+		{
+			name:    "missing required",
+			skyExpr: validate("spec: {}\n", schema),
+			wantErr: `yaml.validate: document does not match schema:
+  #: missing required property "name"`,
+		},
+		{
+			name:    "combinators",
+			skyExpr: validate("5", `{"anyOf": [{"type": "string"}, {"type": "null"}], "oneOf": [{"minimum": 1}, {"maximum": 10}], "not": {"type": "integer"}}`),
+			wantErr: `yaml.validate: document does not match schema:
+  #: value does not match any schema in anyOf
+  #: value matches 2 schemas in oneOf, want exactly one
+  #: value must not match the schema in not`,
+		},
+		{
+			name:    "invalid schema",
+			skyExpr: validate("a: 1", `{"properties": {"a": {"minimum": "1"}}}`),
+			wantErr: `yaml.validate: invalid schema at #/properties/a/minimum: got string, want number`,
+		},
+		{
+			name:    "unsupported schema type",
+			skyExpr: validate("a: 1", `[]`),
+			wantErr: `yaml.validate: for parameter schema: got list, want dict or bool`,
+		},
+		{
+			name:    "validate decode error",
+			skyExpr: validate("a: [1", `{}`),
+			wantErr: "yaml: line 1: did not find expected ',' or ']'\n  a: [1",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			v, ok := evalExpr(t, env, testCase.skyExpr, testCase.wantErr)
+			if !ok {
+				return
+			}
+			if v.String() != testCase.want {
+				t.Error("Bad return value from", testCase.skyExpr, "\nExpected", testCase.want, "\nGot", v)
+			}
+		})
+	}
+}
+
+func TestYamlToSkyErrorContext(t *testing.T) {
+	env := testGlobals(nil)
+
+	for _, testCase := range []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "parse error",
+			src:     `yaml.decode("a: 1\n  b: 2\n")`,
+			wantErr: "yaml: line 2: mapping values are not allowed in this context\n    b: 2",
+		},
+		{
+			name:    "parse error in multi-document stream",
+			src:     `yaml.decode("a: 1\n---\nb: 'x\n", multi=True)`,
+			wantErr: "yaml: line 3: found unexpected end of stream\n  b: 'x",
+		},
+		{
+			name:    "invalid scalar",
+			src:     `yaml.decode("a: 1\nb: !!int abc\n")`,
+			wantErr: "yaml: line 2, column 4: cannot decode !!str `abc` as a !!int\n  b: !!int abc\n     ^",
+		},
+		{
+			name:    "long lines are truncated",
+			src:     `yaml.decode("a: 1\na: 2 ` + strings.Repeat("x", 100) + `\n", strict=True)`,
+			wantErr: "yaml: line 2, column 1: mapping key \"a\" already defined at line 1\n  a: 2 " + strings.Repeat("x", 75) + "...\n  ^",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			thread := new(starlark.Thread)
+			_, err := starlark.ExecFile(thread, "test.sky", testCase.src, env)
+			evalErr, ok := err.(*starlark.EvalError)
+			if !ok {
+				t.Fatal("Expected *starlark.EvalError", "\nGot", err)
+			}
+			if evalErr.Msg != testCase.wantErr {
+				t.Error("Bad error from yaml.decode", "\nExpected", testCase.wantErr, "\nGot", evalErr.Msg)
+			}
+			if backtrace := evalErr.Backtrace(); !strings.Contains(backtrace, "Error in yaml.decode: ") {
+				t.Error("Backtrace does not name yaml.decode", "\nGot", backtrace)
+			}
+		})
+	}
+}
+
+type testFileReader map[string]string
+
+func (r testFileReader) Resolve(ctx context.Context, name, fromPath string) (string, error) {
+	return path.Join(path.Dir(fromPath), name), nil
+}
+
+func (r testFileReader) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	if source, ok := r[path]; ok {
+		return []byte(source), nil
+	}
+	return nil, fmt.Errorf("file %s not found", path)
+}
+
+func TestYamlDecodeFile(t *testing.T) {
+	env := testGlobals(nil)
+	reader := testFileReader{
+		"configs/app.yaml":     "name: app\nports: [80, 443]\n",
+		"configs/multi.yaml":   "a: 1\n---\nb: 2\n",
+		"configs/dup.yaml":     "a: 1\na: 2\n",
+		"configs/bad.yaml":     "a: 1\n  b: 2\n",
+		"configs/empty.yaml":   "",
+		"configs/blank.yaml":   " \n\t\n",
+		"configs/comment.yaml": "# nothing here\n",
+	}
+
+	for _, testCase := range []struct {
+		expr    string
+		want    string
+		wantErr string
+	}{
+		{expr: `yaml.decode_file("app.yaml")`, want: `{"name": "app", "ports": [80, 443]}`},
+		{expr: `yaml.decode_file("multi.yaml", multi=True)`, want: `[{"a": 1}, {"b": 2}]`},
+		{expr: `yaml.decode_file("multi.yaml")`, want: `{"a": 1}`},
+		{expr: `[type(v) for v in [yaml.decode_file("app.yaml", ordered=True)]]`, want: `["yaml.ordered_dict"]`},
+		{expr: `yaml.decode_file("app.yaml", ordered=True)`, want: `{"name": "app", "ports": [80, 443]}`},
+		{expr: `yaml.decode_file("empty.yaml")`, wantErr: "yaml.decode_file: configs/empty.yaml: empty YAML input"},
+		{expr: `yaml.decode_file("blank.yaml")`, wantErr: "yaml.decode_file: configs/blank.yaml: empty YAML input"},
+		{expr: `yaml.decode_file("comment.yaml")`, want: `None`},
+		{expr: `yaml.decode_file("empty.yaml", multi=True)`, want: `[]`},
+		{expr: `yaml.decode_file("missing.yaml")`, wantErr: "file configs/missing.yaml not found"},
+		{
+			expr:    `yaml.decode_file("dup.yaml", strict=True)`,
+			wantErr: "configs/dup.yaml: yaml: line 2, column 1: mapping key \"a\" already defined at line 1\n  a: 2\n  ^",
+		},
+		{
+			expr:    `yaml.decode_file("bad.yaml")`,
+			wantErr: "configs/bad.yaml: yaml: line 2: mapping values are not allowed in this context\n    b: 2",
+		},
+	} {
+		thread := new(starlark.Thread)
+		SetFileReader(thread, context.Background(), reader)
+		// Files are resolved relative to the calling module.
+		globals, err := starlark.ExecFile(thread, "configs/main.sky", "value = "+testCase.expr, env)
+		if testCase.wantErr != "" {
+			evalErr, ok := err.(*starlark.EvalError)
+			if !ok || evalErr.Msg != testCase.wantErr {
+				t.Error("Bad error from", testCase.expr, "\nExpected", testCase.wantErr, "\nGot", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("Error from", testCase.expr, "\nExpected nil", "\nGot", err)
+			continue
+		}
+		if got := globals["value"].String(); got != testCase.want {
+			t.Error("Bad return value from", testCase.expr, "\nExpected", testCase.want, "\nGot", got)
+		}
+	}
+
+	evalExpr(t, env, `yaml.decode_file("app.yaml")`, "yaml.decode_file: no FileReader is configured")
+}
+
+func TestYamlNode(t *testing.T) {
+	const src = `# Service configuration.
+name: web # the service name
+replicas: 3
+image: "nginx"
+# Exposed ports.
+ports:
+  - 80
+  - 443
+`
+	env := testGlobals(starlark.StringDict{"src": starlark.String(src)})
+	for _, testCase := range []struct {
+		name    string
+		script  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "round trip",
+			script: `value = yaml.encode_node(yaml.decode_node(src))`,
+			want:   src,
+		},
+		{
+			name: "set scalars",
+			script: `
+node = yaml.decode_node(src)
+node.set("name", "api")
+node.set("replicas", 5)
+node.set("image", "nginx:1.21")
+node.set("ports", 1, 8443)
+value = yaml.encode_node(node)
+`,
+			want: `# Service configuration.
+name: api # the service name
+replicas: 5
+image: "nginx:1.21"
+# Exposed ports.
+ports:
+  - 80
+  - 8443
+`,
+		},
+		{
+			name: "add entries",
+			script: `
+node = yaml.decode_node(src)
+node.set("ports", 2, 8080)
+node.set("labels", {"tier": "frontend"})
+value = yaml.encode_node(node)
+`,
+			want: src + "  - 8080\nlabels:\n  tier: frontend\n",
+		},
+		{
+			name: "replace collection",
+			script: `
+node = yaml.decode_node(src)
+node.set("ports", [8080])
+value = yaml.encode_node(node)
+`,
+			want: "# Service configuration.\nname: web # the service name\nreplicas: 3\nimage: \"nginx\"\n# Exposed ports.\nports:\n  - 8080\n",
+		},
+		{
+			name: "set commented values",
+			script: `
+node = yaml.decode_node(src)
+node.set("replicas", yaml.comment({"min": 2}, head = "Scaled.", line = "per zone"))
+node.set("ports", yaml.comment([8080], head = "Replaced."))
+node.set("labels", yaml.comment({"tier": "frontend"}, head = "Added."))
+value = yaml.encode_node(node)
+`,
+			want: `# Service configuration.
+name: web # the service name
+# Scaled.
+replicas:
+  min: 2 # per zone
+image: "nginx"
+# Exposed ports.
+ports:
+  - 8080
+# Added.
+labels:
+  tier: frontend
+`,
+		},
+		{
+			name:   "get",
+			script: `node = yaml.decode_node(src); value = [node.get("name"), node.get("ports", 1), node.get()["replicas"]]`,
+			want:   `["web", 443, 3]`,
+		},
+		{
+			name:    "get missing key",
+			script:  `value = yaml.decode_node(src).get("ports", 5)`,
+			wantErr: "get: at [\"ports\"]: index 5 out of range",
+		},
+		{
+			name:    "set within scalar",
+			script:  `value = yaml.decode_node(src).set("name", "first", "x")`,
+			wantErr: "set: at [\"name\"]: cannot index scalar value",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			globals, err := starlark.ExecFile(new(starlark.Thread), "<test>", testCase.script, env)
+			if testCase.wantErr != "" {
+				evalErr, ok := err.(*starlark.EvalError)
+				if !ok || evalErr.Msg != testCase.wantErr {
+					t.Error("Bad error", "\nExpected", testCase.wantErr, "\nGot", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := starlark.AsString(globals["value"])
+			if !ok {
+				got = globals["value"].String()
+			}
+			if got != testCase.want {
+				t.Errorf("Bad result\nExpected:\n%s\nGot:\n%s", testCase.want, got)
+			}
+		})
+	}
+}
@@ -20,46 +20,144 @@ package skycfg
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
-	"go.starlark.net/starlarkjson"
 	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 
 	"github.com/stripe/skycfg/go/assertmodule"
+	"github.com/stripe/skycfg/go/base64module"
+	"github.com/stripe/skycfg/go/dictsmodule"
+	"github.com/stripe/skycfg/go/envmodule"
+	"github.com/stripe/skycfg/go/fmtmodule"
 	"github.com/stripe/skycfg/go/hashmodule"
+	"github.com/stripe/skycfg/go/hclmodule"
+	"github.com/stripe/skycfg/go/jsonmodule"
 	"github.com/stripe/skycfg/go/protomodule"
+	"github.com/stripe/skycfg/go/regexmodule"
+	"github.com/stripe/skycfg/go/tomlmodule"
 	"github.com/stripe/skycfg/go/urlmodule"
 	"github.com/stripe/skycfg/go/yamlmodule"
 )
 
 // Starlark thread-local storage keys.
 const (
-	contextKey   = "context"   // has type context.Context
-	logOutputKey = "logoutput" // has type io.Writer
+	contextKey      = "context"      // has type context.Context
+	logOutputKey    = "logoutput"    // has type io.Writer
+	printHookKey    = "printhook"    // has type PrintHook
+	loadOptionalKey = "loadoptional" // has type loadOptionalFunc
 )
 
+// GetContext returns the context.Context passed to Load(), Config.Main(), or
+// Test.Run() for the execution running on a thread, so that builtins can
+// respect its cancellation and deadline. It is stored as a thread-local value
+// with the key "context". If the thread was not created by Skycfg, GetContext
+// returns context.Background().
+func GetContext(thread *starlark.Thread) context.Context {
+	if ctx, ok := thread.Local(contextKey).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// ThreadLocal returns the thread-local value with the given key, or nil if it
+// isn't set. Builtins can use thread-local values to keep state for the
+// duration of one execution, because Load(), Config.Main(), and Test.Run()
+// each execute on a new thread.
+//
+// Keys are namespaced by the package that owns them, as "namespace.name", so
+// that modules don't collide. For example, the "re" module caches compiled
+// patterns with the key "regexmodule.cache". Keys without a namespace are
+// reserved for Skycfg, and ThreadLocal and SetThreadLocal panic if given one.
+func ThreadLocal(thread *starlark.Thread, key string) interface{} {
+	checkThreadLocalKey("ThreadLocal", key)
+	return thread.Local(key)
+}
+
+// SetThreadLocal sets the thread-local value with the given key, as described
+// by ThreadLocal(). Like thread.SetLocal, it isn't safe to call concurrently
+// with other uses of the thread, but may be called by a builtin during
+// execution.
+func SetThreadLocal(thread *starlark.Thread, key string, value interface{}) {
+	checkThreadLocalKey("SetThreadLocal", key)
+	thread.SetLocal(key, value)
+}
+
+func checkThreadLocalKey(fnName, key string) {
+	dot := strings.IndexByte(key, '.')
+	if dot <= 0 || dot == len(key)-1 {
+		panic(fmt.Sprintf("%s: key %q has no namespace", fnName, key))
+	}
+}
+
+// cancelOnDone cancels execution on the thread when the context is done,
+// until the returned function is called.
+func cancelOnDone(ctx context.Context, thread *starlark.Thread) (stop func()) {
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			thread.Cancel(ctx.Err().Error())
+		case <-stopped:
+		}
+	}()
+	return func() { close(stopped) }
+}
+
 // A FileReader controls how load() calls resolve and read other modules.
+//
+// Implementations need not be backed by the local filesystem; a FileReader
+// may read modules from an object store, an archive, or memory. Skycfg never
+// interprets paths itself, and all load() resolution, including of names
+// relative to the loading module, goes through Resolve.
 type FileReader interface {
 	// Resolve parses the "name" part of load("name", "symbol") to a path. This
 	// is not required to correspond to a true path on the filesystem, but should
 	// be "absolute" within the semantics of this FileReader.
 	//
-	// fromPath will be empty when loading the root module passed to Load().
+	// fromPath is the path previously returned by Resolve for the module
+	// containing the load() statement, so a relative name may be resolved
+	// against it. fromPath will be empty when loading the root module passed
+	// to Load().
+	//
+	// The returned path identifies the module: two names resolving to the
+	// same path load the module only once. It is also the filename reported
+	// in error messages and tracebacks.
 	Resolve(ctx context.Context, name, fromPath string) (path string, err error)
 
 	// ReadFile reads the content of the file at the given path, which was
 	// returned from Resolve().
+	//
+	// If the file doesn't exist, the returned error should satisfy
+	// errors.Is(err, os.ErrNotExist), so that load_optional() can tell a
+	// missing module apart from other failures.
 	ReadFile(ctx context.Context, path string) ([]byte, error)
 }
 
@@ -91,6 +189,21 @@ func (r *localFileReader) ReadFile(ctx context.Context, path string) ([]byte, er
 	return ioutil.ReadFile(path)
 }
 
+// A recordingFileReader is a FileReader that calls read with the path and
+// content of each file read through it successfully.
+type recordingFileReader struct {
+	FileReader
+	read func(path string, content []byte)
+}
+
+func (r *recordingFileReader) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	content, err := r.FileReader.ReadFile(ctx, path)
+	if err == nil {
+		r.read(path, content)
+	}
+	return content, err
+}
+
 // NewProtoMessage returns a Starlark value representing the given Protobuf
 // message. It can be returned back to a proto.Message() via AsProtoMessage().
 func NewProtoMessage(msg proto.Message) (starlark.Value, error) {
@@ -112,15 +225,27 @@ func NewProtoPackage(r unstableProtoRegistryV2, name string) starlark.Value {
 
 // A Config is a Skycfg config file that has been fully loaded and is ready
 // for execution.
+//
+// A Config may be executed by concurrent calls to Main and its variants. Each
+// call runs on its own Starlark thread with its own ctx.vars, and the globals
+// of the config are frozen by Load, so calls can't modify values they share.
+// Values passed with WithVars() are not copied, so a mutable value passed to
+// concurrent calls must not be modified by the config.
 type Config struct {
-	filename string
-	globals  starlark.StringDict
-	locals   starlark.StringDict
-	tests    []*Test
+	filename     string
+	globals      starlark.StringDict
+	locals       starlark.StringDict
+	tests        []*Test
+	dependencies []string
+	fileReader   FileReader
 }
 
 type commonOptions struct {
 	logOutput io.Writer
+	printHook PrintHook
+	maxSteps  uint64
+	maxAllocs uint64
+	timeout   time.Duration
 }
 
 // A CommonOption is an option that can be applied to Load, Config.Main, and Test.Run.
@@ -152,6 +277,249 @@ func WithLogOutput(w io.Writer) CommonOption {
 	})
 }
 
+// A PrintHook is called for each print() function call in Starlark code, with
+// the printed message and the position of the call.
+type PrintHook func(thread *starlark.Thread, msg string, pos syntax.Position)
+
+// WithPrintHook changes the handling of print() function calls in Starlark
+// code, replacing the default of writing them to the log output. If nil, the
+// default is kept.
+func WithPrintHook(hook PrintHook) CommonOption {
+	return fnCommonOption(func(opts *commonOptions) {
+		opts.printHook = hook
+	})
+}
+
+// WithExecutionLimit limits the number of Starlark computation steps that each
+// execution may take. For Load, the limit covers every module loaded; for
+// Config.Main and Test.Run, it covers each call. Exceeding the limit stops
+// execution with an *ExecutionLimitError.
+func WithExecutionLimit(maxSteps int) CommonOption {
+	if maxSteps <= 0 {
+		panic("WithExecutionLimit: maxSteps must be positive")
+	}
+	return fnCommonOption(func(opts *commonOptions) {
+		opts.maxSteps = uint64(maxSteps)
+	})
+}
+
+// WithTimeout limits the wall-clock time that each execution may take, in the
+// same way as WithExecutionLimit limits its steps. Exceeding the limit stops
+// execution with an *ExecutionLimitError.
+//
+// Unlike cancelling the context, a timeout is reported as an
+// *ExecutionLimitError so callers can tell it apart from other cancellation.
+func WithTimeout(d time.Duration) CommonOption {
+	if d <= 0 {
+		panic("WithTimeout: duration must be positive")
+	}
+	return fnCommonOption(func(opts *commonOptions) {
+		opts.timeout = d
+	})
+}
+
+// WithMaxAllocs limits the number of bytes allocated by the whole process while
+// each execution runs. Exceeding the limit stops execution with an
+// *ExecutionLimitError.
+//
+// This is a process-wide limit rather than a per-execution bound. The Starlark
+// interpreter doesn't track allocations, so the bytes allocated by the Go
+// runtime are checked periodically while execution runs. Allocations by every
+// other goroutine in the process are counted, including other executions
+// running concurrently, so it is only a reliable bound on a single
+// execution's allocations when nothing else runs at the same time. Execution
+// may also allocate somewhat more than the limit before it's stopped.
+func WithMaxAllocs(bytes int64) CommonOption {
+	if bytes <= 0 {
+		panic("WithMaxAllocs: bytes must be positive")
+	}
+	return fnCommonOption(func(opts *commonOptions) {
+		opts.maxAllocs = uint64(bytes)
+	})
+}
+
+// allocCheckInterval is how often allocations are checked against the limit
+// set with WithMaxAllocs.
+const allocCheckInterval = 10 * time.Millisecond
+
+// heapAllocs returns the total bytes allocated by the Go runtime. Unlike
+// runtime.ReadMemStats, reading the metric doesn't stop the world, but it
+// isn't available before Go 1.17.
+func heapAllocs() uint64 {
+	sample := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		return sample[0].Value.Uint64()
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.TotalAlloc
+}
+
+// An ExecutionLimitError is returned when execution is stopped for exceeding
+// a limit set with WithExecutionLimit, WithMaxAllocs, or WithTimeout.
+type ExecutionLimitError struct {
+	// Limit describes the limit that was exceeded, such as "1000 steps" or
+	// "1048576 bytes allocated".
+	Limit string
+
+	// Position is where execution was when it was stopped. It's approximate,
+	// because the interpreter only stops between computation steps.
+	Position syntax.Position
+
+	// Err is the error returned by the Starlark interpreter.
+	Err error
+}
+
+func (e *ExecutionLimitError) Error() string {
+	return fmt.Sprintf("%s: execution limit of %s exceeded", e.Position, e.Limit)
+}
+
+func (e *ExecutionLimitError) Unwrap() error { return e.Err }
+
+// Frames returns the Starlark call stack where execution was stopped.
+func (e *ExecutionLimitError) Frames() []Frame { return errorFrames(e.Err) }
+
+// A Frame is a function call in the Starlark call stack of an error.
+type Frame struct {
+	// Function is the name of the called function. Code at the top level of
+	// a module is in the function "<toplevel>".
+	Function string
+
+	// Position is where execution was within the function. It's in the
+	// file "<builtin>" for calls to builtins.
+	Position syntax.Position
+}
+
+// A TracebackError is an error from executing Starlark code that carries the
+// call stack where the error happened. Errors returned by Load(),
+// Config.Main() and its variants, and Test.Run() implement it if they were
+// caused by executing Starlark code.
+type TracebackError interface {
+	error
+
+	// Frames returns the call stack, outermost call first. If the error
+	// happened in a module loaded by load(), the stack starts with the
+	// load() statements leading to it. It's the structured form of the
+	// traceback in starlark.EvalError.Backtrace().
+	Frames() []Frame
+}
+
+// tracebackError adds the Frames method to an error from the Starlark
+// interpreter.
+type tracebackError struct {
+	err error
+}
+
+func (e *tracebackError) Error() string { return e.err.Error() }
+
+func (e *tracebackError) Unwrap() error { return e.err }
+
+func (e *tracebackError) Frames() []Frame { return errorFrames(e.err) }
+
+// withTraceback returns err as a TracebackError if it was caused by executing
+// Starlark code, and otherwise returns err unchanged.
+func withTraceback(err error) error {
+	var tbErr TracebackError
+	if errors.As(err, &tbErr) {
+		return err
+	}
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return err
+	}
+	return &tracebackError{err}
+}
+
+// errorFrames returns the frames of the innermost *starlark.EvalError wrapped
+// by err. Modules are loaded on the same thread, so its call stack includes the
+// frames of any load() statements leading to it.
+func errorFrames(err error) []Frame {
+	var stack starlark.CallStack
+	for err != nil {
+		var evalErr *starlark.EvalError
+		if !errors.As(err, &evalErr) {
+			break
+		}
+		stack = evalErr.CallStack
+		err = evalErr.Unwrap()
+	}
+	var frames []Frame
+	for _, frame := range stack {
+		frames = append(frames, Frame{
+			Function: frame.Name,
+			Position: frame.Pos,
+		})
+	}
+	return frames
+}
+
+// limitExecution enforces the execution limits of opts on the thread. The
+// returned function stops enforcing them, and must be called with the error
+// returned by execution so an error caused by a limit becomes an
+// *ExecutionLimitError.
+func (opts *commonOptions) limitExecution(thread *starlark.Thread) (finish func(err error) error) {
+	if opts.maxSteps > 0 {
+		thread.SetMaxExecutionSteps(opts.maxSteps)
+	}
+	var timedOut int32
+	var timer *time.Timer
+	if opts.timeout > 0 {
+		timer = time.AfterFunc(opts.timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			thread.Cancel(fmt.Sprintf("timeout of %s exceeded", opts.timeout))
+		})
+	}
+	var overAllocs int32
+	var stopAllocCheck chan struct{}
+	if opts.maxAllocs > 0 {
+		stopAllocCheck = make(chan struct{})
+		start := heapAllocs()
+		go func() {
+			ticker := time.NewTicker(allocCheckInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if heapAllocs()-start > opts.maxAllocs {
+						atomic.StoreInt32(&overAllocs, 1)
+						thread.Cancel(fmt.Sprintf("allocation limit of %d bytes exceeded", opts.maxAllocs))
+						return
+					}
+				case <-stopAllocCheck:
+					return
+				}
+			}
+		}()
+	}
+	return func(err error) error {
+		if timer != nil {
+			timer.Stop()
+		}
+		if stopAllocCheck != nil {
+			close(stopAllocCheck)
+		}
+		if err == nil {
+			return nil
+		}
+		var limit string
+		if atomic.LoadInt32(&timedOut) == 1 {
+			limit = opts.timeout.String()
+		} else if atomic.LoadInt32(&overAllocs) == 1 {
+			limit = fmt.Sprintf("%d bytes allocated", opts.maxAllocs)
+		} else if opts.maxSteps > 0 && thread.ExecutionSteps() >= opts.maxSteps {
+			limit = fmt.Sprintf("%d steps", opts.maxSteps)
+		} else {
+			return err
+		}
+		return &ExecutionLimitError{
+			Limit:    limit,
+			Position: failurePosition(err),
+			Err:      err,
+		}
+	}
+}
+
 // A LoadOption adjusts details of how Skycfg configs are loaded.
 type LoadOption interface {
 	applyLoad(*loadOptions)
@@ -159,9 +527,23 @@ type LoadOption interface {
 
 type loadOptions struct {
 	commonOptions
-	globals       starlark.StringDict
-	fileReader    FileReader
-	protoRegistry unstableProtoRegistryV2
+	globals          starlark.StringDict
+	modules          starlark.StringDict
+	fileGlobals      map[string]starlark.StringDict
+	env              map[string]string
+	fileReader       FileReader
+	moduleCache      ModuleCache
+	protoRegistries  []unstableProtoRegistryV2
+	customRegistry   bool
+	deterministic    bool
+	nondeterministic map[*starlark.Builtin]string
+	strictFieldTypes bool
+	disabledBuiltins []string
+	lazyGlobals      func(name string) (starlark.Value, bool, error)
+	lazyMisses       map[string]bool
+
+	// The first error returned by an option, reported by Load.
+	err error
 }
 
 type fnLoadOption func(*loadOptions)
@@ -169,7 +551,8 @@ type fnLoadOption func(*loadOptions)
 func (fn fnLoadOption) applyLoad(opts *loadOptions) { fn(opts) }
 
 // WithGlobals adds additional global symbols to the Starlark environment
-// when loading a Skycfg config.
+// when loading a Skycfg config. The values are frozen by Load, including the
+// members of modules, so they must be complete before Load is called.
 func WithGlobals(globals starlark.StringDict) LoadOption {
 	return fnLoadOption(func(opts *loadOptions) {
 		for key, value := range globals {
@@ -178,6 +561,55 @@ func WithGlobals(globals starlark.StringDict) LoadOption {
 	})
 }
 
+// WithModules adds modules that Skycfg configs can refer to by name, like the
+// built-in modules such as proto and yaml, for embedders that provide their
+// own builtins.
+//
+// Unlike WithGlobals, which replaces a built-in global of the same name,
+// Load returns an error if a module has the name of a built-in global, such
+// as proto or struct, or of a module from an earlier WithModules.
+func WithModules(modules starlark.StringDict) LoadOption {
+	return fnLoadOption(func(opts *loadOptions) {
+		for _, name := range modules.Keys() {
+			if opts.modules.Has(name) {
+				if opts.err == nil {
+					opts.err = fmt.Errorf("WithModules: module %q is already registered", name)
+				}
+				return
+			}
+			opts.modules[name] = modules[name]
+		}
+	})
+}
+
+// WithEntryPointGlobals adds global symbols that apply only when loading the
+// config with the given filename, as passed to Load(). They're visible to
+// every module loaded by that config, and take precedence over globals with
+// the same name from WithGlobals(), so a single set of options can be shared
+// between configs that need different globals.
+func WithEntryPointGlobals(filename string, globals starlark.StringDict) LoadOption {
+	return fnLoadOption(func(opts *loadOptions) {
+		if opts.fileGlobals[filename] == nil {
+			opts.fileGlobals[filename] = starlark.StringDict{}
+		}
+		for key, value := range globals {
+			opts.fileGlobals[filename][key] = value
+		}
+	})
+}
+
+// WithEnv sets the environment variables that a Skycfg config can read with
+// the env module. Configs can't read the environment of the process, so only
+// variables passed to WithEnv are visible. If WithEnv is passed more than
+// once, later values replace earlier ones with the same key.
+func WithEnv(env map[string]string) LoadOption {
+	return fnLoadOption(func(opts *loadOptions) {
+		for key, value := range env {
+			opts.env[key] = value
+		}
+	})
+}
+
 // WithFileReader changes the implementation of load() when loading a
 // Skycfg config.
 func WithFileReader(r FileReader) LoadOption {
@@ -189,6 +621,260 @@ func WithFileReader(r FileReader) LoadOption {
 	})
 }
 
+// A ModuleCache stores the globals of modules executed by load(), so that
+// modules shared between configs are executed once rather than once per call
+// to Load(). Implementations must be safe for concurrent use.
+type ModuleCache interface {
+	// Get returns the module stored for a key, or (_, false) if the module
+	// is not in the cache.
+	Get(key ModuleCacheKey) (*CachedModule, bool)
+
+	// Put stores a module that was executed successfully.
+	Put(key ModuleCacheKey, module *CachedModule)
+}
+
+// A ModuleCacheKey identifies a module by its resolved path and the SHA-256
+// hash of its content.
+type ModuleCacheKey struct {
+	Path        string
+	ContentHash [sha256.Size]byte
+}
+
+// A CachedModule is the result of executing a module.
+type CachedModule struct {
+	// Globals are the frozen globals of the module, and must not be modified.
+	Globals starlark.StringDict
+
+	// Deps are the keys of the modules loaded by this module. A cached
+	// module is not used if any of these has changed.
+	Deps []ModuleCacheKey
+
+	// Files are the keys of the other files read by this module, such as by
+	// yaml.decode_file(). A cached module is not used if any of these has
+	// changed.
+	Files []ModuleCacheKey
+}
+
+type moduleCache struct {
+	mu      sync.RWMutex
+	modules map[ModuleCacheKey]*CachedModule
+}
+
+// NewModuleCache returns an in-memory ModuleCache that is safe for
+// concurrent use.
+func NewModuleCache() ModuleCache {
+	return &moduleCache{modules: make(map[ModuleCacheKey]*CachedModule)}
+}
+
+func (c *moduleCache) Get(key ModuleCacheKey) (*CachedModule, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	module, ok := c.modules[key]
+	return module, ok
+}
+
+func (c *moduleCache) Put(key ModuleCacheKey, module *CachedModule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modules[key] = module
+}
+
+// WithModuleCache stores and reuses the globals of modules executed by
+// load() when loading a Skycfg config.
+//
+// Modules are still resolved and read through the FileReader, as are the files
+// they decoded with yaml.decode_file(), but a module found in the cache whose
+// modules and files are unchanged is not executed again, so any print() calls
+// it makes at the top level are not repeated. A cache should only be shared
+// between calls to Load() with the same globals and Protobuf registry.
+func WithModuleCache(cache ModuleCache) LoadOption {
+	if cache == nil {
+		panic("WithModuleCache: nil cache")
+	}
+	return fnLoadOption(func(opts *loadOptions) {
+		opts.moduleCache = cache
+	})
+}
+
+// WithDeterministic controls whether builtins with results that don't depend
+// on the config's files are disabled when loading a Skycfg config. If
+// enabled, calling one of these builtins is an error, so the config's output
+// depends only on the files it reads.
+//
+// The disabled builtins are:
+//
+//  env.get
+//  env.keys
+//  time.now
+//
+// The env builtins read the variables set with WithEnv(), and time.now is the
+// builtin of go.starlark.net/lib/time, which isn't a global unless added with
+// WithGlobals() or WithLazyGlobals(). Builtins are matched by identity rather
+// than by name, so they are disabled under whatever name they are given,
+// whether as a global or as a member of a global module.
+func WithDeterministic(deterministic bool) LoadOption {
+	return fnLoadOption(func(opts *loadOptions) {
+		opts.deterministic = deterministic
+	})
+}
+
+// WithStrictFieldTypes controls whether messages constructed by a Skycfg
+// config check field types strictly. By default an int may be assigned to a
+// float or double field, or to a FloatValue or DoubleValue wrapper, and a
+// number of seconds to a Duration field, and is converted implicitly. If
+// enabled, such an assignment is an error naming the field's type and the
+// assigned value's type. These are the only conversions it disables: a plain
+// value may still be assigned to the wrapper of its own type, and a string
+// such as "1m30s" to a Duration field.
+//
+// This applies to messages constructed by the message types of the "proto"
+// global, including messages decoded with its functions, such as
+// proto.decode_json().
+func WithStrictFieldTypes(strict bool) LoadOption {
+	return fnLoadOption(func(opts *loadOptions) {
+		opts.strictFieldTypes = strict
+	})
+}
+
+// nondeterministicBuiltins returns the builtins disabled by
+// WithDeterministic(), with the names their errors are reported by.
+func nondeterministicBuiltins(env *starlarkstruct.Module) map[*starlark.Builtin]string {
+	builtins := make(map[*starlark.Builtin]string)
+	if now, ok := starlarktime.Module.Members["now"].(*starlark.Builtin); ok {
+		builtins[now] = "time.now"
+	}
+	for _, value := range env.Members {
+		if builtin, ok := value.(*starlark.Builtin); ok {
+			builtins[builtin] = builtin.Name()
+		}
+	}
+	return builtins
+}
+
+// disableNondeterministicBuiltins returns the value of a global with any of
+// the given builtins replaced by builtins that fail, whether the global is
+// one of them or a module with them as members.
+func disableNondeterministicBuiltins(builtins map[*starlark.Builtin]string, value starlark.Value) starlark.Value {
+	const reason = "not allowed in deterministic mode"
+	switch value := value.(type) {
+	case *starlark.Builtin:
+		if name, ok := builtins[value]; ok {
+			return disabledBuiltin(name, reason)
+		}
+	case *starlarkstruct.Module:
+		var members starlark.StringDict
+		for key, member := range value.Members {
+			builtin, ok := member.(*starlark.Builtin)
+			if !ok {
+				continue
+			}
+			if name, ok := builtins[builtin]; ok {
+				if members == nil {
+					members = make(starlark.StringDict, len(value.Members))
+					for key, member := range value.Members {
+						members[key] = member
+					}
+				}
+				members[key] = disabledBuiltin(name, reason)
+			}
+		}
+		if members != nil {
+			return &starlarkstruct.Module{
+				Name:    value.Name,
+				Members: members,
+			}
+		}
+	}
+	return value
+}
+
+// disableModuleMembers returns a copy of a module global with the named
+// members replaced by builtins that fail with the given reason. Values other
+// than modules are returned unchanged.
+func disableModuleMembers(moduleName string, value starlark.Value, memberNames []string, reason string) starlark.Value {
+	module, ok := value.(*starlarkstruct.Module)
+	if !ok {
+		return value
+	}
+	members := make(starlark.StringDict, len(module.Members))
+	for key, value := range module.Members {
+		members[key] = value
+	}
+	for _, memberName := range memberNames {
+		if _, ok := members[memberName]; !ok {
+			continue
+		}
+		members[memberName] = disabledBuiltin(moduleName+"."+memberName, reason)
+	}
+	return &starlarkstruct.Module{
+		Name:    module.Name,
+		Members: members,
+	}
+}
+
+// disabledBuiltin returns a builtin that fails with the given reason.
+func disabledBuiltin(name, reason string) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return nil, fmt.Errorf("%s: %s", name, reason)
+	})
+}
+
+// WithDisabledBuiltins disables global builtins when loading a Skycfg config,
+// such as to forbid modules that untrusted configs shouldn't use. Calling a
+// disabled builtin is an error.
+//
+// Each name is either a global, such as "hash", or a member of a global
+// module, such as "proto.decode_any". Disabling a module disables all of its
+// members. Load returns an error if a name isn't a global or module member,
+// including globals added with WithGlobals() but not WithLazyGlobals().
+func WithDisabledBuiltins(names []string) LoadOption {
+	return fnLoadOption(func(opts *loadOptions) {
+		opts.disabledBuiltins = append(opts.disabledBuiltins, names...)
+	})
+}
+
+// disableBuiltins replaces the named builtins in globals, as described by
+// WithDisabledBuiltins.
+func disableBuiltins(globals starlark.StringDict, names []string) error {
+	const reason = "disabled"
+	for _, name := range names {
+		globalName, memberName := name, ""
+		if dot := strings.IndexByte(name, '.'); dot >= 0 {
+			globalName, memberName = name[:dot], name[dot+1:]
+		}
+		value, ok := globals[globalName]
+		if !ok {
+			return fmt.Errorf("WithDisabledBuiltins: unknown builtin %q", name)
+		}
+		module, isModule := value.(*starlarkstruct.Module)
+		switch {
+		case memberName == "" && isModule:
+			globals[globalName] = disableModuleMembers(globalName, module, module.Members.Keys(), reason)
+		case memberName == "":
+			globals[globalName] = disabledBuiltin(globalName, reason)
+		case isModule && module.Members.Has(memberName):
+			globals[globalName] = disableModuleMembers(globalName, module, []string{memberName}, reason)
+		default:
+			return fmt.Errorf("WithDisabledBuiltins: unknown builtin %q", name)
+		}
+	}
+	return nil
+}
+
+// WithLazyGlobals adds global symbols to the Starlark environment that are
+// only constructed if a module refers to them, when loading a Skycfg config.
+//
+// The lookup function is called at most once for each name that a module
+// refers to but does not define, and that is not a global set by
+// WithGlobals(). It returns (_, false, nil) if the name is not a global, in
+// which case the usual undefined name error is reported. Constructed values
+// are frozen like those of WithGlobals().
+func WithLazyGlobals(lookup func(name string) (starlark.Value, bool, error)) LoadOption {
+	return fnLoadOption(func(opts *loadOptions) {
+		opts.lazyGlobals = lookup
+	})
+}
+
 type unstableProtoRegistryV2 interface {
 	// UNSTABLE go-protobuf v2 type registry
 	UnstableProtobufTypes() *protoregistry.Types
@@ -210,15 +896,187 @@ func NewUnstableProtobufRegistryV2(r *protoregistry.Types) unstableProtoRegistry
 
 // WithProtoRegistry is an EXPERIMENTAL and UNSTABLE option to override
 // how Protobuf message type names are mapped to Go types.
+//
+// It may be given more than once, in which case types are looked up in each
+// registry in the order the options were given. If the same type name is
+// registered in more than one registry, the first registry's type is used.
 func WithProtoRegistry(r unstableProtoRegistryV2) LoadOption {
 	if r == nil {
 		panic("WithProtoRegistry: nil registry")
 	}
 	return fnLoadOption(func(opts *loadOptions) {
-		opts.protoRegistry = r
+		opts.protoRegistries = append(opts.protoRegistries, r)
+		opts.customRegistry = true
+	})
+}
+
+// WithFileDescriptorSet adds the message, enum, and extension types defined
+// in a serialized google.protobuf.FileDescriptorSet, so they can be used
+// without generated Go code. Messages of these types are *dynamicpb.Message.
+//
+// Imports between files in the set are resolved within the set, falling back
+// to files linked into the binary, such as the well-known types. Types are
+// looked up in the same order as registries given with WithProtoRegistry.
+// Unless WithProtoRegistry is also given, the standard proto registry is
+// searched after every descriptor set.
+//
+// Load returns an error if the set can't be decoded or its files don't
+// resolve.
+func WithFileDescriptorSet(data []byte) LoadOption {
+	types, err := newDescriptorSetTypes(data)
+	return fnLoadOption(func(opts *loadOptions) {
+		if err != nil {
+			if opts.err == nil {
+				opts.err = fmt.Errorf("WithFileDescriptorSet: %w", err)
+			}
+			return
+		}
+		opts.protoRegistries = append(opts.protoRegistries, NewUnstableProtobufRegistryV2(types))
 	})
 }
 
+// newDescriptorSetTypes returns a registry of dynamic types for the files in
+// a serialized FileDescriptorSet.
+func newDescriptorSetTypes(data []byte) (*protoregistry.Types, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	protos := make(map[string]*descriptorpb.FileDescriptorProto, len(set.GetFile()))
+	for _, fd := range set.GetFile() {
+		protos[fd.GetName()] = fd
+	}
+
+	files := &protoregistry.Files{}
+	resolver := &descriptorSetResolver{files}
+	inProgress := map[string]bool{}
+
+	// Files are registered after their imports, so that protodesc can
+	// resolve references to the imported types.
+	var register func(path, importedBy string) error
+	register = func(path, importedBy string) error {
+		if _, err := files.FindFileByPath(path); err == nil {
+			return nil
+		}
+		fd, ok := protos[path]
+		if !ok {
+			if _, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+				return nil
+			}
+			return fmt.Errorf("file %q imported by %q not found", path, importedBy)
+		}
+		if inProgress[path] {
+			return fmt.Errorf("import cycle in %q", path)
+		}
+		inProgress[path] = true
+		for _, dep := range fd.GetDependency() {
+			if err := register(dep, path); err != nil {
+				return err
+			}
+		}
+		file, err := protodesc.NewFile(fd, resolver)
+		if err != nil {
+			return err
+		}
+		return files.RegisterFile(file)
+	}
+	for _, fd := range set.GetFile() {
+		if err := register(fd.GetName(), ""); err != nil {
+			return nil, err
+		}
+	}
+
+	types := &protoregistry.Types{}
+	var rangeErr error
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		rangeErr = registerDynamicTypes(types, file.Messages(), file.Enums(), file.Extensions())
+		return rangeErr == nil
+	})
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+	return types, nil
+}
+
+func registerDynamicTypes(
+	types *protoregistry.Types,
+	messages protoreflect.MessageDescriptors,
+	enums protoreflect.EnumDescriptors,
+	extensions protoreflect.ExtensionDescriptors,
+) error {
+	for i := 0; i < enums.Len(); i++ {
+		if err := types.RegisterEnum(dynamicpb.NewEnumType(enums.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < extensions.Len(); i++ {
+		if err := types.RegisterExtension(dynamicpb.NewExtensionType(extensions.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < messages.Len(); i++ {
+		msg := messages.Get(i)
+		if err := types.RegisterMessage(dynamicpb.NewMessageType(msg)); err != nil {
+			return err
+		}
+		if err := registerDynamicTypes(types, msg.Messages(), msg.Enums(), msg.Extensions()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// descriptorSetResolver resolves imports of a FileDescriptorSet's files to
+// the files already built from the set, or to files linked into the binary.
+type descriptorSetResolver struct {
+	files *protoregistry.Files
+}
+
+func (r *descriptorSetResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r *descriptorSetResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// mergeProtoRegistries returns a registry containing the types of every
+// registry in regs. A name registered in more than one of them resolves to
+// the type in the earliest registry.
+func mergeProtoRegistries(regs []unstableProtoRegistryV2) unstableProtoRegistryV2 {
+	switch len(regs) {
+	case 0:
+		return nil
+	case 1:
+		return regs[0]
+	}
+	merged := &protoregistry.Types{}
+	for _, r := range regs {
+		types := r.UnstableProtobufTypes()
+		// Registration fails for names that are already registered,
+		// which is what gives earlier registries precedence.
+		types.RangeEnums(func(t protoreflect.EnumType) bool {
+			_ = merged.RegisterEnum(t)
+			return true
+		})
+		types.RangeMessages(func(t protoreflect.MessageType) bool {
+			_ = merged.RegisterMessage(t)
+			return true
+		})
+		types.RangeExtensions(func(t protoreflect.ExtensionType) bool {
+			_ = merged.RegisterExtension(t)
+			return true
+		})
+	}
+	return NewUnstableProtobufRegistryV2(merged)
+}
+
 // UnstablePredeclaredModules returns a Starlark string dictionary with
 // predeclared Skycfg modules which can be used in starlark.ExecFile.
 //
@@ -226,35 +1084,38 @@ func WithProtoRegistry(r unstableProtoRegistryV2) LoadOption {
 // registry).
 //
 // Currently provides these modules (see REAMDE for more detailed description):
+//   - base64 - encodes and decodes base64 data.
 //   - fail   - interrupts execution and prints a stacktrace.
 //   - hash   - supports md5, sha1 and sha245 functions.
-//   - json   - marshals plain values (dicts, lists, etc) to JSON.
+//   - hcl    - marshals plain values (dicts, lists, etc) to HCL.
+//   - json   - marshals plain values (dicts, lists, etc) and messages to JSON.
 //   - proto  - package for constructing Protobuf messages.
+//   - re     - matches and substitutes regular expressions.
 //   - struct - experimental Starlark struct support.
+//   - toml   - same as "json" package but for TOML.
 //   - yaml   - same as "json" package but for YAML.
-//   - url    - utility package for encoding URL query string.
+//   - url    - utility package for encoding and parsing URL query strings.
 func UnstablePredeclaredModules(r unstableProtoRegistryV2) starlark.StringDict {
 	return starlark.StringDict{
-		"fail":   assertmodule.Fail,
-		"hash":   hashmodule.NewModule(),
-		"json":   newJsonModule(),
-		"proto":  UnstableProtoModule(r),
-		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
-		"yaml":   newYamlModule(),
-		"url":    urlmodule.NewModule(),
+		"base64":        base64module.NewModule(),
+		"dicts":         dictsmodule.NewModule(),
+		"fail":          assertmodule.Fail,
+		"fmt":           fmtmodule.NewModule(),
+		"hash":          hashmodule.NewModule(),
+		"hcl":           hclmodule.NewModule(),
+		"json":          newJsonModule(),
+		"load_optional": starlark.NewBuiltin("load_optional", loadOptional),
+		"proto":         UnstableProtoModule(r),
+		"re":            regexmodule.NewModule(),
+		"struct":        starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"toml":          tomlmodule.NewModule(),
+		"yaml":          newYamlModule(),
+		"url":           urlmodule.NewModule(),
 	}
 }
 
 func newJsonModule() starlark.Value {
-	// Copy starlarkjson and add compatibility fields
-	starlarjsonModule := starlarkjson.Module
-	module := &starlarkstruct.Module{
-		Name:    starlarjsonModule.Name,
-		Members: make(starlark.StringDict),
-	}
-	for k, v := range starlarjsonModule.Members {
-		module.Members[k] = v
-	}
+	module := jsonmodule.NewModule()
 
 	// Aliases for compatibility with pre-v1.0 Skycfg API.
 	module.Members["marshal"] = module.Members["encode"]
@@ -274,12 +1135,21 @@ func newYamlModule() starlark.Value {
 }
 
 func UnstableProtoModule(r unstableProtoRegistryV2) starlark.Value {
+	return newProtoModule(r, false)
+}
+
+func newProtoModule(r unstableProtoRegistryV2, strict bool) starlark.Value {
 	protoTypes := protoregistry.GlobalTypes
 	if r != nil {
 		protoTypes = r.UnstableProtobufTypes()
 	}
 
-	protoModule := protomodule.NewModule(protoTypes)
+	var protoModule *starlarkstruct.Module
+	if strict {
+		protoModule = protomodule.NewStrictModule(protoTypes)
+	} else {
+		protoModule = protomodule.NewModule(protoTypes)
+	}
 
 	// Compatibility aliases
 	protoModule.Members["from_json"] = protoModule.Members["decode_json"]
@@ -293,87 +1163,444 @@ func UnstableProtoModule(r unstableProtoRegistryV2) starlark.Value {
 
 // Load reads a Skycfg config file from the filesystem.
 func Load(ctx context.Context, filename string, opts ...LoadOption) (*Config, error) {
+	parsedOpts, err := newLoadOptions(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	configLocals, tests, deps, err := loadImpl(ctx, parsedOpts, filename)
+	if err != nil {
+		return nil, withTraceback(err)
+	}
+	return &Config{
+		filename:     filename,
+		globals:      parsedOpts.globals,
+		locals:       configLocals,
+		tests:        tests,
+		dependencies: deps,
+		fileReader:   parsedOpts.fileReader,
+	}, nil
+}
+
+// newLoadOptions applies the options of Load, returning the options with
+// the globals of a config loaded from filename.
+func newLoadOptions(filename string, opts []LoadOption) (*loadOptions, error) {
 	parsedOpts := &loadOptions{
-		globals:    starlark.StringDict{},
-		fileReader: LocalFileReader(filepath.Dir(filename)),
+		globals:     starlark.StringDict{},
+		modules:     starlark.StringDict{},
+		fileGlobals: map[string]starlark.StringDict{},
+		env:         map[string]string{},
+		fileReader:  LocalFileReader(filepath.Dir(filename)),
+		lazyMisses:  map[string]bool{},
 	}
 	for _, opt := range opts {
 		opt.applyLoad(parsedOpts)
 	}
 
+	if parsedOpts.err != nil {
+		return nil, parsedOpts.err
+	}
+
+	registries := parsedOpts.protoRegistries
+	if len(registries) > 0 && !parsedOpts.customRegistry {
+		registries = append(registries, NewUnstableProtobufRegistryV2(protoregistry.GlobalTypes))
+	}
 	overriddenGlobals := parsedOpts.globals
-	parsedOpts.globals = UnstablePredeclaredModules(parsedOpts.protoRegistry)
+	protoRegistry := mergeProtoRegistries(registries)
+	parsedOpts.globals = UnstablePredeclaredModules(protoRegistry)
+	if parsedOpts.strictFieldTypes {
+		parsedOpts.globals["proto"] = newProtoModule(protoRegistry, true)
+	}
+	env := envmodule.NewModule(parsedOpts.env)
+	parsedOpts.globals["env"] = env
+	for _, name := range parsedOpts.modules.Keys() {
+		if parsedOpts.globals.Has(name) {
+			return nil, fmt.Errorf("WithModules: module %q conflicts with a built-in global", name)
+		}
+		parsedOpts.globals[name] = parsedOpts.modules[name]
+	}
 	for key, value := range overriddenGlobals {
 		parsedOpts.globals[key] = value
 	}
-	configLocals, tests, err := loadImpl(ctx, parsedOpts, filename)
+	for key, value := range parsedOpts.fileGlobals[filename] {
+		parsedOpts.globals[key] = value
+	}
+	if parsedOpts.deterministic {
+		parsedOpts.nondeterministic = nondeterministicBuiltins(env)
+		for key, value := range parsedOpts.globals {
+			parsedOpts.globals[key] = disableNondeterministicBuiltins(parsedOpts.nondeterministic, value)
+		}
+	}
+	if err := disableBuiltins(parsedOpts.globals, parsedOpts.disabledBuiltins); err != nil {
+		return nil, err
+	}
+	// Configs may not modify globals, which are shared by every execution
+	// of the config and by any other configs loaded with them. Values such
+	// as messages also mutate themselves when their unset fields are read.
+	parsedOpts.globals.Freeze()
+	return parsedOpts, nil
+}
+
+// A ParsedConfig is a Skycfg config file that has been parsed and resolved,
+// but not executed, such as for static checks of a config before Load().
+type ParsedConfig struct {
+	filename string
+	file     *syntax.File
+}
+
+// Parse reads and parses a Skycfg config file without executing it. Names are
+// resolved against the globals that Load() would provide with the same
+// options, so referring to an undefined name is an error. Modules named by
+// load() statements are not read.
+func Parse(ctx context.Context, filename string, opts ...LoadOption) (*ParsedConfig, error) {
+	parsedOpts, err := newLoadOptions(filename, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &Config{
+	modulePath, err := parsedOpts.fileReader.Resolve(ctx, filename, "")
+	if err != nil {
+		return nil, &LoadError{Chain: []string{filename}, Err: err}
+	}
+	src, err := parsedOpts.fileReader.ReadFile(ctx, modulePath)
+	if err != nil {
+		return nil, &LoadError{Chain: []string{modulePath}, Err: err}
+	}
+	file, _, err := parseModule(parsedOpts, modulePath, src)
+	if err != nil {
+		return nil, err
+	}
+	return &ParsedConfig{
 		filename: filename,
-		globals:  parsedOpts.globals,
-		locals:   configLocals,
-		tests:    tests,
+		file:     file,
 	}, nil
 }
 
-func loadImpl(ctx context.Context, opts *loadOptions, filename string) (starlark.StringDict, []*Test, error) {
+// Filename returns the original filename passed to Parse().
+func (c *ParsedConfig) Filename() string {
+	return c.filename
+}
+
+// Syntax returns the syntax tree of the config file. Each identifier's
+// Binding is a *resolve.Binding naming its scope.
+func (c *ParsedConfig) Syntax() *syntax.File {
+	return c.file
+}
+
+// GlobalNames returns the names of the global variables defined by the
+// config file, in the order they are first defined.
+func (c *ParsedConfig) GlobalNames() []string {
+	module := c.file.Module.(*resolve.Module)
+	names := make([]string, 0, len(module.Globals))
+	for _, binding := range module.Globals {
+		names = append(names, binding.First.Name)
+	}
+	return names
+}
+
+// PredeclaredNames returns the sorted names of the predeclared globals and
+// builtins that the config file refers to, such as "proto" or "len".
+func (c *ParsedConfig) PredeclaredNames() []string {
+	seen := make(map[string]bool)
+	syntax.Walk(c.file, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok {
+			if binding, ok := id.Binding.(*resolve.Binding); ok {
+				if binding.Scope == resolve.Predeclared || binding.Scope == resolve.Universal {
+					seen[id.Name] = true
+				}
+			}
+		}
+		return true
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadImpl(ctx context.Context, opts *loadOptions, filename string) (starlark.StringDict, []*Test, []string, error) {
 	reader := opts.fileReader
 
 	type cacheEntry struct {
 		globals starlark.StringDict
+		key     ModuleCacheKey
 		err     error
+
+		// Whether the globals were taken from the ModuleCache.
+		cached bool
 	}
 	cache := make(map[string]*cacheEntry)
 	tests := []*Test{}
-
-	load := func(thread *starlark.Thread, moduleName string) (starlark.StringDict, error) {
-		var fromPath string
-		if thread.CallStackDepth() > 0 {
-			fromPath = thread.CallFrame(0).Pos.Filename()
+	var readPaths []string
+	seenPaths := make(map[string]bool)
+	recordRead := func(path string) {
+		if !seenPaths[path] {
+			seenPaths[path] = true
+			readPaths = append(readPaths, path)
 		}
-		modulePath, err := reader.Resolve(ctx, moduleName, fromPath)
-		if err != nil {
-			return nil, err
+	}
+
+	// The paths of modules currently being loaded, outermost first, and the
+	// modules and other files each of them has read so far.
+	var stack []string
+	var deps [][]ModuleCacheKey
+	var files [][]ModuleCacheKey
+	fileReader := &recordingFileReader{reader, func(path string, content []byte) {
+		recordRead(path)
+		if len(files) > 0 {
+			files[len(files)-1] = append(files[len(files)-1], ModuleCacheKey{path, sha256.Sum256(content)})
 		}
+	}}
+	loadError := func(path string, err error) error {
+		chain := make([]string, len(stack), len(stack)+1)
+		copy(chain, stack)
+		return &LoadError{Chain: append(chain, path), Err: err}
+	}
 
+	var loadPath func(thread *starlark.Thread, modulePath string) *cacheEntry
+	loadPath = func(thread *starlark.Thread, modulePath string) *cacheEntry {
 		e, ok := cache[modulePath]
 		if e != nil {
-			return e.globals, e.err
+			return e
 		}
 		if ok {
-			return nil, fmt.Errorf("cycle in load graph")
+			return &cacheEntry{err: loadError(modulePath, fmt.Errorf("cycle in load graph"))}
 		}
 		moduleSource, err := reader.ReadFile(ctx, modulePath)
 		if err != nil {
-			cache[modulePath] = &cacheEntry{nil, err}
-			return nil, err
+			e = &cacheEntry{err: loadError(modulePath, err)}
+			cache[modulePath] = e
+			return e
 		}
+		recordRead(modulePath)
 
+		key := ModuleCacheKey{modulePath, sha256.Sum256(moduleSource)}
 		cache[modulePath] = nil
-		globals, err := starlark.ExecFile(thread, modulePath, moduleSource, opts.globals)
-		cache[modulePath] = &cacheEntry{globals, err}
+		stack = append(stack, modulePath)
+		deps = append(deps, nil)
+		files = append(files, nil)
+
+		// A cached module is only used if every module it loaded is
+		// unchanged, which is checked by loading them again, and every
+		// other file it read is unchanged, which is checked by reading them
+		// again. A module that was executed again has new globals even if
+		// its content is the same, such as when a module it loads has
+		// changed.
+		var globals starlark.StringDict
+		cached := false
+		if opts.moduleCache != nil {
+			if m, ok := opts.moduleCache.Get(key); ok {
+				globals, cached = m.Globals, true
+				for _, dep := range m.Deps {
+					if e := loadPath(thread, dep.Path); e.err != nil || e.key != dep || !e.cached {
+						cached = false
+						break
+					}
+				}
+				for _, file := range m.Files {
+					if !cached {
+						break
+					}
+					if content, err := fileReader.ReadFile(ctx, file.Path); err != nil || sha256.Sum256(content) != file.ContentHash {
+						cached = false
+					}
+				}
+			}
+		}
+		if !cached {
+			files[len(files)-1] = nil
+			globals, err = execModule(thread, opts, modulePath, moduleSource)
+			if err == nil && opts.moduleCache != nil {
+				opts.moduleCache.Put(key, &CachedModule{
+					Globals: globals,
+					Deps:    deps[len(deps)-1],
+					Files:   files[len(files)-1],
+				})
+			}
+		}
+		stack = stack[:len(stack)-1]
+		deps = deps[:len(deps)-1]
+		files = files[:len(files)-1]
+		e = &cacheEntry{globals, key, err, cached}
+		cache[modulePath] = e
 
-		for name, val := range globals {
+		for _, name := range globals.Keys() {
 			if !strings.HasPrefix(name, "test_") {
 				continue
 			}
-			if fn, ok := val.(starlark.Callable); ok {
+			if fn, ok := globals[name].(starlark.Callable); ok {
 				tests = append(tests, &Test{
-					callable: fn,
+					callable:   fn,
+					fileReader: reader,
 				})
 			}
 		}
+		return e
+	}
+
+	loadModule := func(thread *starlark.Thread, moduleName, fromPath string, optional bool) (starlark.StringDict, bool, error) {
+		modulePath, err := reader.Resolve(ctx, moduleName, fromPath)
+		if err != nil {
+			return nil, false, loadError(moduleName, err)
+		}
+		e := loadPath(thread, modulePath)
+		// Only a failure to read the module itself makes it missing, not a
+		// failure within a module it loads.
+		if loadErr, ok := e.err.(*LoadError); ok && optional && errors.Is(loadErr.Err, os.ErrNotExist) {
+			// A cached module that found this module missing is executed
+			// again, in case the module has since been added.
+			if len(deps) > 0 {
+				deps[len(deps)-1] = append(deps[len(deps)-1], ModuleCacheKey{Path: modulePath})
+			}
+			return nil, false, nil
+		}
+		if e.err == nil && len(deps) > 0 {
+			deps[len(deps)-1] = append(deps[len(deps)-1], e.key)
+		}
+		return e.globals, e.err == nil, e.err
+	}
+	load := func(thread *starlark.Thread, moduleName string) (starlark.StringDict, error) {
+		var fromPath string
+		if thread.CallStackDepth() > 0 {
+			fromPath = thread.CallFrame(0).Pos.Filename()
+		}
+		globals, _, err := loadModule(thread, moduleName, fromPath, false)
 		return globals, err
 	}
 	thread := &starlark.Thread{
 		Print: skyPrint,
 		Load:  load,
 	}
+	thread.SetLocal(contextKey, ctx)
 	thread.SetLocal(logOutputKey, opts.logOutput)
+	thread.SetLocal(printHookKey, opts.printHook)
+	thread.SetLocal(loadOptionalKey, loadOptionalFunc(func(thread *starlark.Thread, moduleName, fromPath string) (starlark.StringDict, bool, error) {
+		return loadModule(thread, moduleName, fromPath, true)
+	}))
+	yamlmodule.SetFileReader(thread, ctx, fileReader)
+	defer cancelOnDone(ctx, thread)()
+	finish := opts.limitExecution(thread)
 	locals, err := load(thread, filename)
-	return locals, tests, err
+	err = finish(err)
+	// A failed nested load() is reported once per enclosing module; the
+	// LoadError at its root already names every module in the chain, and
+	// is given the call stack of those reports.
+	var loadErr *LoadError
+	if errors.As(err, &loadErr) {
+		loadErr.frames = errorFrames(err)
+		return nil, nil, nil, loadErr
+	}
+	return locals, tests, readPaths, err
+}
+
+// A loadOptionalFunc loads a module for load_optional(), returning
+// (nil, false, nil) if the module doesn't exist.
+type loadOptionalFunc func(thread *starlark.Thread, moduleName, fromPath string) (starlark.StringDict, bool, error)
+
+// loadOptional implements the load_optional() builtin. It loads a module like
+// the load() statement, but returns None if the module doesn't exist, and
+// otherwise a struct of the module's globals.
+func loadOptional(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var moduleName string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &moduleName); err != nil {
+		return nil, err
+	}
+	load, ok := thread.Local(loadOptionalKey).(loadOptionalFunc)
+	if !ok {
+		return nil, fmt.Errorf("%s: modules can only be loaded during Load()", fn.Name())
+	}
+	// The caller is the frame below this builtin's own.
+	var fromPath string
+	if thread.CallStackDepth() > 1 {
+		fromPath = thread.CallFrame(1).Pos.Filename()
+	}
+	globals, found, err := load(thread, moduleName, fromPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+	if !found {
+		return starlark.None, nil
+	}
+	return &starlarkstruct.Module{Name: moduleName, Members: globals}, nil
+}
+
+// execModule executes a module with the globals of a config, constructing any
+// lazy globals it refers to.
+func execModule(thread *starlark.Thread, opts *loadOptions, filename string, src []byte) (starlark.StringDict, error) {
+	_, prog, err := parseModule(opts, filename, src)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := prog.Init(thread, opts.globals)
+	globals.Freeze()
+	return globals, err
+}
+
+// parseModule parses and resolves a module with the globals of a config,
+// constructing any lazy globals it refers to.
+func parseModule(opts *loadOptions, filename string, src []byte) (*syntax.File, *starlark.Program, error) {
+	var lazyErr error
+	isPredeclared := func(name string) bool {
+		if opts.globals.Has(name) {
+			return true
+		}
+		if opts.lazyGlobals == nil || opts.lazyMisses[name] || lazyErr != nil {
+			return false
+		}
+		value, ok, err := opts.lazyGlobals(name)
+		if err != nil {
+			lazyErr = fmt.Errorf("%s: global %q: %v", filename, name, err)
+			return false
+		}
+		if !ok {
+			opts.lazyMisses[name] = true
+			return false
+		}
+		if opts.deterministic {
+			value = disableNondeterministicBuiltins(opts.nondeterministic, value)
+		}
+		value.Freeze()
+		opts.globals[name] = value
+		return true
+	}
+	file, prog, err := starlark.SourceProgram(filename, src, isPredeclared)
+	if lazyErr != nil {
+		return nil, nil, lazyErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, prog, nil
+}
+
+// A LoadError is returned by Load() when a module could not be resolved or
+// read, or when load() statements form a cycle.
+type LoadError struct {
+	// Chain is the path of each module in the sequence of load() statements
+	// leading to the failure, starting with the root module passed to Load().
+	// The last element is the module that failed to load, which is the name
+	// passed to load() if it could not be resolved. For a cycle, the last
+	// element is also found earlier in the chain.
+	Chain []string
+	Err   error
+
+	// The call stack of the load() statements leading to the failure.
+	frames []Frame
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("%s: %v", strings.Join(e.Chain, " -> "), e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// Frames returns the Starlark call stack of the failure, ending with the
+// load() statement of the module that failed to load. A failure to load the
+// root module passed to Load() has no frames.
+func (e *LoadError) Frames() []Frame {
+	return e.frames
 }
 
 // Filename returns the original filename passed to Load().
@@ -393,6 +1620,15 @@ func (c *Config) Locals() starlark.StringDict {
 	return c.locals
 }
 
+// Dependencies returns the path of each file read through the FileReader by
+// load() or yaml.decode_file() while loading the config, including the
+// top-level module, in the order they were first read. Paths are as returned
+// by FileReader.Resolve(). Files read later, such as by main(), aren't
+// included.
+func (c *Config) Dependencies() []string {
+	return c.dependencies
+}
+
 // An ExecOption adjusts details of how a Skycfg config's main function is
 // executed.
 type ExecOption interface {
@@ -401,9 +1637,11 @@ type ExecOption interface {
 
 type execOptions struct {
 	commonOptions
-	vars         *starlark.Dict
-	funcName     string
-	flattenLists bool
+	vars             *starlark.Dict
+	funcName         string
+	flattenLists     bool
+	validateRequired bool
+	validators       []func(proto.Message) error
 }
 
 type fnExecOption func(*execOptions)
@@ -411,6 +1649,11 @@ type fnExecOption func(*execOptions)
 func (fn fnExecOption) applyExec(opts *execOptions) { fn(opts) }
 
 // WithVars adds key:value pairs to the ctx.vars dict passed to main().
+//
+// Vars are set per execution, so a loaded Config may be executed repeatedly
+// with different vars. They are only visible through ctx.vars and never
+// shadow globals set with WithGlobals(). If WithVars is passed more than once,
+// later values replace earlier ones with the same key.
 func WithVars(vars starlark.StringDict) ExecOption {
 	return fnExecOption(func(opts *execOptions) {
 		for key, value := range vars {
@@ -433,9 +1676,120 @@ func WithFlattenLists() ExecOption {
 	})
 }
 
+// WithValidateRequired checks that every Protobuf message returned from the
+// entry point function has its proto2 required fields set, including within
+// nested messages. Messages with unset required fields are an error naming
+// each message's index in the output and the paths of its unset fields.
+func WithValidateRequired(validate bool) ExecOption {
+	return fnExecOption(func(opts *execOptions) {
+		opts.validateRequired = validate
+	})
+}
+
+// WithMessageValidator calls validate on every Protobuf message returned from
+// the entry point function, for checks that can't be expressed in the message
+// schema. The errors of all messages are collected into a single error, with
+// each naming the message's index in the output, and its output's name for
+// MainNamed().
+//
+// If WithMessageValidator is passed more than once, all validators are called
+// in order.
+func WithMessageValidator(validate func(proto.Message) error) ExecOption {
+	return fnExecOption(func(opts *execOptions) {
+		opts.validators = append(opts.validators, validate)
+	})
+}
+
 // Main executes main() or a custom entry point function from the top-level Skycfg config
 // module, which is expected to return either None or a list of Protobuf messages.
+//
+// The function may also return a dict of named outputs, as for MainNamed(), in
+// which case the messages of every output are returned in the dict's order.
 func (c *Config) Main(ctx context.Context, opts ...ExecOption) ([]proto.Message, error) {
+	values, err := c.mainValues(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []proto.Message
+	for _, value := range values {
+		msg, _ := AsProtoMessage(value)
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// A MessageWithSource is a Protobuf message returned from a Skycfg config's
+// main function, along with the position of the Starlark call that
+// constructed it.
+type MessageWithSource struct {
+	Message proto.Message
+
+	// Position is not valid if the message was not constructed by calling a
+	// message type from Starlark, for example if it was decoded from JSON.
+	Position syntax.Position
+}
+
+// MainWithSource is like Main, but also returns the position in the Starlark
+// source where each message was constructed.
+func (c *Config) MainWithSource(ctx context.Context, opts ...ExecOption) ([]MessageWithSource, error) {
+	values, err := c.mainValues(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []MessageWithSource
+	for _, value := range values {
+		msg, _ := AsProtoMessage(value)
+		msgs = append(msgs, MessageWithSource{
+			Message:  msg,
+			Position: protomodule.MessagePosition(value),
+		})
+	}
+	return msgs, nil
+}
+
+// MainNamed executes main() or a custom entry point function from the
+// top-level Skycfg config module, which is expected to return either None or
+// a dict mapping output names to lists of Protobuf messages.
+func (c *Config) MainNamed(ctx context.Context, opts ...ExecOption) (map[string][]proto.Message, error) {
+	mainVal, parsedOpts, err := c.callMain(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	mainDict, ok := mainVal.(*starlark.Dict)
+	if !ok {
+		if _, isNone := mainVal.(starlark.NoneType); isNone {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%q didn't return a dict (got a %s)", parsedOpts.funcName, mainVal.Type())
+	}
+	// Invalid messages are reported together for every output.
+	outputs := make(map[string][]proto.Message, mainDict.Len())
+	var invalid []string
+	for _, item := range mainDict.Items() {
+		list, err := parsedOpts.namedOutput(item)
+		if err != nil {
+			return nil, err
+		}
+		values, err := parsedOpts.messageValues(list)
+		if err != nil {
+			return nil, err
+		}
+		invalid = append(invalid, parsedOpts.invalidMessages(values, fmt.Sprintf(" of output %s", item[0]))...)
+		msgs := []proto.Message{}
+		for _, value := range values {
+			msg, _ := AsProtoMessage(value)
+			msgs = append(msgs, msg)
+		}
+		outputs[string(item[0].(starlark.String))] = msgs
+	}
+	if err := parsedOpts.invalidMessagesError(invalid); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// callMain executes the entry point function and returns its result.
+func (c *Config) callMain(ctx context.Context, opts []ExecOption) (starlark.Value, *execOptions, error) {
 	parsedOpts := &execOptions{
 		vars:     &starlark.Dict{},
 		funcName: "main",
@@ -445,11 +1799,11 @@ func (c *Config) Main(ctx context.Context, opts ...ExecOption) ([]proto.Message,
 	}
 	mainVal, ok := c.locals[parsedOpts.funcName]
 	if !ok {
-		return nil, fmt.Errorf("no %q function found in %q", parsedOpts.funcName, c.filename)
+		return nil, nil, fmt.Errorf("no %q function found in %q", parsedOpts.funcName, c.filename)
 	}
 	main, ok := mainVal.(starlark.Callable)
 	if !ok {
-		return nil, fmt.Errorf("%q must be a function (got a %s)", parsedOpts.funcName, mainVal.Type())
+		return nil, nil, fmt.Errorf("%q must be a function (got a %s)", parsedOpts.funcName, mainVal.Type())
 	}
 
 	thread := &starlark.Thread{
@@ -457,6 +1811,9 @@ func (c *Config) Main(ctx context.Context, opts ...ExecOption) ([]proto.Message,
 	}
 	thread.SetLocal(contextKey, ctx)
 	thread.SetLocal(logOutputKey, parsedOpts.logOutput)
+	thread.SetLocal(printHookKey, parsedOpts.printHook)
+	defer cancelOnDone(ctx, thread)()
+	yamlmodule.SetFileReader(thread, ctx, c.fileReader)
 	mainCtx := &starlarkstruct.Module{
 		Name: "skycfg_ctx",
 		Members: starlark.StringDict(map[string]starlark.Value{
@@ -464,52 +1821,193 @@ func (c *Config) Main(ctx context.Context, opts ...ExecOption) ([]proto.Message,
 		}),
 	}
 	args := starlark.Tuple([]starlark.Value{mainCtx})
+	finish := parsedOpts.limitExecution(thread)
 	mainVal, err := starlark.Call(thread, main, args, nil)
+	if err = finish(err); err != nil {
+		return nil, nil, withTraceback(err)
+	}
+	return mainVal, parsedOpts, nil
+}
+
+// mainValues executes the entry point function and returns the Protobuf
+// message values in its result. If the result is a dict, the messages of
+// each output are returned in the order of the dict's keys.
+func (c *Config) mainValues(ctx context.Context, opts []ExecOption) ([]starlark.Value, error) {
+	mainVal, parsedOpts, err := c.callMain(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
-	mainList, ok := mainVal.(*starlark.List)
-	if !ok {
-		if _, isNone := mainVal.(starlark.NoneType); isNone {
-			return nil, nil
+	var values []starlark.Value
+	switch mainVal := mainVal.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case *starlark.List:
+		values, err = parsedOpts.messageValues(mainVal)
+		if err != nil {
+			return nil, err
+		}
+	case *starlark.Dict:
+		for _, item := range mainVal.Items() {
+			list, err := parsedOpts.namedOutput(item)
+			if err != nil {
+				return nil, err
+			}
+			outputValues, err := parsedOpts.messageValues(list)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, outputValues...)
 		}
+	default:
 		return nil, fmt.Errorf("%q didn't return a list (got a %s)", parsedOpts.funcName, mainVal.Type())
 	}
-	var msgs []proto.Message
+	if err := parsedOpts.validateMessages(values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// namedOutput validates a key:value pair of a dict returned from the entry
+// point function, and returns the list of messages for that output.
+func (opts *execOptions) namedOutput(item starlark.Tuple) (*starlark.List, error) {
+	name, ok := item[0].(starlark.String)
+	if !ok {
+		return nil, fmt.Errorf("%q returned a dict with a non-string key (got a %s)", opts.funcName, item[0].Type())
+	}
+	list, ok := item[1].(*starlark.List)
+	if !ok {
+		return nil, fmt.Errorf("%q returned a dict with a non-list value for %s (got a %s)", opts.funcName, name, item[1].Type())
+	}
+	return list, nil
+}
+
+// messageValues returns the Protobuf message values in a list returned from
+// the entry point function.
+func (opts *execOptions) messageValues(mainList *starlark.List) ([]starlark.Value, error) {
+	var values []starlark.Value
 	for ii := 0; ii < mainList.Len(); ii++ {
 		maybeMsg := mainList.Index(ii)
 		// Flatten lists recursively. [[1, 2], 3] => [1, 2, 3]
-		if maybeMsgList, ok := maybeMsg.(*starlark.List); parsedOpts.flattenLists && ok {
-			flattened, err := FlattenProtoList(maybeMsgList)
+		if maybeMsgList, ok := maybeMsg.(*starlark.List); opts.flattenLists && ok {
+			flattened, err := flattenProtoValues(maybeMsgList)
 			if err != nil {
-				return nil, fmt.Errorf("%q returned something that's not a protobuf within a nested list %w", parsedOpts.funcName, err)
+				return nil, fmt.Errorf("%q returned something that's not a protobuf within a nested list %w", opts.funcName, err)
 			}
-			msgs = append(msgs, flattened...)
+			values = append(values, flattened...)
 		} else {
-			msg, ok := AsProtoMessage(maybeMsg)
-			if !ok {
-				return nil, fmt.Errorf("%q returned something that's not a protobuf (a %s)", parsedOpts.funcName, maybeMsg.Type())
+			if _, ok := AsProtoMessage(maybeMsg); !ok {
+				return nil, fmt.Errorf("%q returned something that's not a protobuf (a %s)", opts.funcName, maybeMsg.Type())
 			}
-			msgs = append(msgs, msg)
+			values = append(values, maybeMsg)
 		}
 	}
-	return msgs, nil
+	return values, nil
+}
+
+// validateMessages checks the Protobuf message values returned from the entry
+// point function, as configured by ExecOptions.
+func (opts *execOptions) validateMessages(values []starlark.Value) error {
+	return opts.invalidMessagesError(opts.invalidMessages(values, ""))
+}
+
+// invalidMessagesError returns an error listing the invalid messages found by
+// invalidMessages, or nil if there are none.
+func (opts *execOptions) invalidMessagesError(invalid []string) error {
+	if len(invalid) > 0 {
+		return fmt.Errorf("%q returned invalid messages: %s", opts.funcName, strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// invalidMessages describes each failure of the Protobuf message values
+// returned from the entry point function to validate. The output describes
+// which output the values are from, if the function returned named outputs.
+func (opts *execOptions) invalidMessages(values []starlark.Value, output string) []string {
+	if !opts.validateRequired && len(opts.validators) == 0 {
+		return nil
+	}
+	var invalid []string
+	for ii, value := range values {
+		msg, _ := AsProtoMessage(value)
+		name := msg.ProtoReflect().Descriptor().FullName()
+		if opts.validateRequired && proto.CheckInitialized(msg) != nil {
+			missing := missingRequiredFields(msg.ProtoReflect(), "")
+			invalid = append(invalid, fmt.Sprintf("message %d%s (%s) has unset required fields: %s",
+				ii, output, name, strings.Join(missing, ", ")))
+		}
+		for _, validate := range opts.validators {
+			if err := validate(msg); err != nil {
+				invalid = append(invalid, fmt.Sprintf("message %d%s (%s): %v", ii, output, name, err))
+			}
+		}
+	}
+	return invalid
+}
+
+// missingRequiredFields returns the paths of unset required fields within a
+// message and its nested messages, in field order.
+func missingRequiredFields(msg protoreflect.Message, prefix string) []string {
+	var missing []string
+	fields := msg.Descriptor().Fields()
+	for ii := 0; ii < fields.Len(); ii++ {
+		field := fields.Get(ii)
+		name := prefix + string(field.Name())
+		if !msg.Has(field) {
+			if field.Cardinality() == protoreflect.Required {
+				missing = append(missing, name)
+			}
+			continue
+		}
+		switch {
+		case field.IsList() && field.Message() != nil:
+			list := msg.Get(field).List()
+			for jj := 0; jj < list.Len(); jj++ {
+				missing = append(missing, missingRequiredFields(list.Get(jj).Message(), fmt.Sprintf("%s[%d].", name, jj))...)
+			}
+		case field.IsMap() && field.MapValue().Message() != nil:
+			values := msg.Get(field).Map()
+			var keys []protoreflect.MapKey
+			values.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, key)
+				return true
+			})
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, key := range keys {
+				missing = append(missing, missingRequiredFields(values.Get(key).Message(), fmt.Sprintf("%s[%s].", name, key.String()))...)
+			}
+		case field.Message() != nil && !field.IsList() && !field.IsMap():
+			missing = append(missing, missingRequiredFields(msg.Get(field).Message(), name+".")...)
+		}
+	}
+	return missing
 }
 
 func FlattenProtoList(list *starlark.List) ([]proto.Message, error) {
+	values, err := flattenProtoValues(list)
 	var flattened []proto.Message
+	for _, v := range values {
+		msg, _ := AsProtoMessage(v)
+		flattened = append(flattened, msg)
+	}
+	return flattened, err
+}
+
+// flattenProtoValues returns the Protobuf message values within a list and
+// any nested lists.
+func flattenProtoValues(list *starlark.List) ([]starlark.Value, error) {
+	var flattened []starlark.Value
 	for i := 0; i < list.Len(); i++ {
 		v := list.Index(i)
 		if l, ok := v.(*starlark.List); ok {
-			recursiveFlattened, err := FlattenProtoList(l)
+			recursiveFlattened, err := flattenProtoValues(l)
 			if err != nil {
 				return flattened, err
 			}
 			flattened = append(flattened, recursiveFlattened...)
 			continue
 		}
-		if msg, ok := AsProtoMessage(v); ok {
-			flattened = append(flattened, msg)
+		if _, ok := AsProtoMessage(v); ok {
+			flattened = append(flattened, v)
 		} else {
 			return flattened, fmt.Errorf("list contains object which is not a protobuf (got %s)", v.Type())
 		}
@@ -522,11 +2020,15 @@ type TestResult struct {
 	TestName string
 	Failure  error
 	Duration time.Duration
+
+	// Position is where the test failed in the Starlark source, if known.
+	Position syntax.Position
 }
 
 // A Test is a test case, which is a skycfg function whose name starts with `test_`.
 type Test struct {
-	callable starlark.Callable
+	callable   starlark.Callable
+	fileReader FileReader
 }
 
 // Name returns the name of the test (the name of the function)
@@ -573,6 +2075,9 @@ func (t *Test) Run(ctx context.Context, opts ...TestOption) (*TestResult, error)
 	}
 	thread.SetLocal(contextKey, ctx)
 	thread.SetLocal(logOutputKey, parsedOpts.logOutput)
+	thread.SetLocal(printHookKey, parsedOpts.printHook)
+	defer cancelOnDone(ctx, thread)()
+	yamlmodule.SetFileReader(thread, ctx, t.fileReader)
 
 	assertModule := assertmodule.AssertModule()
 	testCtx := &starlarkstruct.Module{
@@ -589,12 +2094,14 @@ func (t *Test) Run(ctx context.Context, opts ...TestOption) (*TestResult, error)
 	}
 
 	startTime := time.Now()
+	finish := parsedOpts.limitExecution(thread)
 	_, err := starlark.Call(thread, t.callable, args, nil)
+	err = finish(err)
 	result.Duration = time.Since(startTime)
 	if err != nil {
 		// if there is no assertion error, there was something wrong with the execution itself
 		if len(assertModule.Failures) == 0 {
-			return nil, err
+			return nil, withTraceback(err)
 		}
 
 		// there should only be one failure, because each test run gets its own *TestContext
@@ -608,12 +2115,51 @@ func (t *Test) Run(ctx context.Context, opts ...TestOption) (*TestResult, error)
 	return &result, nil
 }
 
+// RunTests runs each test of a config in turn, and returns their results. A
+// test that fails, or that stops with an error other than a failed assertion,
+// does not prevent the remaining tests from running; such errors are reported
+// as the Failure of that test's result.
+func RunTests(ctx context.Context, config *Config, opts ...TestOption) []*TestResult {
+	var results []*TestResult
+	for _, test := range config.Tests() {
+		result, err := test.Run(ctx, opts...)
+		if err != nil {
+			result = &TestResult{
+				TestName: test.Name(),
+				Failure:  err,
+			}
+		}
+		if result.Failure != nil {
+			result.Position = failurePosition(result.Failure)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// failurePosition returns the position in the Starlark source where a test
+// failed, if known.
+func failurePosition(err error) syntax.Position {
+	if failure, ok := err.(interface{ Position() syntax.Position }); ok {
+		return failure.Position()
+	}
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) && len(evalErr.CallStack) > 0 {
+		return evalErr.CallStack.At(0).Pos
+	}
+	return syntax.Position{}
+}
+
 // Tests returns all tests defined in the config
 func (c *Config) Tests() []*Test {
 	return c.tests
 }
 
 func skyPrint(t *starlark.Thread, msg string) {
+	if hook, ok := t.Local(printHookKey).(PrintHook); ok && hook != nil {
+		hook(t, msg, t.CallFrame(1).Pos)
+		return
+	}
 	var out io.Writer = os.Stderr
 	if w := t.Local(logOutputKey); w != nil {
 		out = w.(io.Writer)
@@ -648,6 +2194,9 @@ func (c *Config) MainNonProtobuf(ctx context.Context, opts ...ExecOption) ([]str
 	}
 	thread.SetLocal(contextKey, ctx)
 	thread.SetLocal(logOutputKey, parsedOpts.logOutput)
+	thread.SetLocal(printHookKey, parsedOpts.printHook)
+	defer cancelOnDone(ctx, thread)()
+	yamlmodule.SetFileReader(thread, ctx, c.fileReader)
 	mainCtx := &starlarkstruct.Module{
 		Name: "skycfg_ctx",
 		Members: starlark.StringDict(map[string]starlark.Value{
@@ -655,9 +2204,10 @@ func (c *Config) MainNonProtobuf(ctx context.Context, opts ...ExecOption) ([]str
 		}),
 	}
 	args := starlark.Tuple([]starlark.Value{mainCtx})
+	finish := parsedOpts.limitExecution(thread)
 	mainVal, err := starlark.Call(thread, main, args, nil)
-	if err != nil {
-		return nil, err
+	if err = finish(err); err != nil {
+		return nil, withTraceback(err)
 	}
 	mainList, ok := mainVal.(*starlark.List)
 	if !ok {
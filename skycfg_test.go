@@ -18,13 +18,26 @@ package skycfg_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	starlarktime "go.starlark.net/lib/time"
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 	wrappers "google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/stripe/skycfg"
@@ -312,6 +325,21 @@ def main(ctx):
 	msg4 = "44444"
 
 	return [[msg, [msg2, [msg3]]], msg4]
+`,
+	"yaml/decode_file.sky": `
+config = yaml.decode_file("yaml/config.yaml")
+
+def main(ctx):
+	docs = yaml.decode_file("yaml/multi.yaml", multi = True)
+	return [config["name"]] + [doc["value"] for doc in docs]
+`,
+	"yaml/config.yaml": `
+name: from-load
+`,
+	"yaml/multi.yaml": `
+value: from-main-1
+---
+value: from-main-2
 `,
 	"print/on_load.sky": `
 print("hello world")
@@ -712,6 +740,15 @@ func TestSkycfgNonProtobufEndToEnd(t *testing.T) {
 			},
 			execOptions: []skycfg.ExecOption{skycfg.WithFlattenLists()},
 		},
+		endToEndTestCaseNonProtobuf{
+			caseName:   "yaml.decode_file",
+			fileToLoad: "yaml/decode_file.sky",
+			expStrings: []string{
+				"from-load",
+				"from-main-1",
+				"from-main-2",
+			},
+		},
 	}
 
 	fnExecSkycfg := ExecSkycfgNonProtobuf(func(config *skycfg.Config, testCase endToEndTestCaseNonProtobuf) ([]string, error) {
@@ -779,6 +816,45 @@ func TestSkycfgLogOutput(t *testing.T) {
 	})
 }
 
+func TestSkycfgPrintHook(t *testing.T) {
+	ctx := context.Background()
+	loader := new(testLoader)
+	var printed []string
+	hook := skycfg.PrintHook(func(thread *starlark.Thread, msg string, pos syntax.Position) {
+		printed = append(printed, fmt.Sprintf("%s: %s", pos, msg))
+	})
+	var sb strings.Builder
+	cfg, err := skycfg.Load(ctx, "print/on_load.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithLogOutput(&sb),
+		skycfg.WithPrintHook(hook),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = skycfg.Load(ctx, "print/main_and_test.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.Main(ctx, skycfg.WithLogOutput(&sb), skycfg.WithPrintHook(hook)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.Tests()[0].Run(ctx, skycfg.WithLogOutput(&sb), skycfg.WithPrintHook(hook)); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"print/on_load.sky:2:6: hello world",
+		"print/main_and_test.sky:6:7: hello world in main",
+		"print/main_and_test.sky:3:7: hello world in test",
+	}
+	if !reflect.DeepEqual(printed, expected) {
+		t.Errorf("incorrect printed messages: found %q, expected %q", printed, expected)
+	}
+	if out := sb.String(); out != "" {
+		t.Errorf("log output should be unused when a print hook is set, found %q", out)
+	}
+}
+
 func TestSkycfgWithEntryPoint(t *testing.T) {
 	testCases := []endToEndTestCase{
 		endToEndTestCase{
@@ -799,98 +875,1803 @@ func TestSkycfgWithEntryPoint(t *testing.T) {
 	runTestCases(t, testCases, fnExecSkycfg)
 }
 
-// testTestCase is a test case for the testing functionality built into skycfg
-type testTestCase struct {
-	errors     bool
-	passes     bool
-	failureMsg string
+// virtualLoader resolves names in the style of Bazel labels: "//" names are
+// absolute, and all others are relative to the directory of the loading module.
+type virtualLoader struct {
+	files    map[string]string
+	resolved []string
 }
 
-func TestSkycfgTesting(t *testing.T) {
-	loader := &testLoader{}
-	ctx := context.Background()
+func (loader *virtualLoader) Resolve(ctx context.Context, name, fromPath string) (string, error) {
+	var resolved string
+	if strings.HasPrefix(name, "//") {
+		resolved = path.Clean(strings.TrimPrefix(name, "//"))
+	} else {
+		resolved = path.Join(path.Dir(fromPath), name)
+	}
+	loader.resolved = append(loader.resolved, fmt.Sprintf("%s -> %s", name, resolved))
+	return resolved, nil
+}
 
-	config, err := skycfg.Load(ctx, "test1.sky", skycfg.WithFileReader(loader))
+func (loader *virtualLoader) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	if source, ok := loader.files[path]; ok {
+		return []byte(source), nil
+	}
+	return nil, fileNotFoundError(path)
+}
+
+// fileNotFoundError reports a missing file, and matches os.ErrNotExist.
+type fileNotFoundError string
+
+func (e fileNotFoundError) Error() string { return fmt.Sprintf("File %s not found", string(e)) }
+
+func (e fileNotFoundError) Is(target error) bool { return target == os.ErrNotExist }
+
+func TestSkycfgVirtualFileReader(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"app/main.sky": `
+load("lib/names.sky", "name")
+load("//common/suffix.sky", "suffix")
+value = name + suffix
+names = yaml.decode_file("lib/names.yaml")
+`,
+		"app/lib/names.sky": `
+load("../../common/suffix.sky", "suffix")
+name = yaml.decode_file("names.yaml")["name"] + suffix
+`,
+		"app/lib/names.yaml": `
+name: app
+`,
+		"common/suffix.sky": `
+suffix = yaml.decode_file("suffix.yaml")
+`,
+		"common/suffix.yaml": `
+"!"
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "app/main.sky", skycfg.WithFileReader(loader))
 	if err != nil {
-		t.Error("Unexpected error loading test1.sky", err)
+		t.Fatal(err)
+	}
+	if got, want := config.Filename(), "app/main.sky"; got != want {
+		t.Errorf("Filename: got %q, want %q", got, want)
+	}
+	if got, want := config.Locals()["value"], starlark.String("app!!"); got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+	wantResolved := []string{
+		"app/main.sky -> app/main.sky",
+		"lib/names.sky -> app/lib/names.sky",
+		"../../common/suffix.sky -> common/suffix.sky",
+		"suffix.yaml -> common/suffix.yaml",
+		"names.yaml -> app/lib/names.yaml",
+		"//common/suffix.sky -> common/suffix.sky",
+		"lib/names.yaml -> app/lib/names.yaml",
+	}
+	if !reflect.DeepEqual(loader.resolved, wantResolved) {
+		t.Errorf("Resolve calls: got %q, want %q", loader.resolved, wantResolved)
+	}
+	wantDeps := []string{"app/main.sky", "app/lib/names.sky", "common/suffix.sky", "common/suffix.yaml", "app/lib/names.yaml"}
+	if got := config.Dependencies(); !reflect.DeepEqual(got, wantDeps) {
+		t.Errorf("Dependencies: got %q, want %q", got, wantDeps)
 	}
+}
 
-	cases := map[string]testTestCase{
-		"test_helper1": testTestCase{
-			passes: true,
-		},
-		"test_helper2": testTestCase{
-			passes: true,
-		},
-		"test_helper2_fails": testTestCase{
-			passes:     false,
-			failureMsg: "assertion failed",
+func TestSkycfgLoadErrorChain(t *testing.T) {
+	files := map[string]string{
+		"a.sky":       `load("b.sky", "b")`,
+		"b.sky":       `load("c.sky", "c")`,
+		"c.sky":       `load("missing.sky", "m")`,
+		"cycle/a.sky": `load("b.sky", "b")`,
+		"cycle/b.sky": `load("c.sky", "c")`,
+		"cycle/c.sky": `load("b.sky", "b")`,
+	}
+	testCases := []struct {
+		filename   string
+		wantChain  []string
+		wantErr    string
+		wantFrames []string
+	}{
+		{
+			filename:   "a.sky",
+			wantChain:  []string{"a.sky", "b.sky", "c.sky", "missing.sky"},
+			wantErr:    "a.sky -> b.sky -> c.sky -> missing.sky: File missing.sky not found",
+			wantFrames: []string{"a.sky:1:1: <toplevel>", "b.sky:1:1: <toplevel>", "c.sky:1:1: <toplevel>"},
 		},
-		"test_helper2_errors": testTestCase{
-			errors: true,
+		{
+			filename:   "cycle/a.sky",
+			wantChain:  []string{"cycle/a.sky", "cycle/b.sky", "cycle/c.sky", "cycle/b.sky"},
+			wantErr:    "cycle/a.sky -> cycle/b.sky -> cycle/c.sky -> cycle/b.sky: cycle in load graph",
+			wantFrames: []string{"cycle/a.sky:1:1: <toplevel>", "cycle/b.sky:1:1: <toplevel>", "cycle/c.sky:1:1: <toplevel>"},
 		},
-		"test_main": testTestCase{
-			passes: true,
+		{
+			filename:  "missing.sky",
+			wantChain: []string{"missing.sky"},
+			wantErr:   "missing.sky: File missing.sky not found",
 		},
 	}
+	for _, testCase := range testCases {
+		loader := &virtualLoader{files: files}
+		_, err := skycfg.Load(context.Background(), testCase.filename, skycfg.WithFileReader(loader))
+		if err == nil || err.Error() != testCase.wantErr {
+			t.Errorf("Load(%q): got error %v, want %q", testCase.filename, err, testCase.wantErr)
+		}
+		var loadErr *skycfg.LoadError
+		if !errors.As(err, &loadErr) {
+			t.Errorf("Load(%q): got error of type %T, want *skycfg.LoadError", testCase.filename, err)
+			continue
+		}
+		if !reflect.DeepEqual(loadErr.Chain, testCase.wantChain) {
+			t.Errorf("Load(%q): got chain %q, want %q", testCase.filename, loadErr.Chain, testCase.wantChain)
+		}
+		var frames []string
+		for _, frame := range loadErr.Frames() {
+			frames = append(frames, fmt.Sprintf("%s: %s", frame.Position, frame.Function))
+		}
+		if !reflect.DeepEqual(frames, testCase.wantFrames) {
+			t.Errorf("Load(%q): got frames %q, want %q", testCase.filename, frames, testCase.wantFrames)
+		}
+	}
+}
 
-	tests := config.Tests()
-	if len(tests) != len(cases) {
-		t.Error("Expected %d tests but found %d", len(cases), len(tests))
+func TestSkycfgLoadOptional(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"app/main.sky": `
+lib = load_optional("lib/names.sky")
+missing = load_optional("//missing.sky")
+name = lib.name if lib else "default"
+fallback = missing.name if missing else "default"
+
+def main(ctx):
+  load_optional("lib/names.sky")
+  return []
+`,
+		"app/lib/names.sky": `
+name = "app"
+`,
+		"broken.sky": `
+broken = load_optional("//lib.sky")
+`,
+		"lib.sky": `
+load("//missing.sky", "name")
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "app/main.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	locals := config.Locals()
+	if got, want := locals["name"], starlark.String("app"); got != want {
+		t.Errorf("name: got %v, want %v", got, want)
+	}
+	if got, want := locals["fallback"], starlark.String("default"); got != want {
+		t.Errorf("fallback: got %v, want %v", got, want)
+	}
+	if got := locals["missing"]; got != starlark.None {
+		t.Errorf("missing: got %v, want None", got)
+	}
+	wantDeps := []string{"app/main.sky", "app/lib/names.sky"}
+	if got := config.Dependencies(); !reflect.DeepEqual(got, wantDeps) {
+		t.Errorf("Dependencies: got %q, want %q", got, wantDeps)
 	}
 
-	for _, test := range tests {
-		result, err := test.Run(ctx, skycfg.WithTestVars(starlark.StringDict{
-			"var_key": starlark.String("var_value"),
-		}))
-		testCase, ok := cases[test.Name()]
-		if !ok {
-			t.Error("Could not find test case for test", test.Name())
-			continue
+	_, err = config.Main(context.Background())
+	if wantErr := "load_optional: modules can only be loaded during Load()"; err == nil || err.Error() != wantErr {
+		t.Errorf("main: got error %v, want %q", err, wantErr)
+	}
+
+	// A module that exists but fails to load is an error.
+	_, err = skycfg.Load(context.Background(), "broken.sky", skycfg.WithFileReader(loader))
+	var loadErr *skycfg.LoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("broken: got error %v, want *skycfg.LoadError", err)
+	}
+	if want := []string{"broken.sky", "lib.sky", "missing.sky"}; !reflect.DeepEqual(loadErr.Chain, want) {
+		t.Errorf("broken: got chain %q, want %q", loadErr.Chain, want)
+	}
+
+	// A cached module is executed again once a missing module is added.
+	cache := skycfg.NewModuleCache()
+	files := map[string]string{
+		"root.sky": `
+load("shared.sky", shared_value = "value")
+value = shared_value
+`,
+		"shared.sky": `
+extra = load_optional("extra.sky")
+value = extra.value if extra else "default"
+`,
+	}
+	for _, want := range []string{"default", "extra"} {
+		config, err := skycfg.Load(context.Background(), "root.sky",
+			skycfg.WithFileReader(&virtualLoader{files: files}),
+			skycfg.WithModuleCache(cache),
+		)
+		if err != nil {
+			t.Fatal(err)
 		}
+		if got := config.Locals()["value"]; got != starlark.String(want) {
+			t.Errorf("cached: got %v, want %q", got, want)
+		}
+		files["extra.sky"] = `value = "extra"`
+	}
 
-		if (err != nil) != testCase.errors {
-			t.Errorf(
-				"[%s] Execution result (error: %t) did not equal expected execution result (error: %t). err: %s",
-				test.Name(),
-				err != nil,
-				testCase.errors,
-				err,
-			)
-			continue
+	// Missing files are detected with the default file reader too.
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.sky")
+	if err := os.WriteFile(mainPath, []byte(`missing = load_optional("missing.sky")`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = skycfg.Load(context.Background(), mainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Locals()["missing"]; got != starlark.None {
+		t.Errorf("local missing: got %v, want None", got)
+	}
+}
+
+func TestSkycfgModuleCache(t *testing.T) {
+	files := map[string]string{
+		"lib.sky": `
+print("executing lib.sky")
+value = yaml.decode_file("value.yaml")
+`,
+		"value.yaml": `shared`,
+		"a.sky": `
+load("lib.sky", lib_value = "value")
+value = lib_value
+`,
+		"b.sky": `
+load("lib.sky", lib_value = "value")
+value = lib_value
+`,
+	}
+	cache := skycfg.NewModuleCache()
+	load := func(filename string) string {
+		var sb strings.Builder
+		config, err := skycfg.Load(context.Background(), filename,
+			skycfg.WithFileReader(&virtualLoader{files: files}),
+			skycfg.WithModuleCache(cache),
+			skycfg.WithLogOutput(&sb),
+		)
+		if err != nil {
+			t.Error(err)
+			return ""
 		}
+		if got := config.Locals()["value"]; got != starlark.String("shared") {
+			t.Errorf("%s: got value %v, want \"shared\"", filename, got)
+		}
+		wantDeps := []string{filename, "lib.sky", "value.yaml"}
+		if got := config.Dependencies(); !reflect.DeepEqual(got, wantDeps) {
+			t.Errorf("%s: got dependencies %q, want %q", filename, got, wantDeps)
+		}
+		return sb.String()
+	}
 
-		// if the execution errors, the result is nil and theres nothing else to check
+	if got, want := load("a.sky"), "[lib.sky:2:6] executing lib.sky\n"; got != want {
+		t.Errorf("first load: got output %q, want %q", got, want)
+	}
+	if got := load("b.sky"); got != "" {
+		t.Errorf("cached load: got output %q, want no output", got)
+	}
+
+	// Changing the content of a module invalidates its cache entry, and the
+	// entries of modules that load it.
+	files["lib.sky"] = `
+print("executing new lib.sky")
+value = yaml.decode_file("value.yaml")
+`
+	if got, want := load("a.sky"), "[lib.sky:2:6] executing new lib.sky\n"; got != want {
+		t.Errorf("modified load: got output %q, want %q", got, want)
+	}
+
+	// As does changing the content of a file read by yaml.decode_file().
+	files["value.yaml"] = `shared # changed`
+	if got, want := load("b.sky"), "[lib.sky:2:6] executing new lib.sky\n"; got != want {
+		t.Errorf("modified file load: got output %q, want %q", got, want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := load("b.sky"); got != "" {
+				t.Errorf("concurrent load: got output %q, want no output", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSkycfgMainWithSource(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"lib.sky": `
+test_proto = proto.package("skycfg.test_proto")
+
+def make(value):
+  return test_proto.MessageV3(f_string = value)
+`,
+		"main.sky": `
+load("lib.sky", "make")
+test_proto = proto.package("skycfg.test_proto")
+
+def main(ctx):
+  return [
+    make("from lib"),
+    test_proto.MessageV3(f_string = "from main"),
+    proto.decode_json(test_proto.MessageV3, '{"f_string": "decoded"}'),
+  ]
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "main.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := config.MainWithSource(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		value    string
+		position string
+	}{
+		{"from lib", "lib.sky:5:30"},
+		{"from main", "main.sky:8:25"},
+		{"decoded", "<invalid>"},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(want))
+	}
+	for ii, msg := range msgs {
+		if got := msg.Message.(*pb.MessageV3).GetFString(); got != want[ii].value {
+			t.Errorf("message %d: got f_string %q, want %q", ii, got, want[ii].value)
+		}
+		if got := msg.Position.String(); got != want[ii].position {
+			t.Errorf("message %d: got position %s, want %s", ii, got, want[ii].position)
+		}
+	}
+}
+
+func TestSkycfgMainNamed(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"named.sky": `
+test_proto = proto.package("skycfg.test_proto")
+
+def main(ctx):
+  return {
+    "deployment": [test_proto.MessageV3(f_string = "a"), test_proto.MessageV3(f_string = "b")],
+    "service": [],
+  }
+`,
+		"bad_value.sky": `
+def main(ctx):
+  return {"deployment": "a"}
+`,
+		"bad_key.sky": `
+def main(ctx):
+  return {1: []}
+`,
+		"list.sky": `
+def main(ctx):
+  return []
+`,
+	}}
+	load := func(filename string) *skycfg.Config {
+		config, err := skycfg.Load(context.Background(), filename, skycfg.WithFileReader(loader))
 		if err != nil {
-			continue
+			t.Fatal(err)
 		}
+		return config
+	}
+	ctx := context.Background()
 
-		if result.TestName != test.Name() {
-			t.Errorf("TestResult (%s) and Test (%s) should have the same name", result.TestName, test.Name())
+	outputs, err := load("named.sky").MainNamed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]proto.Message{
+		"deployment": {&pb.MessageV3{FString: "a"}, &pb.MessageV3{FString: "b"}},
+		"service":    {},
+	}
+	if len(outputs) != len(want) {
+		t.Errorf("MainNamed: got outputs %v, want %v", outputs, want)
+	}
+	for name, wantMsgs := range want {
+		gotMsgs, ok := outputs[name]
+		if !ok || len(gotMsgs) != len(wantMsgs) {
+			t.Errorf("MainNamed: output %q: got %v, want %v", name, gotMsgs, wantMsgs)
 			continue
 		}
+		for ii := range wantMsgs {
+			if !proto.Equal(gotMsgs[ii], wantMsgs[ii]) {
+				t.Errorf("MainNamed: output %q: got %v, want %v", name, gotMsgs, wantMsgs)
+			}
+		}
+	}
 
-		if (result.Failure == nil) != testCase.passes {
-			t.Errorf(
-				"[%s] Test result (pass: %t) did not equal expected test result (pass: %t)",
-				test.Name(),
-				result.Failure == nil,
-				testCase.passes,
-			)
-			continue
+	// Main returns the messages of every named output.
+	msgs, err := load("named.sky").Main(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || !proto.Equal(msgs[0], want["deployment"][0]) || !proto.Equal(msgs[1], want["deployment"][1]) {
+		t.Errorf("Main: got %v, want %v", msgs, want["deployment"])
+	}
+
+	errorCases := map[string]string{
+		"bad_value.sky": `"main" returned a dict with a non-list value for "deployment" (got a string)`,
+		"bad_key.sky":   `"main" returned a dict with a non-string key (got a int)`,
+		"list.sky":      `"main" didn't return a dict (got a list)`,
+	}
+	for filename, wantErr := range errorCases {
+		_, err := load(filename).MainNamed(ctx)
+		if err == nil || err.Error() != wantErr {
+			t.Errorf("MainNamed(%q): got error %v, want %q", filename, err, wantErr)
 		}
+	}
+}
 
-		if !testCase.passes {
-			// check the error message
-			if !strings.Contains(result.Failure.Error(), testCase.failureMsg) {
-				t.Errorf(
-					"[%s] Expected %s to be in failure message, but instead found %s",
-					test.Name(),
-					testCase.failureMsg,
-					result.Failure.Error(),
-				)
-				continue
-			}
+type testContextKey struct{}
+
+func TestSkycfgContext(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"context.sky": `
+def main(ctx):
+  return [proto.package("google.protobuf").StringValue(value = context_value())]
+`,
+		"loop.sky": `
+def main(ctx):
+  for x in range(1000000000):
+    pass
+  return []
+`,
+	}}
+	contextValue := starlark.NewBuiltin("context_value", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		value, _ := skycfg.GetContext(thread).Value(testContextKey{}).(string)
+		return starlark.String(value), nil
+	})
+	load := func(filename string) *skycfg.Config {
+		config, err := skycfg.Load(context.Background(), filename,
+			skycfg.WithFileReader(loader),
+			skycfg.WithGlobals(starlark.StringDict{"context_value": contextValue}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	ctx := context.WithValue(context.Background(), testContextKey{}, "from context")
+	msgs, err := load("context.sky").Main(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msgs[0].(*wrappers.StringValue).GetValue(); got != "from context" {
+		t.Errorf("GetContext: got value %q, want %q", got, "from context")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = load("loop.sky").Main(ctx)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("Main with expired context: got error %v, want %q", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSkycfgExecutionLimit(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"loop.sky": `
+def spin():
+  for x in range(1000000000):
+    pass
+
+def main(ctx):
+  spin()
+  return []
+`,
+		"load_loop.sky": `
+load("loop.sky", "spin")
+spin()
+`,
+		"alloc.sky": `
+def main(ctx):
+  chunks = []
+  for x in range(1000000):
+    chunks.append("x" * 100000)
+  return []
+`,
+	}}
+
+	config, err := skycfg.Load(context.Background(), "loop.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = config.Main(context.Background(), skycfg.WithExecutionLimit(1000))
+	var limitErr *skycfg.ExecutionLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("step limit: got error %v, want *ExecutionLimitError", err)
+	}
+	if got, want := limitErr.Error(), "loop.sky:3:3: execution limit of 1000 steps exceeded"; got != want {
+		t.Errorf("step limit: got error %q, want %q", got, want)
+	}
+
+	_, err = config.Main(context.Background(), skycfg.WithTimeout(10*time.Millisecond))
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("timeout: got error %v, want *ExecutionLimitError", err)
+	}
+	if got, want := limitErr.Limit, "10ms"; got != want {
+		t.Errorf("timeout: got limit %q, want %q", got, want)
+	}
+	if got, want := limitErr.Position.Filename(), "loop.sky"; got != want {
+		t.Errorf("timeout: got position in %q, want %q", got, want)
+	}
+
+	_, err = skycfg.Load(context.Background(), "load_loop.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithExecutionLimit(1000),
+	)
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("load: got error %v, want *ExecutionLimitError", err)
+	}
+
+	config, err = skycfg.Load(context.Background(), "alloc.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = config.Main(context.Background(), skycfg.WithMaxAllocs(10<<20))
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("allocation limit: got error %v, want *ExecutionLimitError", err)
+	}
+	if got, want := limitErr.Limit, "10485760 bytes allocated"; got != want {
+		t.Errorf("allocation limit: got limit %q, want %q", got, want)
+	}
+
+	// An error unrelated to the limit is returned unchanged.
+	_, err = config.Main(context.Background(), skycfg.WithExecutionLimit(1000), skycfg.WithEntryPoint("missing"))
+	if err == nil || errors.As(err, &limitErr) {
+		t.Errorf("missing entry point: got error %v", err)
+	}
+}
+
+func TestSkycfgRecursion(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"main.sky": `
+def depth(n):
+  if n == 0:
+    return 0
+  return depth(n - 1) + 1
+
+def is_even(n):
+  return True if n == 0 else is_odd(n - 1)
+
+def is_odd(n):
+  return False if n == 0 else is_even(n - 1)
+
+def apply(f, n):
+  return f(n)
+
+def nested(n):
+  return apply(nested, n - 1)
+
+def main(ctx):
+  depth(1000000)
+  return []
+
+def mutual(ctx):
+  is_even(1000000)
+  return []
+
+def through_call(ctx):
+  nested(1000000)
+  return []
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "main.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		entryPoint string
+		wantErr    string
+	}{
+		{"main", "function depth called recursively"},
+		{"mutual", "function is_even called recursively"},
+		{"through_call", "function nested called recursively"},
+	} {
+		t.Run(test.entryPoint, func(t *testing.T) {
+			_, err := config.Main(context.Background(), skycfg.WithEntryPoint(test.entryPoint))
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSkycfgTraceback(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"lib.sky": `
+def check(value):
+  if not value:
+    fail("value is required")
+  return value
+`,
+		"main.sky": `
+load("lib.sky", "check")
+
+def main(ctx):
+  return [check(None)]
+`,
+		"broken.sky": `
+load("main.sky", "main")
+`,
+		"lib_broken.sky": `
+load("lib.sky", "check")
+check(None)
+`,
+	}}
+	frames := func(err error) string {
+		var tbErr skycfg.TracebackError
+		if !errors.As(err, &tbErr) {
+			t.Fatalf("got error %v, want skycfg.TracebackError", err)
+		}
+		var evalErr *starlark.EvalError
+		if !errors.As(err, &evalErr) {
+			t.Errorf("got error %v, want *starlark.EvalError", err)
+		}
+		var lines []string
+		for _, frame := range tbErr.Frames() {
+			lines = append(lines, fmt.Sprintf("%s: %s", frame.Position, frame.Function))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	config, err := skycfg.Load(context.Background(), "main.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = config.Main(context.Background())
+	want := strings.Join([]string{
+		"main.sky:5:16: main",
+		"lib.sky:4:9: check",
+		"<builtin>: fail",
+	}, "\n")
+	if got := frames(err); got != want {
+		t.Errorf("main: got frames\n%s\nwant\n%s", got, want)
+	}
+
+	_, err = skycfg.Load(context.Background(), "lib_broken.sky", skycfg.WithFileReader(loader))
+	want = strings.Join([]string{
+		"lib_broken.sky:3:6: <toplevel>",
+		"lib.sky:4:9: check",
+		"<builtin>: fail",
+	}, "\n")
+	if got := frames(err); got != want {
+		t.Errorf("load: got frames\n%s\nwant\n%s", got, want)
+	}
+
+	// A module that fails to load was loaded by load().
+	loader.files["lib.sky"] = `fail("broken lib")`
+	_, err = skycfg.Load(context.Background(), "broken.sky", skycfg.WithFileReader(loader))
+	want = strings.Join([]string{
+		"broken.sky:2:1: <toplevel>",
+		"main.sky:2:1: <toplevel>",
+		"lib.sky:1:5: <toplevel>",
+		"<builtin>: fail",
+	}, "\n")
+	if got := frames(err); got != want {
+		t.Errorf("nested load: got frames\n%s\nwant\n%s", got, want)
+	}
+
+	// Errors not caused by executing Starlark code have no frames.
+	_, err = config.Main(context.Background(), skycfg.WithEntryPoint("missing"))
+	var tbErr skycfg.TracebackError
+	if errors.As(err, &tbErr) {
+		t.Errorf("missing entry point: got TracebackError %v", err)
+	}
+}
+
+func TestSkycfgThreadLocal(t *testing.T) {
+	const counterKey = "skycfgtest.counter"
+	next := starlark.NewBuiltin("next", func(thread *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		count, _ := skycfg.ThreadLocal(thread, counterKey).(int)
+		count++
+		skycfg.SetThreadLocal(thread, counterKey, count)
+		return starlark.MakeInt(count), nil
+	})
+	loader := &virtualLoader{files: map[string]string{
+		"main.sky": `
+def main(ctx):
+  return [str(next()), str(next())]
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "main.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithGlobals(starlark.StringDict{"next": next}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each execution starts with no thread-local values.
+	for i := 0; i < 2; i++ {
+		got, err := config.MainNonProtobuf(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"1", "2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("execution %d: got %v, want %v", i, got, want)
+		}
+	}
+
+	for _, key := range []string{"counter", ".counter", "skycfgtest."} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("ThreadLocal(%q): expected panic", key)
+				}
+			}()
+			skycfg.ThreadLocal(&starlark.Thread{}, key)
+		}()
+	}
+}
+
+func TestSkycfgDeterministic(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"now.sky": `
+def main(ctx):
+  time.now()
+  return []
+`,
+		"parse.sky": `
+def main(ctx):
+  time.parse_duration("1s")
+  return []
+`,
+		"renamed.sky": `
+def main(ctx):
+  clock.now()
+  return []
+`,
+		"builtin.sky": `
+def main(ctx):
+  now()
+  return []
+`,
+		"env.sky": `
+def main(ctx):
+  env.get("NAME")
+  return []
+`,
+	}}
+	now := starlarktime.Module.Members["now"]
+	run := func(filename string, deterministic bool) error {
+		config, err := skycfg.Load(context.Background(), filename,
+			skycfg.WithFileReader(loader),
+			skycfg.WithGlobals(starlark.StringDict{
+				"time":  starlarktime.Module,
+				"clock": starlarktime.Module,
+				"now":   now,
+			}),
+			skycfg.WithEnv(map[string]string{"NAME": "value"}),
+			skycfg.WithDeterministic(deterministic),
+		)
+		if err != nil {
+			return err
+		}
+		_, err = config.Main(context.Background())
+		return err
+	}
+
+	if err := run("now.sky", false); err != nil {
+		t.Errorf("time.now without deterministic mode: %v", err)
+	}
+	err := run("now.sky", true)
+	if want := "time.now: not allowed in deterministic mode"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("time.now in deterministic mode: got error %v, want %q", err, want)
+	}
+	for _, filename := range []string{"renamed.sky", "builtin.sky"} {
+		err := run(filename, true)
+		if want := "time.now: not allowed in deterministic mode"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s in deterministic mode: got error %v, want %q", filename, err, want)
+		}
+	}
+	if err := run("env.sky", false); err != nil {
+		t.Errorf("env.get without deterministic mode: %v", err)
+	}
+	err = run("env.sky", true)
+	if want := "env.get: not allowed in deterministic mode"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("env.get in deterministic mode: got error %v, want %q", err, want)
+	}
+	if err := run("parse.sky", true); err != nil {
+		t.Errorf("time.parse_duration in deterministic mode: %v", err)
+	}
+	if starlarktime.Module.Members["now"] != now {
+		t.Errorf("deterministic mode modified the time module")
+	}
+}
+
+func TestSkycfgStrictFieldTypes(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"test.sky": `
+pb = proto.package("skycfg.test_proto")
+
+def main(ctx):
+  return [pb.MessageV3(f_float64 = 1)]
+`,
+	}}
+	run := func(strict bool) ([]proto.Message, error) {
+		config, err := skycfg.Load(context.Background(), "test.sky",
+			skycfg.WithFileReader(loader),
+			skycfg.WithStrictFieldTypes(strict),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return config.Main(context.Background())
+	}
+
+	msgs, err := run(false)
+	if err != nil {
+		t.Fatalf("without strict field types: %v", err)
+	}
+	if got := msgs[0].(*pb.MessageV3).GetFFloat64(); got != 1 {
+		t.Errorf("without strict field types: got f_float64 %v, want 1", got)
+	}
+	_, err = run(true)
+	if want := `TypeError: value 1 (type "int") can't be assigned to type "double".`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("with strict field types: got error %v, want %q", err, want)
+	}
+}
+
+func TestSkycfgFrozenGlobals(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"load.sky": `shared.append(1)`,
+		"main.sky": `
+def main(ctx):
+  shared.append(1)
+  return []
+`,
+		"module.sky": `
+def main(ctx):
+  settings.values["a"] = 1
+  return []
+`,
+		"lazy.sky": `
+def main(ctx):
+  lazy.append(1)
+  return []
+`,
+		"repeated.sky": `
+def main(ctx):
+  msg.r_string.append("a")
+  return []
+`,
+		"map.sky": `
+def main(ctx):
+  msg.map_string["a"] = "A"
+  return []
+`,
+		"submsg.sky": `
+def main(ctx):
+  msg.f_submsg = proto.package("skycfg.test_proto").MessageV3()
+  return []
+`,
+	}}
+	msg, err := skycfg.NewProtoMessage(&pb.MessageV3{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newGlobals := func() []skycfg.LoadOption {
+		return []skycfg.LoadOption{
+			skycfg.WithFileReader(loader),
+			skycfg.WithGlobals(starlark.StringDict{
+				"shared": starlark.NewList(nil),
+				"settings": &starlarkstruct.Module{
+					Name:    "settings",
+					Members: starlark.StringDict{"values": starlark.NewDict(0)},
+				},
+				"msg": msg,
+			}),
+			skycfg.WithLazyGlobals(func(name string) (starlark.Value, bool, error) {
+				return starlark.NewList(nil), name == "lazy", nil
+			}),
+		}
+	}
+	for _, test := range []struct {
+		filename string
+		want     string
+	}{
+		{"load.sky", "cannot append to frozen list"},
+		{"main.sky", "cannot append to frozen list"},
+		{"module.sky", "cannot insert into frozen hash table"},
+		{"lazy.sky", "cannot append to frozen list"},
+		{"repeated.sky", "cannot append to frozen list"},
+		{"map.sky", "cannot insert into frozen hash table"},
+		{"submsg.sky", "cannot set field of frozen message"},
+	} {
+		config, err := skycfg.Load(context.Background(), test.filename, newGlobals()...)
+		if err == nil {
+			_, err = config.Main(context.Background())
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.filename, err, test.want)
+		}
+	}
+}
+
+// Data races between the calls are reported when run with -race.
+func TestSkycfgConcurrentMain(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"test.sky": `
+load("base.sky", "base", "names")
+
+pb = proto.package("skycfg.test_proto")
+
+def main(ctx):
+  msg = proto.clone(base)
+  msg.f_int64 = ctx.vars["n"]
+  msg.r_string.extend(names)
+  msg.map_string["n"] = str(ctx.vars["n"])
+  merged = proto.merge(pb.MessageV3(f_submsg = base), msg)
+  return [
+    merged,
+    pb.MessageV3(f_string = proto.encode_json(merged) + yaml.encode(names)),
+    shared.f_submsg or pb.MessageV3(f_string = "%d %d" % (len(shared.r_string), len(shared.map_string))),
+  ]
+`,
+		"base.sky": `
+pb = proto.package("skycfg.test_proto")
+names = ["a", "b"]
+base = pb.MessageV3(f_string = "base", r_string = names, map_string = {"a": "A"})
+`,
+	}}
+	shared := &pb.MessageV3{FString: "shared"}
+	sharedValue, err := skycfg.NewProtoMessage(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := skycfg.Load(context.Background(), "test.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithGlobals(starlark.StringDict{"shared": sharedValue}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const calls = 20
+	var wg sync.WaitGroup
+	errs := make([]error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msgs, err := config.Main(context.Background(), skycfg.WithVars(starlark.StringDict{
+				"n": starlark.MakeInt(i),
+			}))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			msg := msgs[0].(*pb.MessageV3)
+			if msg.GetFInt64() != int64(i) || len(msg.GetRString()) != 4 || msg.GetMapString()["n"] != fmt.Sprint(i) {
+				errs[i] = fmt.Errorf("unexpected message %v", msg)
+			}
+			// Unset fields of the frozen global read as empty defaults.
+			if got := msgs[2].(*pb.MessageV3).GetFString(); got != "0 0" {
+				errs[i] = fmt.Errorf("unexpected defaults of shared message %q", got)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+}
+
+func TestSkycfgParse(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"test.sky": `
+load("other.sky", "helper")
+
+pb = proto.package("skycfg.test_proto")
+fail("not executed")
+
+def main(ctx):
+  return [pb.MessageV3(f_string = helper(len(ctx.vars)))]
+`,
+		"undefined.sky": `x = undefined_name`,
+	}}
+	parsed, err := skycfg.Parse(context.Background(), "test.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := parsed.Filename(); got != "test.sky" {
+		t.Errorf("Filename(): got %q, want %q", got, "test.sky")
+	}
+	if got, want := parsed.GlobalNames(), []string{"pb", "main"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GlobalNames(): got %q, want %q", got, want)
+	}
+	if got, want := parsed.PredeclaredNames(), []string{"fail", "len", "proto"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PredeclaredNames(): got %q, want %q", got, want)
+	}
+
+	var calls []string
+	syntax.Walk(parsed.Syntax(), func(n syntax.Node) bool {
+		if call, ok := n.(*syntax.CallExpr); ok {
+			if id, ok := call.Fn.(*syntax.Ident); ok {
+				calls = append(calls, id.Name)
+			}
+		}
+		return true
+	})
+	if want := []string{"fail", "helper", "len"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls in syntax tree: got %q, want %q", calls, want)
+	}
+
+	_, err = skycfg.Parse(context.Background(), "undefined.sky", skycfg.WithFileReader(loader))
+	if want := "undefined: undefined_name"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Parse with undefined name: got error %v, want %q", err, want)
+	}
+	_, err = skycfg.Parse(context.Background(), "missing.sky", skycfg.WithFileReader(loader))
+	var loadErr *skycfg.LoadError
+	if !errors.As(err, &loadErr) {
+		t.Errorf("Parse with missing file: got error %v, want a LoadError", err)
+	}
+}
+
+func TestSkycfgDisabledBuiltins(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"hash.sky":   `hash.md5("a")`,
+		"url.sky":    `url.encode_query({"a": "b"})`,
+		"struct.sky": `struct(a = 1)`,
+		"proto.sky":  `proto.package("google.protobuf")`,
+	}}
+	load := func(filename string, disabled ...string) error {
+		_, err := skycfg.Load(context.Background(), filename,
+			skycfg.WithFileReader(loader),
+			skycfg.WithDisabledBuiltins(disabled),
+		)
+		return err
+	}
+
+	tests := []struct {
+		filename string
+		disabled []string
+		wantErr  string
+	}{
+		{"hash.sky", []string{"hash"}, "hash.md5: disabled"},
+		{"url.sky", []string{"url.encode_query"}, "url.encode_query: disabled"},
+		{"struct.sky", []string{"struct"}, "struct: disabled"},
+		{"proto.sky", []string{"hash", "url"}, ""},
+		{"hash.sky", []string{"hash.md5"}, "hash.md5: disabled"},
+		{"hash.sky", []string{"hash.sha1"}, ""},
+		{"hash.sky", []string{"nosuchmodule"}, `WithDisabledBuiltins: unknown builtin "nosuchmodule"`},
+		{"hash.sky", []string{"hash.nosuchfn"}, `WithDisabledBuiltins: unknown builtin "hash.nosuchfn"`},
+		{"hash.sky", []string{"struct.nosuchfn"}, `WithDisabledBuiltins: unknown builtin "struct.nosuchfn"`},
+	}
+	for _, test := range tests {
+		err := load(test.filename, test.disabled...)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s with %v disabled: %v", test.filename, test.disabled, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s with %v disabled: got error %v, want %q", test.filename, test.disabled, err, test.wantErr)
+		}
+	}
+
+	// Loading again without the option is unaffected.
+	if err := load("hash.sky"); err != nil {
+		t.Errorf("hash.sky after disabling: %v", err)
+	}
+}
+
+func TestSkycfgModules(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"lib.sky": `
+region = internal.region()
+`,
+		"main.sky": `
+load("lib.sky", "region")
+value = "%s/%s" % (region, teams.owner)
+`,
+	}}
+	internal := &starlarkstruct.Module{
+		Name: "internal",
+		Members: starlark.StringDict{
+			"region": starlark.NewBuiltin("internal.region", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+				return starlark.String("us-west-2"), nil
+			}),
+		},
+	}
+	teams := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"owner": starlark.String("infra"),
+	})
+	config, err := skycfg.Load(context.Background(), "main.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithModules(starlark.StringDict{"internal": internal}),
+		skycfg.WithModules(map[string]starlark.Value{"teams": teams}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Locals()["value"], starlark.String("us-west-2/infra"); got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+
+	for _, test := range []struct {
+		name    string
+		opts    []skycfg.LoadOption
+		wantErr string
+	}{
+		{
+			name:    "built-in module",
+			opts:    []skycfg.LoadOption{skycfg.WithModules(starlark.StringDict{"proto": internal})},
+			wantErr: `WithModules: module "proto" conflicts with a built-in global`,
+		},
+		{
+			name:    "built-in function",
+			opts:    []skycfg.LoadOption{skycfg.WithModules(starlark.StringDict{"struct": internal})},
+			wantErr: `WithModules: module "struct" conflicts with a built-in global`,
+		},
+		{
+			name: "registered twice",
+			opts: []skycfg.LoadOption{
+				skycfg.WithModules(starlark.StringDict{"internal": internal}),
+				skycfg.WithModules(starlark.StringDict{"internal": teams}),
+			},
+			wantErr: `WithModules: module "internal" is already registered`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := skycfg.Load(context.Background(), "main.sky", append(test.opts, skycfg.WithFileReader(loader))...)
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSkycfgEntryPointGlobals(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"lib.sky": `
+lib_tenant = tenant
+`,
+		"a.sky": `
+load("lib.sky", "lib_tenant")
+value = "%s/%s/%s" % (tenant, lib_tenant, region)
+`,
+		"b.sky": `
+value = "%s/%s" % (tenant, region)
+`,
+		"c.sky": `
+value = "%s/%s" % (tenant, region)
+`,
+	}}
+	// The same options are used for every config.
+	opts := []skycfg.LoadOption{
+		skycfg.WithFileReader(loader),
+		skycfg.WithEntryPointGlobals("a.sky", starlark.StringDict{"tenant": starlark.String("alpha")}),
+		skycfg.WithGlobals(starlark.StringDict{
+			"tenant": starlark.String("default"),
+			"region": starlark.String("us"),
+		}),
+		skycfg.WithEntryPointGlobals("b.sky", starlark.StringDict{"tenant": starlark.String("beta")}),
+		skycfg.WithEntryPointGlobals("b.sky", starlark.StringDict{"region": starlark.String("eu")}),
+	}
+	for _, test := range []struct {
+		filename string
+		want     string
+	}{
+		{"a.sky", "alpha/alpha/us"},
+		{"b.sky", "beta/eu"},
+		{"c.sky", "default/us"},
+	} {
+		config, err := skycfg.Load(context.Background(), test.filename, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.Locals()["value"]; got != starlark.String(test.want) {
+			t.Errorf("%s: got value %v, want %q", test.filename, got, test.want)
+		}
+	}
+}
+
+func TestSkycfgEnv(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"main.sky": `
+cluster = env.get("CLUSTER", required = True)
+
+def main(ctx):
+  return [proto.package("skycfg.test_proto").MessageV3(f_string = "%s/%s" % (cluster, env.get("REGION", "none")))]
+`,
+	}}
+	// Variables of the process aren't visible.
+	os.Setenv("REGION", "process")
+	defer os.Unsetenv("REGION")
+
+	config, err := skycfg.Load(context.Background(), "main.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithEnv(map[string]string{"CLUSTER": "ord1"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := config.Main(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msgs[0].(*pb.MessageV3).GetFString(), "ord1/none"; got != want {
+		t.Errorf("got f_string %q, want %q", got, want)
+	}
+
+	_, err = skycfg.Load(context.Background(), "main.sky", skycfg.WithFileReader(loader))
+	if want := `env.get: environment variable "CLUSTER" is not set`; err == nil || err.Error() != want {
+		t.Errorf("missing variable: got error %v, want %q", err, want)
+	}
+}
+
+func TestSkycfgLazyGlobals(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"lib.sky": `
+lib_value = lazy_value
+`,
+		"main.sky": `
+load("lib.sky", "lib_value")
+value = lib_value + lazy_value
+`,
+		"undefined.sky": `
+value = undefined_value
+`,
+		"broken.sky": `
+value = broken_value
+`,
+	}}
+	var lookups []string
+	lazyGlobals := func(name string) (starlark.Value, bool, error) {
+		lookups = append(lookups, name)
+		switch name {
+		case "lazy_value":
+			return starlark.String("lazy"), true, nil
+		case "broken_value":
+			return nil, false, fmt.Errorf("not available")
+		}
+		return nil, false, nil
+	}
+	load := func(filename string) (*skycfg.Config, error) {
+		return skycfg.Load(context.Background(), filename,
+			skycfg.WithFileReader(loader),
+			skycfg.WithLazyGlobals(lazyGlobals),
+		)
+	}
+
+	config, err := load("main.sky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Locals()["value"], starlark.String("lazylazy"); got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+	if want := []string{"lazy_value"}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("lookups: got %q, want %q", lookups, want)
+	}
+
+	_, err = load("undefined.sky")
+	if want := "undefined.sky:2:9: undefined: undefined_value"; err == nil || err.Error() != want {
+		t.Errorf("undefined global: got error %v, want %q", err, want)
+	}
+	_, err = load("broken.sky")
+	if want := `broken.sky: global "broken_value": not available`; err == nil || err.Error() != want {
+		t.Errorf("broken global: got error %v, want %q", err, want)
+	}
+}
+
+// testTestCase is a test case for the testing functionality built into skycfg
+type testTestCase struct {
+	errors     bool
+	passes     bool
+	failureMsg string
+}
+
+func TestSkycfgProtoRegistries(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"main.sky": `
+pb = proto.package("skycfg.test_proto")
+
+def main(ctx):
+  return [pb.MessageV3(f_string = "a"), pb.MessageV2()]
+`,
+	}}
+
+	// Both registries have a MessageV3, using different Go types.
+	dynamic := &protoregistry.Types{}
+	if err := dynamic.RegisterMessage(dynamicpb.NewMessageType((&pb.MessageV3{}).ProtoReflect().Descriptor())); err != nil {
+		t.Fatal(err)
+	}
+	generated := &protoregistry.Types{}
+	for _, msg := range []proto.Message{&pb.MessageV2{}, &pb.MessageV3{}} {
+		if err := generated.RegisterMessage(msg.ProtoReflect().Type()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(regs ...*protoregistry.Types) []proto.Message {
+		t.Helper()
+		opts := []skycfg.LoadOption{skycfg.WithFileReader(loader)}
+		for _, r := range regs {
+			opts = append(opts, skycfg.WithProtoRegistry(skycfg.NewUnstableProtobufRegistryV2(r)))
+		}
+		config, err := skycfg.Load(context.Background(), "main.sky", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs, err := config.Main(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msgs
+	}
+
+	msgs := run(dynamic, generated)
+	if _, ok := msgs[0].(*dynamicpb.Message); !ok {
+		t.Errorf("first registry: got MessageV3 of type %T, want *dynamicpb.Message", msgs[0])
+	}
+	if _, ok := msgs[1].(*pb.MessageV2); !ok {
+		t.Errorf("second registry: got MessageV2 of type %T, want *pb.MessageV2", msgs[1])
+	}
+
+	msgs = run(generated, dynamic)
+	if _, ok := msgs[0].(*pb.MessageV3); !ok {
+		t.Errorf("reversed order: got MessageV3 of type %T, want *pb.MessageV3", msgs[0])
+	}
+}
+
+func TestSkycfgFileDescriptorSet(t *testing.T) {
+	// The importing file comes first, to check that imports are resolved
+	// regardless of the order of files in the set.
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		{
+			Name:       proto.String("outer.proto"),
+			Package:    proto.String("dynamic"),
+			Dependency: []string{"inner.proto"},
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Outer"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("inner"),
+					JsonName: proto.String("inner"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".dynamic.Inner"),
+				}},
+			}},
+			Syntax: proto.String("proto3"),
+		},
+		{
+			Name:    proto.String("inner.proto"),
+			Package: proto.String("dynamic"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("name"),
+					JsonName: proto.String("name"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				}},
+			}},
+			Syntax: proto.String("proto3"),
+		},
+	}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loader := &virtualLoader{files: map[string]string{
+		"main.sky": `
+dynamic = proto.package("dynamic")
+test_proto = proto.package("skycfg.test_proto")
+
+def main(ctx):
+  return [
+    dynamic.Outer(inner = dynamic.Inner(name = "a")),
+    test_proto.MessageV3(f_string = "b"),
+  ]
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "main.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithFileDescriptorSet(data),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := config.Main(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	if _, ok := msgs[0].(*dynamicpb.Message); !ok {
+		t.Errorf("got message of type %T, want *dynamicpb.Message", msgs[0])
+	}
+	if got, want := prototext.Format(msgs[0]), `inner:{name:"a"}`; strings.Join(strings.Fields(got), "") != want {
+		t.Errorf("dynamic message: got %q, want %q", got, want)
+	}
+	if _, ok := msgs[1].(*pb.MessageV3); !ok {
+		t.Errorf("got message of type %T, want *pb.MessageV3", msgs[1])
+	}
+
+	// An import that isn't in the set or linked into the binary.
+	set.File = set.File[:1]
+	data, err = proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = skycfg.Load(context.Background(), "main.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithFileDescriptorSet(data),
+	)
+	want := `WithFileDescriptorSet: file "inner.proto" imported by "outer.proto" not found`
+	if err == nil || err.Error() != want {
+		t.Errorf("missing import: got error %v, want %q", err, want)
+	}
+}
+
+func TestSkycfgValidateRequired(t *testing.T) {
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    label.Enum(),
+			Type:     typ.Enum(),
+		}
+	}
+	required := descriptorpb.FieldDescriptorProto_LABEL_REQUIRED
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	inner := field("inner", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	inner.TypeName = proto.String(".dynamic.Inner")
+	items := field("items", 3, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	items.TypeName = proto.String(".dynamic.Inner")
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("required.proto"),
+		Package: proto.String("dynamic"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Outer"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, required, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					inner,
+					items,
+				},
+			},
+			{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, required, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+				},
+			},
+		},
+		Syntax: proto.String("proto2"),
+	}}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loader := &virtualLoader{files: map[string]string{
+		"main.sky": `
+dynamic = proto.package("dynamic")
+
+def complete(ctx):
+  return [dynamic.Outer(name = "a", inner = dynamic.Inner(id = 1))]
+
+def incomplete(ctx):
+  return [
+    dynamic.Outer(),
+    dynamic.Outer(name = "a"),
+    dynamic.Outer(inner = dynamic.Inner(), items = [dynamic.Inner(id = 1), dynamic.Inner()]),
+  ]
+
+def named(ctx):
+  return {"web": [dynamic.Outer(name = "a", inner = dynamic.Inner())]}
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "main.sky",
+		skycfg.WithFileReader(loader),
+		skycfg.WithFileDescriptorSet(data),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := config.Main(context.Background(), skycfg.WithEntryPoint("incomplete")); err != nil {
+		t.Errorf("without validation: unexpected error %v", err)
+	}
+	if _, err := config.Main(context.Background(), skycfg.WithEntryPoint("complete"), skycfg.WithValidateRequired(true)); err != nil {
+		t.Errorf("complete: unexpected error %v", err)
+	}
+
+	_, err = config.Main(context.Background(), skycfg.WithEntryPoint("incomplete"), skycfg.WithValidateRequired(true))
+	want := `"incomplete" returned invalid messages: ` +
+		`message 0 (dynamic.Outer) has unset required fields: name; ` +
+		`message 2 (dynamic.Outer) has unset required fields: name, inner.id, items[1].id`
+	if err == nil || err.Error() != want {
+		t.Errorf("incomplete: got error %v, want %q", err, want)
+	}
+
+	_, err = config.MainNamed(context.Background(), skycfg.WithEntryPoint("named"), skycfg.WithValidateRequired(true))
+	want = `"named" returned invalid messages: message 0 of output "web" (dynamic.Outer) has unset required fields: inner.id`
+	if err == nil || err.Error() != want {
+		t.Errorf("named: got error %v, want %q", err, want)
+	}
+}
+
+func TestSkycfgMessageValidator(t *testing.T) {
+	loader := &virtualLoader{files: map[string]string{
+		"main.sky": `
+test_proto = proto.package("skycfg.test_proto")
+
+def main(ctx):
+  return [
+    test_proto.MessageV3(f_string = "a", f_int64 = 1),
+    test_proto.MessageV3(f_string = "b"),
+    test_proto.MessageV2(),
+    test_proto.MessageV3(),
+  ]
+
+def named(ctx):
+  return {
+    "web": [test_proto.MessageV3(f_string = "a", f_int64 = 1)],
+    "db": [test_proto.MessageV3(f_string = "b")],
+    "cache": [test_proto.MessageV3(), test_proto.MessageV3(f_string = "c")],
+  }
+`,
+	}}
+	config, err := skycfg.Load(context.Background(), "main.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// f_string requires f_int64.
+	pair := skycfg.WithMessageValidator(func(msg proto.Message) error {
+		if msg, ok := msg.(*pb.MessageV3); ok && msg.GetFString() != "" && msg.GetFInt64() == 0 {
+			return errors.New("f_string is set without f_int64")
+		}
+		return nil
+	})
+	// Every message must be a MessageV3.
+	v3 := skycfg.WithMessageValidator(func(msg proto.Message) error {
+		if _, ok := msg.(*pb.MessageV3); !ok {
+			return fmt.Errorf("unexpected message type")
+		}
+		return nil
+	})
+	var seen int
+	count := skycfg.WithMessageValidator(func(msg proto.Message) error {
+		seen++
+		return nil
+	})
+
+	if _, err := config.Main(context.Background(), count); err != nil {
+		t.Fatalf("count: unexpected error %v", err)
+	}
+	if seen != 4 {
+		t.Errorf("count: validator called %d times, want 4", seen)
+	}
+
+	_, err = config.Main(context.Background(), pair, v3)
+	want := `"main" returned invalid messages: ` +
+		`message 1 (skycfg.test_proto.MessageV3): f_string is set without f_int64; ` +
+		`message 2 (skycfg.test_proto.MessageV2): unexpected message type`
+	if err == nil || err.Error() != want {
+		t.Errorf("main: got error %v, want %q", err, want)
+	}
+
+	_, err = config.MainNamed(context.Background(), skycfg.WithEntryPoint("named"), pair)
+	want = `"named" returned invalid messages: ` +
+		`message 0 of output "db" (skycfg.test_proto.MessageV3): f_string is set without f_int64; ` +
+		`message 1 of output "cache" (skycfg.test_proto.MessageV3): f_string is set without f_int64`
+	if err == nil || err.Error() != want {
+		t.Errorf("named: got error %v, want %q", err, want)
+	}
+}
+
+func TestSkycfgTesting(t *testing.T) {
+	loader := &testLoader{}
+	ctx := context.Background()
+
+	config, err := skycfg.Load(ctx, "test1.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Error("Unexpected error loading test1.sky", err)
+	}
+
+	cases := map[string]testTestCase{
+		"test_helper1": testTestCase{
+			passes: true,
+		},
+		"test_helper2": testTestCase{
+			passes: true,
+		},
+		"test_helper2_fails": testTestCase{
+			passes:     false,
+			failureMsg: "assertion failed",
+		},
+		"test_helper2_errors": testTestCase{
+			errors: true,
+		},
+		"test_main": testTestCase{
+			passes: true,
+		},
+	}
+
+	tests := config.Tests()
+	if len(tests) != len(cases) {
+		t.Error("Expected %d tests but found %d", len(cases), len(tests))
+	}
+
+	for _, test := range tests {
+		result, err := test.Run(ctx, skycfg.WithTestVars(starlark.StringDict{
+			"var_key": starlark.String("var_value"),
+		}))
+		testCase, ok := cases[test.Name()]
+		if !ok {
+			t.Error("Could not find test case for test", test.Name())
+			continue
+		}
+
+		if (err != nil) != testCase.errors {
+			t.Errorf(
+				"[%s] Execution result (error: %t) did not equal expected execution result (error: %t). err: %s",
+				test.Name(),
+				err != nil,
+				testCase.errors,
+				err,
+			)
+			continue
+		}
+
+		// if the execution errors, the result is nil and theres nothing else to check
+		if err != nil {
+			continue
+		}
+
+		if result.TestName != test.Name() {
+			t.Errorf("TestResult (%s) and Test (%s) should have the same name", result.TestName, test.Name())
+			continue
+		}
+
+		if (result.Failure == nil) != testCase.passes {
+			t.Errorf(
+				"[%s] Test result (pass: %t) did not equal expected test result (pass: %t)",
+				test.Name(),
+				result.Failure == nil,
+				testCase.passes,
+			)
+			continue
+		}
+
+		if !testCase.passes {
+			// check the error message
+			if !strings.Contains(result.Failure.Error(), testCase.failureMsg) {
+				t.Errorf(
+					"[%s] Expected %s to be in failure message, but instead found %s",
+					test.Name(),
+					testCase.failureMsg,
+					result.Failure.Error(),
+				)
+				continue
+			}
+		}
+	}
+}
+
+func TestSkycfgRunTests(t *testing.T) {
+	loader := &testLoader{}
+	ctx := context.Background()
+
+	config, err := skycfg.Load(ctx, "test1.sky", skycfg.WithFileReader(loader))
+	if err != nil {
+		t.Fatal("Unexpected error loading test1.sky", err)
+	}
+
+	results := skycfg.RunTests(ctx, config, skycfg.WithTestVars(starlark.StringDict{
+		"var_key": starlark.String("var_value"),
+	}))
+	var got []string
+	for _, result := range results {
+		status := "pass"
+		if result.Failure != nil {
+			status = fmt.Sprintf("fail at %s", result.Position)
+		}
+		got = append(got, fmt.Sprintf("%s: %s", result.TestName, status))
+	}
+	expected := []string{
+		"test_helper2: pass",
+		"test_helper2_errors: fail at test2.sky:29:3",
+		"test_helper2_fails: fail at test2.sky:26:10",
+		"test_helper1: pass",
+		"test_main: pass",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Bad results from RunTests\nExpected: %q\nGot: %q", expected, got)
+	}
+}
+
+func TestSkycfgYamlProto(t *testing.T) {
+	src := `
+pb = proto.package("skycfg.test_proto")
+msg = pb.MessageV3(
+    f_int64 = 1234567890123,
+    f_string = "hello",
+    f_submsg = pb.MessageV3(f_bool = True),
+    r_string = ["a", "b"],
+    map_string = {"k": "v"},
+    f_nested_submsg = pb.MessageV3.NestedMessage(f_string = "nested"),
+)
+`
+	thread := new(starlark.Thread)
+	globals := skycfg.UnstablePredeclaredModules(nil)
+	env, err := starlark.ExecFile(thread, "test.sky", src, globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range globals {
+		env[k] = v
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{
+			expr: `yaml.encode(msg)`,
+			want: `"f_int64: \"1234567890123\"\nf_nested_submsg:\n  f_string: nested\nf_string: hello\nf_submsg:\n  f_bool: true\nmap_string:\n  k: v\nr_string:\n  - a\n  - b\n"`,
+		},
+		{
+			expr: `yaml.encode({"msgs": [pb.MessageV3(f_string = "x")]})`,
+			want: `"msgs:\n  - f_string: x\n"`,
+		},
+		{
+			expr: `yaml.encode(pb.MessageV3())`,
+			want: `"{}\n"`,
+		},
+		{
+			expr: `yaml.decode(yaml.encode(msg)) == json.decode(proto.encode_json(msg))`,
+			want: `True`,
+		},
+		{
+			expr: `proto.decode_json(pb.MessageV3, json.encode(yaml.decode(yaml.encode(msg)))) == msg`,
+			want: `True`,
+		},
+		{
+			expr: `json.encode({"msgs": [pb.MessageV3(f_string = "x")]})`,
+			want: `"{\"msgs\":[{\"f_string\":\"x\"}]}"`,
+		},
+		{
+			expr: `json.unmarshal(json.marshal(msg)) == json.decode(proto.encode_json(msg))`,
+			want: `True`,
+		},
+	}
+	for _, test := range tests {
+		val, err := starlark.Eval(thread, "<expr>", test.expr, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.expr, err)
+			continue
+		}
+		if got := val.String(); got != test.want {
+			t.Errorf("%s:\nExpected: %s\nGot     : %s", test.expr, test.want, got)
 		}
 	}
 }